@@ -54,7 +54,13 @@ def build():
             output = subprocess.check_output(['ld', '-ljpeg', '--trace-symbol', 'jpeg_CreateDecompress', '-e', '0'], stderr=subprocess.STDOUT)
             libjpg = output.decode().split(':')[0]
         except (subprocess.CalledProcessError, OSError):
-            raise BuildException("Could not find libjpeg. HINT: try 'sudo apt-get install libjpeg-turbo8-dev' on Ubuntu or 'brew install libjpeg-turbo' on OSX")
+            pass
+
+    # Without libjpeg, fall back to Go's own (slower) JPEG decoder
+    tags = []
+    if not libjpg:
+        eprint("Could not find libjpeg, so building with Go's slower JPEG decoder. HINT: try 'sudo apt-get install libjpeg-turbo8-dev' on Ubuntu or 'brew install libjpeg-turbo' on OSX")
+        tags.append('no_libjpeg')
 
     numpy_include = numpy.get_include()
     py_include = distutils.sysconfig.get_python_inc()
@@ -81,10 +87,15 @@ def build():
           raise BuildException('Could not parse LIBRARY: {}'.format(library))
         ldflags = '-L{} -l{}'.format(sysconfig.get_config_var('LIBDIR'), match.group(1))
 
-    env['CGO_LDFLAGS'] = ' '.join([libjpg, ldflags])
+    env['CGO_LDFLAGS'] = ' '.join(([libjpg] if libjpg else []) + [ldflags])
+
+    def go_build(*extra_tags):
+        all_tags = tags + list(extra_tags)
+        flags = '-tags {} '.format(','.join(all_tags)) if all_tags else ''
+        return 'go build {}-buildmode=c-shared -o go_vncdriver.so github.com/openai/go-vncdriver'.format(flags)
 
     def build_no_gl():
-        cmd = 'go build -tags no_gl -buildmode=c-shared -o go_vncdriver.so github.com/openai/go-vncdriver'
+        cmd = go_build('no_gl')
         eprint('Building without OpenGL: GOPATH={} {}'.format(os.getenv('GOPATH'), cmd))
         if subprocess.call(cmd.split()):
             raise BuildException('''
@@ -95,7 +106,7 @@ Build failed. HINT:
 ''')
 
     def build_gl():
-        cmd = 'go build -buildmode=c-shared -o go_vncdriver.so github.com/openai/go-vncdriver'
+        cmd = go_build()
         eprint('Building with OpenGL: GOPATH={} {}. (Set GO_VNCDRIVER_NOGL to build without OpenGL.)'.format(os.getenv('GOPATH'), cmd))
         return not subprocess.call(cmd.split())
 
@@ -21,6 +21,29 @@ var (
 	out = flag.String("out", "", "path to output file")
 )
 
+// outFormat is the pixel format transcoded files are in, whatever the
+// recording's was: 32-bit little-endian BGRX.
+var outFormat = vncclient.PixelFormat{
+	BPP:        32,
+	Depth:      24,
+	TrueColor:  true,
+	RedMax:     255,
+	GreenMax:   255,
+	BlueMax:    255,
+	RedShift:   16,
+	GreenShift: 8,
+}
+
+// appendColors appends colors to b in outFormat.
+func appendColors(b []byte, colors []vncclient.Color) []byte {
+	for _, c := range colors {
+		b = append(b, c.B, c.G, c.R, 0)
+	}
+	return b
+}
+
+// cursorEncoding holds a cursor's pixels, already in outFormat, and
+// its bitmask.
 type cursorEncoding struct {
 	b []byte
 }
@@ -34,10 +57,16 @@ func (e *cursorEncoding) Size() int {
 }
 
 func (e *cursorEncoding) Read(c *vncclient.ClientConn, rect *vncclient.Rectangle, r io.Reader) (vncclient.Encoding, error) {
-	sz := rect.Area()*int(c.PixelFormat.BPP)/8 + (int(rect.Width+7)/8)*int(rect.Height)
-	b := make([]byte, sz)
-	_, err := io.ReadFull(r, b)
-	return &cursorEncoding{b}, err
+	// The pixels are as a Raw rectangle's would be
+	enc, err := new(vncclient.RawEncoding).Read(c, rect, r)
+	if err != nil {
+		return nil, err
+	}
+	b := appendColors(make([]byte, 0, rect.Area()*4), enc.(*vncclient.RawEncoding).Colors)
+
+	mask := make([]byte, (int(rect.Width+7)/8)*int(rect.Height))
+	_, err = io.ReadFull(r, mask)
+	return &cursorEncoding{append(b, mask...)}, err
 }
 
 type fbsReader struct {
@@ -235,21 +264,17 @@ func main() {
 	b := next(24)
 	var pf vncclient.PixelFormat
 	check(vncclient.ReadPixelFormat(bytes.NewReader(b[4:20]), &pf))
-	if !(pf.BPP == 32 &&
-		pf.Depth == 24 &&
-		!pf.BigEndian &&
-		pf.TrueColor &&
-		pf.RedMax == 255 &&
-		pf.GreenMax == 255 &&
-		pf.BlueMax == 255 &&
-		pf.RedShift == 16 &&
-		pf.GreenShift == 8 &&
-		pf.BlueShift == 0) {
+	if pf.BPP != 8 && pf.BPP != 16 && pf.BPP != 32 {
 		log.Fatalf("Unsupported pixel format: %#v\n", pf)
 	}
+	// Everything we write out is in outFormat instead
+	outPF, err := vncclient.WritePixelFormat(&outFormat)
+	check(err)
+	copy(b[4:20], outPF)
 	emit(append(b, next(int(bytes2Uint32(b[20:24])))...))
 
 	var fbu vncclient.FramebufferUpdateMessage
+	var pixels []byte
 	conn := &vncclient.ClientConn{
 		Encs: []vncclient.Encoding{
 			new(vncclient.TightEncoding),
@@ -271,7 +296,8 @@ func main() {
 				return
 			}
 			check(err)
-			rects := msg.(*vncclient.FramebufferUpdateMessage).Rectangles
+			update := msg.(*vncclient.FramebufferUpdateMessage)
+			rects := update.Rectangles
 
 			// Start counting how many bytes we're going to write
 			size := 0
@@ -319,24 +345,20 @@ func main() {
 					check(w.Write(r.Enc.(*cursorEncoding).b))
 				}
 
-				for _, c := range colors {
-					colorOrder := (uint32(c.R) << conn.PixelFormat.RedShift) +
-						(uint32(c.G) << conn.PixelFormat.GreenShift) +
-						(uint32(c.B) << conn.PixelFormat.BlueShift)
-
-					// Write the RGB bytes out in little-endian order,
-					// having verified "!pf.BigEndian" above
-					check(w.Write([]byte{uint8(colorOrder),
-						uint8(colorOrder >> 8),
-						uint8(colorOrder >> 16),
-						0}))
-				}
+				pixels = appendColors(pixels[:0], colors)
+				check(w.Write(pixels))
 			}
+			update.Release()
 			check(w.Write(r.timestamp[:]))
 
-		// SetColorMapEntries
+		// SetColorMapEntries, which isn't written out since we write
+		// colors already looked up in the color map
 		case 1:
-			log.Fatal("SetColorMapEntries not supported")
+			_, err := new(vncclient.SetColorMapEntriesMessage).Read(conn, r)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			check(err)
 		// Bell
 		case 2:
 			emit(b)
@@ -15,6 +15,15 @@ func forwardCopy(mem []byte, dst, src, n int) {
 		copy(mem[dst:dst+n], mem[src:src+n])
 		return
 	}
+	if n <= 16 && dst < src+n {
+		// A short run of a short pattern is quicker byte by byte
+		// than with a copy for each repeat.
+		out, in := mem[dst:dst+n], mem[src:src+n]
+		for i := range out {
+			out[i] = in[i]
+		}
+		return
+	}
 	for {
 		if dst >= src+n {
 			copy(mem[dst:dst+n], mem[src:src+n])
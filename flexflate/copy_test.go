@@ -5,6 +5,7 @@
 package flexflate
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -52,3 +53,47 @@ func TestForwardCopy(t *testing.T) {
 		}
 	}
 }
+
+func TestForwardCopy_Runs(t *testing.T) {
+	for dist := 1; dist < 40; dist++ {
+		for n := 0; n < 300; n += 1 + n/8 {
+			got := make([]byte, 400)
+			for i := range got {
+				got[i] = byte(i)
+			}
+			want := append([]byte(nil), got...)
+			for i := 0; i < n; i++ {
+				want[50+i] = want[50-dist+i]
+			}
+			forwardCopy(got, 50, 50-dist, n)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("copying %d bytes from %d back: got %v, want %v", n, dist, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkForwardCopy(b *testing.B) {
+	for _, tc := range []struct {
+		name      string
+		dist, len int
+	}{
+		{"Short", 300, 5},
+		{"Long", 300, 258},
+		{"RunOf1", 1, 258},
+		{"RunOf3", 3, 258},
+		{"RunOf4", 4, 258},
+		{"ShortRunOf3", 3, 12},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			mem := make([]byte, 1024)
+			for i := range mem {
+				mem[i] = byte(i)
+			}
+			b.SetBytes(int64(tc.len))
+			for i := 0; i < b.N; i++ {
+				forwardCopy(mem, 400, 400-tc.dist, tc.len)
+			}
+		})
+	}
+}
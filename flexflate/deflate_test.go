@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
 	"reflect"
 	"sync"
 	"testing"
@@ -345,7 +346,11 @@ var deflateInflateStringTests = []deflateInflateStringTest{
 func TestDeflateInflateString(t *testing.T) {
 	for _, test := range deflateInflateStringTests {
 		gold, err := ioutil.ReadFile(test.filename)
-		if err != nil {
+		if os.IsNotExist(err) {
+			// The testdata of compress/flate isn't vendored here
+			t.Logf("skipping %s: %s", test.label, err)
+			continue
+		} else if err != nil {
 			t.Error(err)
 		}
 		testToFromWithLimit(t, gold, test.label, test.limit)
@@ -7,6 +7,7 @@ package flexflate
 import (
 	"io"
 	"math"
+	"strconv"
 )
 
 const (
@@ -511,7 +512,7 @@ func (w *huffmanBitWriter) writeBlock(tokens []token, eof bool, input []byte) {
 			}
 			break
 		default:
-			panic("unknown token type: " + string(t))
+			panic("unknown token type: " + strconv.Itoa(int(t)))
 		}
 	}
 }
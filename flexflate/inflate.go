@@ -11,6 +11,7 @@ package flexflate
 
 import (
 	"bufio"
+	"encoding/binary"
 	"io"
 	"strconv"
 )
@@ -22,6 +23,10 @@ const (
 	maxLit   = 286
 	maxDist  = 32
 	numCodes = 19 // number of codes in Huffman meta-code
+
+	// Input read ahead of the bit buffer, for Readers that say how much
+	// they hold.
+	inputSize = 4096
 )
 
 // A CorruptInputError reports the presence of corrupt input at a given offset.
@@ -101,9 +106,10 @@ type huffmanDecoder struct {
 }
 
 // Initialize Huffman decoding tables from array of code lengths.
+// The link tables of the last call are reused where they're big enough.
 func (h *huffmanDecoder) init(bits []int) bool {
 	if h.min != 0 {
-		*h = huffmanDecoder{}
+		*h = huffmanDecoder{links: h.links[:0]}
 	}
 
 	// Count number of codes of each length,
@@ -143,13 +149,24 @@ func (h *huffmanDecoder) init(bits []int) bool {
 			if huffmanNumChunks < link {
 				return false
 			}
-			h.links = make([][]uint32, huffmanNumChunks-link)
+			if cap(h.links) < huffmanNumChunks-link {
+				h.links = make([][]uint32, huffmanNumChunks-link, huffmanNumChunks)
+			}
+			h.links = h.links[:huffmanNumChunks-link]
 			for j := uint(link); j < huffmanNumChunks; j++ {
 				reverse := int(reverseByte[j>>8]) | int(reverseByte[j&0xff])<<8
 				reverse >>= uint(16 - huffmanChunkBits)
 				off := j - uint(link)
 				h.chunks[reverse] = uint32(off<<huffmanValueShift + uint(i))
-				h.links[off] = make([]uint32, 1<<linkBits)
+				if linktab := h.links[off]; cap(linktab) >= numLinks {
+					h.links[off] = linktab[:numLinks]
+					for k := range h.links[off] {
+						h.links[off][k] = 0
+					}
+				} else {
+					// Big enough to be reused for any code
+					h.links[off] = make([]uint32, numLinks, 1<<(maxCodeLen-1-huffmanChunkBits))
+				}
 			}
 		}
 		n := count[i]
@@ -186,6 +203,34 @@ func (h *huffmanDecoder) init(bits []int) bool {
 	return true
 }
 
+// A pairTable decodes two literals at once, wherever both their codes
+// fit in its pairBits-wide index. An entry has the first literal in its
+// bottom byte, the second in the next, and how many bits they take up
+// above that, or is 0 where the next two codes aren't both short
+// literals.
+type pairTable [1 << pairBits]uint32
+
+const pairBits = 10
+
+// init fills in t from h's lookup table. As with that, a code can be
+// looked up with the bits after it, if there are fewer, set to anything.
+func (t *pairTable) init(h *huffmanDecoder) {
+	for i := range t {
+		t[i] = 0
+		first := h.chunks[i&(huffmanNumChunks-1)]
+		n1 := uint(first & huffmanCountMask)
+		if n1 == 0 || n1 > huffmanChunkBits || first>>huffmanValueShift >= 256 {
+			continue
+		}
+		second := h.chunks[(i>>n1)&(huffmanNumChunks-1)]
+		n2 := uint(second & huffmanCountMask)
+		if n2 == 0 || n2 > huffmanChunkBits || n1+n2 > pairBits || second>>huffmanValueShift >= 256 {
+			continue
+		}
+		t[i] = first>>huffmanValueShift | second>>huffmanValueShift<<8 | uint32(n1+n2)<<16
+	}
+}
+
 // The actual read interface needed by NewReader.
 // If the passed in io.Reader does not also have ReadByte,
 // the NewReader will introduce its own buffering.
@@ -194,19 +239,34 @@ type Reader interface {
 	io.ByteReader
 }
 
+// A lenReader is a Reader, like *bytes.Reader or *bytes.Buffer, that
+// can say how many bytes it has left to read without blocking.
+type lenReader interface {
+	Reader
+	Len() int
+}
+
 // Decompress state.
 type decompressor struct {
 	// Input source.
 	r       Reader
+	lr      lenReader // r, if it's a lenReader
 	roffset int64
 	woffset int64
 
-	// Input bits, in top of b.
-	b  uint32
+	// Input read ahead from lr, yet to go in b.
+	input [inputSize]byte
+	in    []byte
+
+	// Input bits, in bottom of b. The bits above nb are either zero or
+	// the ones that are next in the input, so it can be added to with |.
+	b  uint64
 	nb uint
 
 	// Huffman decoders for literal/length, distance.
 	h1, h2 huffmanDecoder
+	// Pairs of literals of h1, when it's the literal/length decoder.
+	pairs pairTable
 
 	// Length arrays used to define Huffman codes.
 	bits     *[maxLit + maxDist]int
@@ -228,6 +288,7 @@ type decompressor struct {
 	err      error
 	toRead   []byte
 	hl, hd   *huffmanDecoder
+	hp2      *pairTable // pairs of hl, if any
 	copyLen  int
 	copyDist int
 }
@@ -236,11 +297,18 @@ type ReaderSwapper interface {
 	SwapReader(r Reader)
 }
 
+// SwapReader carries on decompressing from r, as if it followed on from
+// the last Reader. Any input read ahead from that is kept.
 func (f *decompressor) SwapReader(r Reader) {
-	f.r = r
+	f.setReader(r)
 	f.err = nil
 }
 
+func (f *decompressor) setReader(r Reader) {
+	f.r = r
+	f.lr, _ = r.(lenReader)
+}
+
 func (f *decompressor) nextBlock() {
 	if f.final {
 		if f.hw != f.hp {
@@ -250,10 +318,8 @@ func (f *decompressor) nextBlock() {
 		f.err = io.EOF
 		return
 	}
-	for f.nb < 1+2 {
-		if f.err = f.moreBits(); f.err != nil {
-			return
-		}
+	if f.err = f.need(1 + 2); f.err != nil {
+		return
 	}
 	f.final = f.b&1 == 1
 	f.b >>= 1
@@ -267,6 +333,7 @@ func (f *decompressor) nextBlock() {
 		// compressed, fixed Huffman tables
 		f.hl = &fixedHuffmanDecoder
 		f.hd = nil
+		f.hp2 = nil
 		f.huffmanBlock()
 	case 2:
 		// compressed, dynamic Huffman tables
@@ -310,10 +377,8 @@ var codeOrder = [...]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2,
 
 func (f *decompressor) readHuffman() error {
 	// HLIT[5], HDIST[5], HCLEN[4].
-	for f.nb < 5+5+4 {
-		if err := f.moreBits(); err != nil {
-			return err
-		}
+	if err := f.need(5 + 5 + 4); err != nil {
+		return err
 	}
 	nlit := int(f.b&0x1F) + 257
 	if nlit > maxLit {
@@ -330,10 +395,8 @@ func (f *decompressor) readHuffman() error {
 
 	// (HCLEN+4)*3 bits: code lengths in the magic codeOrder order.
 	for i := 0; i < nclen; i++ {
-		for f.nb < 3 {
-			if err := f.moreBits(); err != nil {
-				return err
-			}
+		if err := f.need(3); err != nil {
+			return err
 		}
 		f.codebits[codeOrder[i]] = int(f.b & 0x7)
 		f.b >>= 3
@@ -382,12 +445,10 @@ func (f *decompressor) readHuffman() error {
 			nb = 7
 			b = 0
 		}
-		for f.nb < nb {
-			if err := f.moreBits(); err != nil {
-				return err
-			}
+		if err := f.need(nb); err != nil {
+			return err
 		}
-		rep += int(f.b & uint32(1<<nb-1))
+		rep += int(f.b & uint64(1<<nb-1))
 		f.b >>= nb
 		f.nb -= nb
 		if i+rep > n {
@@ -402,6 +463,12 @@ func (f *decompressor) readHuffman() error {
 	if !f.h1.init(f.bits[0:nlit]) || !f.h2.init(f.bits[nlit:nlit+ndist]) {
 		return CorruptInputError(f.roffset)
 	}
+	// Pairs of literals are only worth looking up if they can fit
+	f.hp2 = nil
+	if 2*f.h1.min <= pairBits {
+		f.pairs.init(&f.h1)
+		f.hp2 = &f.pairs
+	}
 
 	return nil
 }
@@ -410,10 +477,39 @@ func (f *decompressor) readHuffman() error {
 // hl and hd are the Huffman states for the lit/length values
 // and the distance values, respectively.  If hd == nil, using the
 // fixed distance encoding associated with fixed Huffman blocks.
+// hp2, if set, decodes pairs of literals of hl.
 func (f *decompressor) huffmanBlock() {
 	for {
-		v, err := f.huffSym(f.hl)
-		if err != nil {
+		// Top up f.b, so that most symbols are decoded without
+		// having to read more
+		if f.nb < 32 {
+			f.fill()
+		}
+		// Two literals at once, if they fit
+		if f.hp2 != nil && f.hp+2 <= len(f.hist) {
+			if pair := f.hp2[f.b&(1<<pairBits-1)]; pair != 0 && uint(pair>>16) <= f.nb {
+				f.hist[f.hp] = byte(pair)
+				f.hist[f.hp+1] = byte(pair >> 8)
+				f.hp += 2
+				f.b >>= pair >> 16
+				f.nb -= uint(pair >> 16)
+				if f.hp == len(f.hist) {
+					f.flush((*decompressor).huffmanBlock)
+					return
+				}
+				continue
+			}
+		}
+		// Look up a symbol whose code is in the first table here, and
+		// leave the rest to huffSym.
+		var v int
+		var err error
+		chunk := f.hl.chunks[f.b&(huffmanNumChunks-1)]
+		if n := uint(chunk & huffmanCountMask); n != 0 && n <= huffmanChunkBits && n <= f.nb {
+			v = int(chunk >> huffmanValueShift)
+			f.b >>= n
+			f.nb -= n
+		} else if v, err = f.huffSym(f.hl); err != nil {
 			f.err = err
 			return
 		}
@@ -457,24 +553,20 @@ func (f *decompressor) huffmanBlock() {
 			n = 0
 		}
 		if n > 0 {
-			for f.nb < n {
-				if err = f.moreBits(); err != nil {
-					f.err = err
-					return
-				}
+			if err = f.need(n); err != nil {
+				f.err = err
+				return
 			}
-			length += int(f.b & uint32(1<<n-1))
+			length += int(f.b & uint64(1<<n-1))
 			f.b >>= n
 			f.nb -= n
 		}
 
 		var dist int
 		if f.hd == nil {
-			for f.nb < 5 {
-				if err = f.moreBits(); err != nil {
-					f.err = err
-					return
-				}
+			if err = f.need(5); err != nil {
+				f.err = err
+				return
 			}
 			dist = int(reverseByte[(f.b&0x1F)<<3])
 			f.b >>= 5
@@ -496,13 +588,11 @@ func (f *decompressor) huffmanBlock() {
 			nb := uint(dist-2) >> 1
 			// have 1 bit in bottom of dist, need nb more.
 			extra := (dist & 1) << nb
-			for f.nb < nb {
-				if err = f.moreBits(); err != nil {
-					f.err = err
-					return
-				}
+			if err = f.need(nb); err != nil {
+				f.err = err
+				return
 			}
-			extra |= int(f.b & uint32(1<<nb-1))
+			extra |= int(f.b & uint64(1<<nb-1))
 			f.b >>= nb
 			f.nb -= nb
 			dist = 1<<(nb+1) + 1 + extra
@@ -520,6 +610,13 @@ func (f *decompressor) huffmanBlock() {
 			return
 		}
 
+		// Copy straight into f.hist if it fits without wrapping
+		// around, and won't fill it.
+		if p := f.hp - dist; p >= 0 && f.hp+length < len(f.hist) {
+			forwardCopy(f.hist[:], f.hp, p, length)
+			f.hp += length
+			continue
+		}
 		f.copyLen, f.copyDist = length, dist
 		if f.copyHist() {
 			return
@@ -569,13 +666,11 @@ func (f *decompressor) copyHuff() {
 func (f *decompressor) dataBlock() {
 	// Uncompressed.
 	// Discard current half-byte.
-	f.nb = 0
-	f.b = 0
+	f.b >>= f.nb & 7
+	f.nb &^= 7
 
 	// Length then ones-complement of length.
-	nr, err := io.ReadFull(f.r, f.buf[0:4])
-	f.roffset += int64(nr)
-	if err != nil {
+	if err := f.readBytes(f.buf[0:4]); err != nil {
 		f.err = &ReadError{f.roffset, err}
 		return
 	}
@@ -605,9 +700,7 @@ func (f *decompressor) copyData() {
 		if m > n {
 			m = n
 		}
-		m, err := io.ReadFull(f.r, f.hist[f.hp:f.hp+m])
-		f.roffset += int64(m)
-		if err != nil {
+		if err := f.readBytes(f.hist[f.hp : f.hp+m]); err != nil {
 			f.err = &ReadError{f.roffset, err}
 			return
 		}
@@ -636,6 +729,49 @@ func (f *decompressor) setDict(dict []byte) {
 	f.hw = f.hp
 }
 
+// fill adds as many bytes of input as fit to f.b, from those read ahead,
+// and any more f.lr has without blocking.
+func (f *decompressor) fill() {
+	if len(f.in) < 8 && f.lr != nil {
+		if n := f.lr.Len(); n > 0 {
+			m := copy(f.input[:], f.in)
+			if n > len(f.input)-m {
+				n = len(f.input) - m
+			}
+			n, _ = f.lr.Read(f.input[m : m+n])
+			f.in = f.input[:m+n]
+		}
+	}
+	if len(f.in) >= 8 {
+		f.b |= binary.LittleEndian.Uint64(f.in) << f.nb
+		n := (63 - f.nb) >> 3
+		f.in = f.in[n:]
+		f.nb += n << 3
+		f.roffset += int64(n)
+		return
+	}
+	for f.nb <= 64-8 && len(f.in) > 0 {
+		f.b |= uint64(f.in[0]) << f.nb
+		f.in = f.in[1:]
+		f.nb += 8
+		f.roffset++
+	}
+}
+
+// need makes sure there are at least n bits in f.b, reading from f.r
+// for them if need be.
+func (f *decompressor) need(n uint) error {
+	if f.nb < n {
+		f.fill()
+	}
+	for f.nb < n {
+		if err := f.moreBits(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (f *decompressor) moreBits() error {
 	c, err := f.r.ReadByte()
 	if err != nil {
@@ -645,13 +781,41 @@ func (f *decompressor) moreBits() error {
 		return err
 	}
 	f.roffset++
-	f.b |= uint32(c) << f.nb
+	f.b |= uint64(c) << f.nb
 	f.nb += 8
 	return nil
 }
 
+// readBytes reads len(p) bytes of input, starting with any whole bytes
+// left in f.b, which must have been byte-aligned.
+func (f *decompressor) readBytes(p []byte) error {
+	for len(p) > 0 && f.nb > 0 {
+		p[0] = byte(f.b)
+		p = p[1:]
+		f.b >>= 8
+		f.nb -= 8
+	}
+	if f.nb == 0 {
+		// What's above is the rest of f.in, which is about to be
+		// read from there instead
+		f.b = 0
+	}
+	n := copy(p, f.in)
+	f.in = f.in[n:]
+	f.roffset += int64(n)
+	if n < len(p) {
+		n, err := io.ReadFull(f.r, p[n:])
+		f.roffset += int64(n)
+		return err
+	}
+	return nil
+}
+
 // Read the next Huffman-encoded symbol from f according to h.
 func (f *decompressor) huffSym(h *huffmanDecoder) (int, error) {
+	if f.nb < maxCodeLen {
+		f.fill()
+	}
 	n := uint(h.min)
 	for {
 		for f.nb < n {
@@ -662,12 +826,12 @@ func (f *decompressor) huffSym(h *huffmanDecoder) (int, error) {
 		chunk := h.chunks[f.b&(huffmanNumChunks-1)]
 		n = uint(chunk & huffmanCountMask)
 		if n > huffmanChunkBits {
-			chunk = h.links[chunk>>huffmanValueShift][(f.b>>huffmanChunkBits)&h.linkMask]
+			chunk = h.links[chunk>>huffmanValueShift][(f.b>>huffmanChunkBits)&uint64(h.linkMask)]
 			n = uint(chunk & huffmanCountMask)
-			if n == 0 {
-				f.err = CorruptInputError(f.roffset)
-				return 0, f.err
-			}
+		}
+		if n == 0 {
+			f.err = CorruptInputError(f.roffset)
+			return 0, f.err
 		}
 		if n <= f.nb {
 			f.b >>= n
@@ -699,12 +863,14 @@ func makeReader(r io.Reader) Reader {
 
 func (f *decompressor) Reset(r io.Reader, dict []byte) error {
 	*f = decompressor{
-		r:        makeReader(r),
+		h1:       huffmanDecoder{links: f.h1.links},
+		h2:       huffmanDecoder{links: f.h2.links},
 		bits:     f.bits,
 		codebits: f.codebits,
 		hist:     f.hist,
 		step:     (*decompressor).nextBlock,
 	}
+	f.setReader(makeReader(r))
 	if dict != nil {
 		f.setDict(dict)
 	}
@@ -715,6 +881,8 @@ func (f *decompressor) Reset(r io.Reader, dict []byte) error {
 // to read the uncompressed version of r.
 // If r does not also implement io.ByteReader,
 // the decompressor may read more data than necessary from r.
+// If it also has a Len method, as *bytes.Reader and *bytes.Buffer do,
+// the decompressor reads as far ahead as that says it can.
 // It is the caller's responsibility to call Close on the ReadCloser
 // when finished reading.
 //
@@ -723,7 +891,7 @@ func NewReader(r io.Reader) io.ReadCloser {
 	var f decompressor
 	f.bits = new([maxLit + maxDist]int)
 	f.codebits = new([numCodes]int)
-	f.r = makeReader(r)
+	f.setReader(makeReader(r))
 	f.hist = new([maxHist]byte)
 	f.step = (*decompressor).nextBlock
 	return &f
@@ -738,7 +906,7 @@ func NewReader(r io.Reader) io.ReadCloser {
 // The ReadCloser returned by NewReader also implements Resetter.
 func NewReaderDict(r io.Reader, dict []byte) io.ReadCloser {
 	var f decompressor
-	f.r = makeReader(r)
+	f.setReader(makeReader(r))
 	f.hist = new([maxHist]byte)
 	f.bits = new([maxLit + maxDist]int)
 	f.codebits = new([numCodes]int)
@@ -5,9 +5,13 @@
 package flexflate
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"fmt"
 	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestReset(t *testing.T) {
@@ -33,7 +37,268 @@ func TestReset(t *testing.T) {
 
 	for i, s := range ss {
 		if s != inflated[i].String() {
-			t.Errorf("inflated[%d]:\ngot  %q\nwant %q", i, inflated[i], s)
+			t.Errorf("inflated[%d]:\ngot  %q\nwant %q", i, inflated[i].String(), s)
+		}
+	}
+}
+
+func TestPairTable(t *testing.T) {
+	// Literals with codes of every length up to one that needs a link
+	// table, and a mix of short literals and lengths
+	long := make([]int, 257)
+	for i := 0; i < 10; i++ {
+		long[i] = i + 1
+	}
+	long[10], long[256] = 11, 11
+	short := make([]int, 258)
+	short[97], short[98], short[256], short[99], short[257] = 2, 2, 2, 3, 3
+
+	for name, bits := range map[string][]int{"Long": long, "Short": short} {
+		var h huffmanDecoder
+		if !h.init(bits) {
+			t.Fatalf("%s: bad code lengths", name)
+		}
+		var pairs pairTable
+		pairs.init(&h)
+		for i, pair := range pairs {
+			f := &decompressor{r: bytes.NewReader(nil), b: uint64(i), nb: pairBits}
+			first, err1 := f.huffSym(&h)
+			second, err2 := f.huffSym(&h)
+			var want uint32
+			if err1 == nil && err2 == nil && first < 256 && second < 256 {
+				want = uint32(first | second<<8 | (pairBits-int(f.nb))<<16)
+			}
+			if pair != want {
+				t.Fatalf("%s: pair %#x is %#x; expected %#x", name, i, pair, want)
+			}
+		}
+	}
+}
+
+// screen draws the sort of 256x256 desktop VNC servers send, as rows of
+// RGB: a flat background, and a window with a gradient title bar, lines
+// of text, and a photo.
+func screen() []byte {
+	const size = 256
+	pix := make([]byte, 3*size*size)
+	seed := uint32(1)
+	rand := func() uint32 {
+		seed = seed*1664525 + 1013904223
+		return seed >> 16
+	}
+	set := func(x, y int, r, g, b byte) {
+		i := 3 * (y*size + x)
+		pix[i], pix[i+1], pix[i+2] = r, g, b
+	}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			set(x, y, 58, 110, 165)
+		}
+	}
+	for y := 24; y < 224; y++ {
+		for x := 16; x < 240; x++ {
+			switch {
+			case y < 40:
+				set(x, y, byte(40+x/2), byte(60+x/2), byte(140+x/4))
+			case x >= 140 && x < 230 && y >= 120 && y < 215:
+				n := byte(rand() & 15)
+				set(x, y, byte(x+y)+n, byte(2*y-x)+n, byte(x*y/64)+n)
+			default:
+				set(x, y, 255, 255, 255)
+			}
+		}
+	}
+	// Glyphs are 5x8 in 6x12 cells
+	for line := 48; line+12 < 224; line += 12 {
+		for cell := 20; cell+6 < 236; cell += 6 {
+			if cell >= 136 && line+12 > 120 && line < 215 {
+				continue
+			}
+			glyph := rand()<<16 | rand()
+			if glyph%7 == 0 {
+				continue
+			}
+			for i := uint(0); i < 40; i++ {
+				if glyph&(1<<(i%32)) != 0 {
+					set(cell+int(i%5), line+int(i/5), 0, 0, 0)
+				}
+			}
+		}
+	}
+	return pix
+}
+
+// tightRects splits screen into 64x64 rectangles of the data Tight's
+// copy filter would compress.
+func tightRects(pix []byte) [][]byte {
+	var rects [][]byte
+	for y := 0; y < 256; y += 64 {
+		for x := 0; x < 256; x += 64 {
+			var rect []byte
+			for row := y; row < y+64; row++ {
+				rect = append(rect, pix[3*(row*256+x):3*(row*256+x+64)]...)
+			}
+			rects = append(rects, rect)
+		}
+	}
+	return rects
+}
+
+// zrleRects splits screen into 64x64 rectangles of ZRLE tiles, which
+// are solid, packed palette or raw.
+func zrleRects(pix []byte) [][]byte {
+	var rects [][]byte
+	for _, rect := range tightRects(pix) {
+		var palette []string
+		index := make([]int, len(rect)/3)
+		for i := range index {
+			c := string(rect[3*i : 3*i+3])
+			index[i] = -1
+			for j, p := range palette {
+				if p == c {
+					index[i] = j
+				}
+			}
+			if index[i] < 0 && len(palette) <= 16 {
+				index[i] = len(palette)
+				palette = append(palette, c)
+			}
+		}
+		switch n := len(palette); {
+		case n == 1:
+			rect = append([]byte{1}, palette[0]...)
+		case n <= 16:
+			bits := uint(4)
+			if n == 2 {
+				bits = 1
+			} else if n <= 4 {
+				bits = 2
+			}
+			tile := []byte{byte(n)}
+			for _, p := range palette {
+				tile = append(tile, p...)
+			}
+			for row := 0; row < 64; row++ {
+				var b byte
+				shift := uint(8)
+				for _, i := range index[64*row : 64*row+64] {
+					shift -= bits
+					b |= byte(i) << shift
+					if shift == 0 {
+						tile, b, shift = append(tile, b), 0, 8
+					}
+				}
+			}
+			rect = tile
+		default:
+			rect = append([]byte{0}, rect...)
+		}
+		rects = append(rects, rect)
+	}
+	return rects
+}
+
+// compressChunks compresses rects on one deflate stream at level,
+// flushing after each as VNC servers do.
+func compressChunks(rects [][]byte, level int) [][]byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, level)
+	var chunks [][]byte
+	for _, rect := range rects {
+		w.Write(rect)
+		w.Flush()
+		chunks = append(chunks, append([]byte(nil), buf.Bytes()...))
+		buf.Reset()
+	}
+	return chunks
+}
+
+// inflateChunk reads all that f inflates the chunk it's been given to,
+// which must end at a flush, into buf.
+func inflateChunk(f io.Reader, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, err := f.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if err == io.ErrUnexpectedEOF {
+			return buf, nil
+		} else if err != nil {
+			return buf, err
+		}
+	}
+}
+
+// byteReader hides all but the Reader methods of r.
+type byteReader struct{ Reader }
+
+func TestReaderSwapper(t *testing.T) {
+	pix := screen()
+	payloads := map[string][][]byte{"Tight": tightRects(pix), "ZRLE": zrleRects(pix)}
+	readers := map[string]func([]byte) Reader{
+		"BytesReader": func(b []byte) Reader { return bytes.NewReader(b) },
+		"ByteReader":  func(b []byte) Reader { return byteReader{bytes.NewReader(b)} },
+		"Bufio":       func(b []byte) Reader { return bufio.NewReader(iotest.OneByteReader(bytes.NewReader(b))) },
+	}
+	for name, rects := range payloads {
+		for _, level := range []int{flate.BestSpeed, flate.DefaultCompression, flate.BestCompression} {
+			chunks := compressChunks(rects, level)
+			for readerName, reader := range readers {
+				f := NewReader(reader(chunks[0]))
+				for i, chunk := range chunks {
+					if i > 0 {
+						f.(ReaderSwapper).SwapReader(reader(chunk))
+					}
+					got, err := inflateChunk(f, nil)
+					if err != nil {
+						t.Fatalf("%s at level %d from %s: chunk %d: %s", name, level, readerName, i, err)
+					}
+					if !bytes.Equal(got, rects[i]) {
+						t.Fatalf("%s at level %d from %s: chunk %d inflated differently", name, level, readerName, i)
+					}
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkInflateChunks inflates screens of ZRLE and Tight rectangles
+// chunk by chunk, at the compression levels VNC servers tend to use.
+func BenchmarkInflateChunks(b *testing.B) {
+	pix := screen()
+	for _, payload := range []struct {
+		name  string
+		rects [][]byte
+	}{{"Tight", tightRects(pix)}, {"ZRLE", zrleRects(pix)}} {
+		for _, level := range []int{1, 6} {
+			b.Run(fmt.Sprintf("%s/Level%d", payload.name, level), func(b *testing.B) {
+				chunks := compressChunks(payload.rects, level)
+				var size int
+				for _, rect := range payload.rects {
+					size += len(rect)
+				}
+				r := bytes.NewReader(chunks[0])
+				f := NewReader(r)
+				buf := make([]byte, 0, 64*64*3+1)
+				b.SetBytes(int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					r.Reset(chunks[0])
+					f.(Resetter).Reset(r, nil)
+					for j, chunk := range chunks {
+						if j > 0 {
+							r.Reset(chunk)
+							f.(ReaderSwapper).SwapReader(r)
+						}
+						var err error
+						if buf, err = inflateChunk(f, buf); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			})
 		}
 	}
 }
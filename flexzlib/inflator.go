@@ -2,11 +2,22 @@ package flexzlib
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"io/ioutil"
 )
 
+// ErrTooLarge is returned by InflateLimit, InflateInto and InflateBuffer
+// when the data would inflate to more than the limit.
+var ErrTooLarge = errors.New("zlib: inflated data exceeds limit")
+
 type Inflator struct {
 	r *Reader
+
+	// src reads the data being inflated
+	src bytes.Reader
+	// end is where InflateInto checks for more data past its limit
+	end [1]byte
 }
 
 func NewInflator() *Inflator {
@@ -14,21 +25,88 @@ func NewInflator() *Inflator {
 }
 
 func (i *Inflator) Inflate(p []byte) ([]byte, error) {
-	buf := bytes.NewBuffer(p)
+	return i.InflateLimit(p, -1)
+}
+
+// InflateLimit is like Inflate, but fails with ErrTooLarge rather than
+// inflate p to more than max bytes. A negative max means no limit.
+func (i *Inflator) InflateLimit(p []byte, max int) ([]byte, error) {
+	if err := i.swap(p); err != nil {
+		return nil, err
+	}
+
+	if max < 0 {
+		return ioutil.ReadAll(i.r)
+	}
+	r, e := ioutil.ReadAll(io.LimitReader(i.r, int64(max)+1))
+	if e == nil && len(r) > max {
+		return nil, ErrTooLarge
+	}
+	return r, e
+}
+
+// InflateInto is like Inflate, but inflates src into dst, and returns
+// how many bytes that came to. It fails with ErrTooLarge if that's more
+// than len(dst). Unlike Inflate, it doesn't allocate once the stream
+// has started.
+func (i *Inflator) InflateInto(dst, src []byte) (int, error) {
+	if err := i.swap(src); err != nil {
+		return 0, err
+	}
+
+	var n int
+	for {
+		p, full := dst[n:], n == len(dst)
+		if full {
+			// Only the end of the data should be left
+			p = i.end[:]
+		}
+		m, err := i.r.Read(p)
+		if full && m > 0 {
+			return n, ErrTooLarge
+		}
+		n += m
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+	}
+}
+
+// InflateBuffer is like InflateLimit, but appends what src inflates to
+// onto dst, which only grows as the data inflates.
+func (i *Inflator) InflateBuffer(dst *bytes.Buffer, src []byte, max int) error {
+	if err := i.swap(src); err != nil {
+		return err
+	}
+	// The caller may reuse src once we're done
+	defer i.src.Reset(nil)
+
+	n, err := dst.ReadFrom(io.LimitReader(i.r, int64(max)+1))
+	if err != nil {
+		return err
+	} else if n > int64(max) {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// swap carries on inflating from p, or starts doing so.
+func (i *Inflator) swap(p []byte) error {
+	i.src.Reset(p)
 	if i.r == nil {
 		// Need to do this lazily since we need to make sure
 		// we have a complete zlib header.
-		r, err := NewReader(buf)
+		r, err := NewReader(&i.src)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		i.r = r
 	} else {
-		i.r.SwapReader(buf)
+		i.r.SwapReader(&i.src)
 	}
-
-	r, e := ioutil.ReadAll(i.r)
-	return r, e
+	return nil
 }
 
 func (i *Inflator) Read(p []byte) (int, error) {
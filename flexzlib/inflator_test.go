@@ -2,6 +2,7 @@ package flexzlib
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/base64"
 	"testing"
 )
@@ -30,3 +31,155 @@ func TestInflator(t *testing.T) {
 		}
 	}
 }
+
+func TestInflator_InflateLimit(t *testing.T) {
+	compressed, err := base64.StdEncoding.DecodeString(dataCompressed[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedInflated, err := base64.StdEncoding.DecodeString(dataInflated[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inflated, err := NewInflator().InflateLimit(compressed, len(expectedInflated))
+	if err != nil {
+		t.Fatalf("unexpected error at the limit: %s", err)
+	}
+	if !bytes.Equal(inflated, expectedInflated) {
+		t.Fatalf("Incorrect inflation: actual=%q expected=%q", inflated, expectedInflated)
+	}
+
+	if _, err := NewInflator().InflateLimit(compressed, len(expectedInflated)-1); err != ErrTooLarge {
+		t.Errorf("over the limit: got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestInflator_InflateInto(t *testing.T) {
+	inflator := NewInflator()
+	for i := range dataCompressed {
+		compressed, err := base64.StdEncoding.DecodeString(dataCompressed[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedInflated, err := base64.StdEncoding.DecodeString(dataInflated[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Exactly big enough, bar the first, which has room to spare
+		dst := make([]byte, len(expectedInflated))
+		if i == 0 {
+			dst = make([]byte, 2*len(expectedInflated))
+		}
+		n, err := inflator.InflateInto(dst, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst[:n], expectedInflated) {
+			t.Fatalf("Incorrect inflation: actual=%q expected=%q", dst[:n], expectedInflated)
+		}
+	}
+
+	compressed, _ := base64.StdEncoding.DecodeString(dataCompressed[0])
+	expectedInflated, _ := base64.StdEncoding.DecodeString(dataInflated[0])
+	if _, err := NewInflator().InflateInto(make([]byte, len(expectedInflated)-1), compressed); err != ErrTooLarge {
+		t.Errorf("over the limit: got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestInflator_InflateBuffer(t *testing.T) {
+	inflator := NewInflator()
+	var dst bytes.Buffer
+	for i := range dataCompressed {
+		compressed, err := base64.StdEncoding.DecodeString(dataCompressed[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedInflated, err := base64.StdEncoding.DecodeString(dataInflated[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Exactly at the limit
+		dst.Reset()
+		if err := inflator.InflateBuffer(&dst, compressed, len(expectedInflated)); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dst.Bytes(), expectedInflated) {
+			t.Fatalf("Incorrect inflation: actual=%q expected=%q", dst.Bytes(), expectedInflated)
+		}
+	}
+
+	compressed, _ := base64.StdEncoding.DecodeString(dataCompressed[0])
+	expectedInflated, _ := base64.StdEncoding.DecodeString(dataInflated[0])
+	if err := NewInflator().InflateBuffer(&dst, compressed, len(expectedInflated)-1); err != ErrTooLarge {
+		t.Errorf("over the limit: got %v, want ErrTooLarge", err)
+	}
+}
+
+// flushedChunks compresses n chunks of data on one zlib stream, flushing
+// after each, as VNC servers do.
+func flushedChunks(n int) (chunks [][]byte, size int) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	data := make([]byte, 64*64*3)
+	for i := 0; i < n; i++ {
+		for j := range data {
+			data[j] = byte(j/3*i/7 + j%3)
+		}
+		w.Write(data)
+		w.Flush()
+		chunks = append(chunks, append([]byte(nil), buf.Bytes()...))
+		buf.Reset()
+	}
+	return chunks, len(data)
+}
+
+func TestInflator_InflateIntoAllocs(t *testing.T) {
+	chunks, size := flushedChunks(101)
+	inflator := NewInflator()
+	dst := make([]byte, size)
+	if _, err := inflator.InflateInto(dst, chunks[0]); err != nil {
+		t.Fatal(err)
+	}
+	chunks = chunks[1:]
+	allocs := testing.AllocsPerRun(len(chunks)-1, func() {
+		if _, err := inflator.InflateInto(dst, chunks[0]); err != nil {
+			t.Fatal(err)
+		}
+		chunks = chunks[1:]
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per chunk", allocs)
+	}
+}
+
+func BenchmarkInflator(b *testing.B) {
+	chunks, size := flushedChunks(16)
+	b.Run("Inflate", func(b *testing.B) {
+		b.SetBytes(int64(len(chunks) * size))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			inflator := NewInflator()
+			for _, chunk := range chunks {
+				if _, err := inflator.Inflate(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("InflateInto", func(b *testing.B) {
+		dst := make([]byte, size)
+		b.SetBytes(int64(len(chunks) * size))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			inflator := NewInflator()
+			for _, chunk := range chunks {
+				if _, err := inflator.InflateInto(dst, chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
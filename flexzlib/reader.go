@@ -3,7 +3,6 @@ package flexzlib
 import (
 	"bufio"
 	"errors"
-	"hash"
 	"hash/adler32"
 	"io"
 
@@ -27,7 +26,6 @@ var (
 type Reader struct {
 	r            flexflate.Reader
 	decompressor io.ReadCloser
-	digest       hash.Hash32
 	err          error
 	scratch      [4]byte
 }
@@ -88,7 +86,6 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 	}
 
 	n, err = z.decompressor.Read(p)
-	z.digest.Write(p[0:n])
 
 	if err == io.ErrUnexpectedEOF {
 		err = io.EOF
@@ -96,23 +93,10 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 
 	if n != 0 || err != io.EOF {
 		z.err = err
-		return
 	}
 
-	// Do not need checksuming
-	return
-
-	// Finished file; check checksum.
-	if _, err := io.ReadFull(z.r, z.scratch[0:4]); err != nil {
-		z.err = err
-		return 0, err
-	}
-	// ZLIB (RFC 1950) is big-endian, unlike GZIP (RFC 1952).
-	checksum := uint32(z.scratch[0])<<24 | uint32(z.scratch[1])<<16 | uint32(z.scratch[2])<<8 | uint32(z.scratch[3])
-	if checksum != z.digest.Sum32() {
-		z.err = ErrChecksum
-		return 0, z.err
-	}
+	// There's no checksum to check, since VNC's zlib streams don't end,
+	// and so no digest is kept either
 	return
 }
 
@@ -155,6 +139,5 @@ func (z *Reader) Reset(r io.Reader, dict []byte) error {
 	} else {
 		z.decompressor.(flexflate.Resetter).Reset(z.r, dict)
 	}
-	z.digest = adler32.New()
 	return nil
 }
@@ -6,6 +6,12 @@ type VNCEvent interface {
 	Execute(c *vncclient.ClientConn) error
 }
 
+// batchedEvent is a VNCEvent that Step can send along with the rest of
+// a step's events, in one write.
+type batchedEvent interface {
+	addTo(b *vncclient.EventBatch)
+}
+
 type KeyEvent struct {
 	Keysym uint32
 	Down   bool
@@ -15,6 +21,10 @@ func (k KeyEvent) Execute(c *vncclient.ClientConn) error {
 	return c.KeyEvent(k.Keysym, k.Down)
 }
 
+func (k KeyEvent) addTo(b *vncclient.EventBatch) {
+	b.KeyEvent(k.Keysym, k.Down)
+}
+
 type PointerEvent struct {
 	Mask vncclient.ButtonMask
 	X, Y uint16
@@ -23,3 +33,7 @@ type PointerEvent struct {
 func (k PointerEvent) Execute(c *vncclient.ClientConn) error {
 	return c.PointerEvent(k.Mask, k.X, k.Y)
 }
+
+func (k PointerEvent) addTo(b *vncclient.EventBatch) {
+	b.PointerEvent(k.Mask, k.X, k.Y)
+}
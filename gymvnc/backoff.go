@@ -0,0 +1,72 @@
+package gymvnc
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff controls how a session retries a connection that failed
+// with a retryable error. Zero fields take the defaults, which match
+// the historical behavior: waits of 2s, 4s, 6s, ... up to 30s.
+type Backoff struct {
+	// InitialDelay is the wait after the first failed attempt. Each
+	// further failure waits InitialDelay longer than the last.
+	InitialDelay time.Duration
+
+	// MaxDelay caps the wait between attempts.
+	MaxDelay time.Duration
+
+	// Jitter randomizes each wait by up to this fraction (0-1) in
+	// either direction, so a fleet of sessions doesn't retry in
+	// lockstep.
+	Jitter float64
+
+	// MaxAttempts, if positive, gives up after that many attempts.
+	MaxAttempts int
+}
+
+const (
+	defaultInitialDelay = 2 * time.Second
+	defaultMaxDelay     = 30 * time.Second
+)
+
+func (b Backoff) withDefaults() Backoff {
+	if b.InitialDelay <= 0 {
+		b.InitialDelay = defaultInitialDelay
+	}
+	if b.MaxDelay <= 0 {
+		b.MaxDelay = defaultMaxDelay
+	}
+	if b.Jitter < 0 {
+		b.Jitter = 0
+	} else if b.Jitter > 1 {
+		b.Jitter = 1
+	}
+	return b
+}
+
+// delay returns how long to wait after the given (1-based) failed
+// attempt.
+func (b Backoff) delay(attempt int) time.Duration {
+	d := b.InitialDelay * time.Duration(attempt)
+	if d > b.MaxDelay || d <= 0 {
+		d = b.MaxDelay
+	}
+	if b.Jitter > 0 {
+		d = time.Duration(float64(d) * (1 + b.Jitter*(2*rand.Float64()-1)))
+	}
+	return d
+}
+
+// A ConnectAttempt reports the outcome of one try at connecting.
+type ConnectAttempt struct {
+	// Attempt counts from 1.
+	Attempt int
+
+	// Err is nil if the attempt succeeded.
+	Err error
+
+	// Retry is whether another attempt will be made, after Delay.
+	Retry bool
+	Delay time.Duration
+}
@@ -0,0 +1,119 @@
+package gymvnc
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{}.withDefaults()
+	for attempt, expected := range map[int]time.Duration{
+		1:  2 * time.Second,
+		2:  4 * time.Second,
+		3:  6 * time.Second,
+		15: 30 * time.Second,
+		16: 30 * time.Second,
+	} {
+		if actual := b.delay(attempt); actual != expected {
+			t.Errorf("delay(%d) = %s, want %s", attempt, actual, expected)
+		}
+	}
+
+	b = Backoff{InitialDelay: time.Second, MaxDelay: 10 * time.Second, Jitter: 0.5}.withDefaults()
+	for i := 0; i < 100; i++ {
+		if d := b.delay(20); d < 5*time.Second || d > 15*time.Second {
+			t.Fatalf("delay with jitter out of range: %s", d)
+		}
+	}
+}
+
+// closedPort returns an address nothing is listening on.
+func closedPort(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+// sessionErr waits for the session's connect goroutine to record an
+// error.
+func sessionErr(t *testing.T, session *VNCSession, within time.Duration) error {
+	deadline := time.Now().Add(within)
+	for {
+		session.lock.Lock()
+		err := session.err
+		session.lock.Unlock()
+		if err != nil {
+			return err
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session still connecting after %s", within)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnect_CloseStopsRetrying(t *testing.T) {
+	session := NewVNCSession("retrying", VNCSessionConfig{
+		Address:      closedPort(t),
+		StartTimeout: time.Hour,
+		Backoff:      Backoff{InitialDelay: time.Minute, MaxDelay: time.Hour},
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	var attempts []ConnectAttempt
+	for len(attempts) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no connection attempt reported")
+		}
+		time.Sleep(10 * time.Millisecond)
+		attempts = session.ConnectAttempts()
+	}
+	if !attempts[0].Retry || attempts[0].Delay != time.Minute || attempts[0].Err == nil {
+		t.Errorf("unexpected attempt: %+v", attempts[0])
+	}
+
+	session.Close()
+	// Without cancellation this would take a minute
+	sessionErr(t, session, 5*time.Second)
+}
+
+func TestConnect_MaxAttempts(t *testing.T) {
+	session := NewVNCSession("retrying", VNCSessionConfig{
+		Address: closedPort(t),
+		Backoff: Backoff{InitialDelay: time.Millisecond, MaxAttempts: 3},
+	})
+	defer session.Close()
+
+	sessionErr(t, session, 5*time.Second)
+	attempts := session.ConnectAttempts()
+	if len(attempts) != 3 {
+		t.Fatalf("got %d attempts, want 3: %+v", len(attempts), attempts)
+	}
+	for i, attempt := range attempts {
+		if attempt.Attempt != i+1 || attempt.Err == nil || attempt.Retry != (i < 2) {
+			t.Errorf("unexpected attempt %d: %+v", i, attempt)
+		}
+	}
+}
+
+func TestConnect_ReportsSuccess(t *testing.T) {
+	server, closeServer := newMockRFBServer(t, "attempts")
+	defer closeServer()
+
+	batch := NewVNCBatch()
+	batch.Open("attempts", VNCSessionConfig{Address: server})
+	defer batch.Close("attempts")
+
+	waitForScreen(t, batch, "attempts")
+	attempts := batch.ConnectAttempts()["attempts"]
+	if len(attempts) != 1 || attempts[0].Attempt != 1 || attempts[0].Err != nil || attempts[0].Retry {
+		t.Errorf("unexpected attempts: %+v", attempts)
+	}
+	if len(batch.ConnectAttempts()) != 0 {
+		t.Error("attempts were reported twice")
+	}
+}
@@ -0,0 +1,389 @@
+package gymvnc
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+)
+
+// A Dialer opens the connection a VNCSession speaks RFB over. It has
+// the same shape as (*net.Dialer).Dial, so a *net.Dialer (or any
+// proxy dialer following that convention) can be used directly.
+type Dialer interface {
+	Dial(network, address string) (net.Conn, error)
+}
+
+// A ContextDialer is a Dialer that can also abandon a dial when ctx is
+// done. *net.Dialer is one.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func dialContext(ctx context.Context, d Dialer, network, address string) (net.Conn, error) {
+	if cd, ok := d.(ContextDialer); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	return d.Dial(network, address)
+}
+
+// defaultVNCPort is used when an address doesn't specify a port.
+const defaultVNCPort = "5900"
+
+// ParseAddress picks a Dialer based on the scheme of address, and
+// returns the network and address it should be called with. The
+// supported forms are:
+//
+//	host:port, [ipv6]:port           plain TCP
+//	unix:/path/to/socket             Unix domain socket
+//	socks5://[user[:pass]@]proxy:port/host:port
+//	http://[user[:pass]@]proxy:port/host:port   (HTTP CONNECT)
+//	ws://host:port/path, wss://host:port/path   (websockify)
+//
+// A missing port defaults to 5900, except for WebSocket addresses,
+// which default to 80 and 443.
+func ParseAddress(address string) (Dialer, string, string, error) {
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(strings.TrimPrefix(address, "unix:"), "//")
+		if path == "" {
+			return nil, "", "", errors.Errorf("no socket path in address: %s", address)
+		}
+		return &net.Dialer{}, "unix", path, nil
+	}
+
+	if !strings.Contains(address, "://") {
+		hostport, err := withDefaultPort(address)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return &net.Dialer{}, "tcp", hostport, nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, "", "", errors.Annotatef(err, "could not parse address %s", address)
+	}
+
+	// For WebSocket addresses the whole URL is the target: the path
+	// is the websockify endpoint, not a host:port.
+	if u.Scheme == "ws" || u.Scheme == "wss" {
+		if u.Host == "" {
+			return nil, "", "", errors.Errorf("no host in address: %s", address)
+		}
+		return &WebSocketDialer{}, "tcp", address, nil
+	}
+
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	proxy, err := withDefaultPort(u.Host)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	target, err := withDefaultPort(strings.TrimPrefix(u.Path, "/"))
+	if err != nil {
+		return nil, "", "", errors.Annotatef(err, "invalid target in address %s", address)
+	}
+
+	switch u.Scheme {
+	case "socks5":
+		return &SOCKS5Dialer{
+			ProxyAddress: proxy,
+			Username:     username,
+			Password:     password,
+		}, "tcp", target, nil
+	case "http":
+		return &HTTPConnectDialer{
+			ProxyAddress: proxy,
+			Username:     username,
+			Password:     password,
+		}, "tcp", target, nil
+	default:
+		return nil, "", "", errors.Errorf("unsupported address scheme %q in %s", u.Scheme, address)
+	}
+}
+
+// withDefaultPort validates a host:port pair, filling in the default
+// VNC port if there is none. IPv6 literals must be bracketed.
+func withDefaultPort(hostport string) (string, error) {
+	if hostport == "" {
+		return "", errors.New("empty address")
+	}
+
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport, nil
+	}
+
+	host := hostport
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	} else if strings.Contains(host, ":") {
+		return "", errors.Errorf("invalid address %s (IPv6 addresses must be bracketed, as in [::1]:5900)", hostport)
+	}
+	return net.JoinHostPort(host, defaultVNCPort), nil
+}
+
+func forwardDialer(d Dialer) Dialer {
+	if d == nil {
+		return &net.Dialer{}
+	}
+	return d
+}
+
+// SOCKS5Dialer connects through a SOCKS5 proxy (RFC 1928), optionally
+// authenticating with a username and password (RFC 1929).
+type SOCKS5Dialer struct {
+	ProxyAddress string
+	Username     string
+	Password     string
+
+	// Forward is used to reach the proxy. Defaults to a plain
+	// net.Dialer.
+	Forward Dialer
+}
+
+func (s *SOCKS5Dialer) Dial(network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, errors.Errorf("socks5: unsupported network %s", network)
+	}
+
+	conn, err := forwardDialer(s.Forward).Dial("tcp", s.ProxyAddress)
+	if err != nil {
+		return nil, errors.Annotatef(err, "socks5: could not reach proxy %s", s.ProxyAddress)
+	}
+
+	if err := s.handshake(conn, address); err != nil {
+		conn.Close()
+		return nil, errors.Annotatef(err, "socks5: could not connect to %s via %s", address, s.ProxyAddress)
+	}
+	return conn, nil
+}
+
+const (
+	socks5Version          = 5
+	socks5AuthNone         = 0
+	socks5AuthPassword     = 2
+	socks5AuthNoAcceptable = 0xff
+	socks5Connect          = 1
+	socks5AddrIPv4         = 1
+	socks5AddrDomain       = 3
+	socks5AddrIPv6         = 4
+)
+
+func (s *SOCKS5Dialer) handshake(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return errors.Errorf("invalid port %s", portStr)
+	}
+
+	// Method selection
+	methods := []byte{socks5AuthNone}
+	if s.Username != "" {
+		methods = []byte{socks5AuthNone, socks5AuthPassword}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.Errorf("unexpected protocol version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if len(s.Username) > 255 || len(s.Password) > 255 {
+			return errors.New("username or password too long")
+		}
+		req := []byte{1, byte(len(s.Username))}
+		req = append(req, s.Username...)
+		req = append(req, byte(len(s.Password)))
+		req = append(req, s.Password...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("proxy rejected username/password")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("proxy requires an authentication method we don't support")
+	default:
+		return errors.Errorf("proxy chose unexpected authentication method %d", reply[1])
+	}
+
+	// Connect request
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.Errorf("hostname too long: %s", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var resp [4]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.Errorf("proxy refused connection: %s", socks5ReplyString(resp[1]))
+	}
+
+	// Discard the bound address
+	var skip int
+	switch resp[3] {
+	case socks5AddrIPv4:
+		skip = net.IPv4len
+	case socks5AddrIPv6:
+		skip = net.IPv6len
+	case socks5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(conn, l[:]); err != nil {
+			return err
+		}
+		skip = int(l[0])
+	default:
+		return errors.Errorf("unexpected bound address type %d", resp[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+func socks5ReplyString(code byte) string {
+	switch code {
+	case 1:
+		return "general failure"
+	case 2:
+		return "connection not allowed by ruleset"
+	case 3:
+		return "network unreachable"
+	case 4:
+		return "host unreachable"
+	case 5:
+		return "connection refused"
+	case 6:
+		return "TTL expired"
+	case 7:
+		return "command not supported"
+	case 8:
+		return "address type not supported"
+	}
+	return fmt.Sprintf("unknown code %d", code)
+}
+
+// HTTPConnectDialer tunnels through an HTTP proxy using the CONNECT
+// method, optionally with basic authentication.
+type HTTPConnectDialer struct {
+	ProxyAddress string
+	Username     string
+	Password     string
+
+	// Forward is used to reach the proxy. Defaults to a plain
+	// net.Dialer.
+	Forward Dialer
+}
+
+func (h *HTTPConnectDialer) Dial(network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return nil, errors.Errorf("http connect: unsupported network %s", network)
+	}
+
+	conn, err := forwardDialer(h.Forward).Dial("tcp", h.ProxyAddress)
+	if err != nil {
+		return nil, errors.Annotatef(err, "http connect: could not reach proxy %s", h.ProxyAddress)
+	}
+
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if h.Username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(h.Username + ":" + h.Password))
+		req += "Proxy-Authorization: Basic " + creds + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, errors.Annotate(err, "http connect: could not send request")
+	}
+
+	// Read the response a byte at a time, so we don't consume any of
+	// the RFB stream that follows it.
+	br := bufio.NewReaderSize(&byteReader{r: conn}, 16)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		return nil, errors.Annotate(err, "http connect: could not read response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("http connect: proxy %s refused connection to %s: %s", h.ProxyAddress, address, resp.Status)
+	}
+	if br.Buffered() != 0 {
+		conn.Close()
+		return nil, errors.New("http connect: BUG: buffered bytes beyond the response")
+	}
+	return conn, nil
+}
+
+// byteReader hands out at most one byte per Read, so a bufio.Reader
+// wrapping it never reads past what's been asked for.
+type byteReader struct {
+	r io.Reader
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// dialer returns the Dialer to use for this config, along with the
+// network and address to call it with.
+func (c *VNCSessionConfig) dialer() (Dialer, string, string, error) {
+	if c.Dialer != nil {
+		return c.Dialer, "tcp", c.Address, nil
+	}
+	if c.ProxyCommand != "" {
+		address, err := withDefaultPort(c.Address)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return &CommandDialer{Command: c.ProxyCommand}, "tcp", address, nil
+	}
+	return ParseAddress(c.Address)
+}
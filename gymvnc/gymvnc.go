@@ -1,8 +1,10 @@
 package gymvnc
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -44,13 +46,84 @@ type VNCSessionConfig struct {
 	Password string
 	Encoding string
 
+	// Dialer, if set, is used to connect to Address as-is. Otherwise
+	// the dialer is chosen from the scheme of Address; see
+	// ParseAddress.
+	Dialer Dialer
+
+	// ProxyCommand, if set, is run to reach Address, and RFB is
+	// spoken over its stdin and stdout. See CommandDialer.
+	ProxyCommand string
+
+	// Repeater, if set, means Address is an UltraVNC repeater, and
+	// names the server to be put through to: "ID:xxxx" for mode 2,
+	// or host:port for mode 1.
+	Repeater string
+
+	// Listener, if set, makes this a reverse connection: rather than
+	// dialing Address, the session waits for a server to connect to
+	// the Listener. ListenID and ListenDesktopName, if set, restrict
+	// which connection it takes. StartTimeout bounds the wait.
+	Listener          *Listener
+	ListenID          string
+	ListenDesktopName string
+
+	// PixelFormat names the format the server sends pixels in:
+	// rgb888 (the default), rgb565, rgb332, or bgr233, which uses an
+	// 8-bit color map. Screens are 8 bits per channel whichever is
+	// used, but the smaller formats take less bandwidth.
+	PixelFormat string
+
 	QualityLevel     int // 0-9, 9 being top quality. Not orthogonal to FineQualityLevel/SubsampleLevel, see https://github.com/TurboVNC/turbovnc/blob/master/unix/Xvnc/programs/Xserver/hw/vnc/rfbserver.c#L1103-L1112
 	CompressLevel    int // 0-9, 9 being highest compression
 	FineQualityLevel int // 0-100, 100 being top quality
 	SubsampleLevel   int // 0-3, 3 being grayscale; 0 being full color
 
 	StartTimeout time.Duration
+	Backoff      Backoff
 	Subscription []Region
+
+	// ReadTimeout and WriteTimeout, if positive, bound reading each
+	// server message and sending each client message. See
+	// vncclient.ClientConfig.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Limits bounds what the server can make us allocate. See
+	// vncclient.Limits.
+	Limits vncclient.Limits
+
+	// JPEGWorkers is how many goroutines decode Tight JPEG rectangles.
+	// See vncclient.ClientConfig.
+	JPEGWorkers int
+
+	// FlushEachEvent sends each of a step's events in a write of its
+	// own, rather than all of them in one. See vncclient.ClientConfig.
+	FlushEachEvent bool
+
+	// KeepAlive is the TCP keepalive period for TCP connections.
+	// Zero means 30s; negative disables keepalives.
+	KeepAlive time.Duration
+
+	// UpdateTimeout, if positive, is how long to wait for a
+	// framebuffer update after requesting one. Servers only answer
+	// an incremental request once something changes, so if none
+	// arrives, the session asks for a full refresh, which they must
+	// answer, and fails only if that goes unanswered too. An idle
+	// desktop is refreshed every UpdateTimeout.
+	UpdateTimeout time.Duration
+	// UpdateTimeoutRefresh is no longer needed, since the refresh
+	// is always asked for. It's kept so that configurations that
+	// set it still build.
+	UpdateTimeoutRefresh bool
+
+	// Reconnect, if set, re-establishes a connection that fails
+	// after having been set up, rather than failing the session.
+	// The last screen is kept while reconnecting; see Outage.
+	// ReconnectTimeout, if positive, bounds how long each
+	// reconnection may take, with attempts spaced out by Backoff.
+	Reconnect        bool
+	ReconnectTimeout time.Duration
 }
 
 type VNCSession struct {
@@ -60,6 +133,20 @@ type VNCSession struct {
 	mgr  *sessionMgr
 	conn *vncclient.ClientConn
 
+	// dialing is the transport of a connection attempt still in its
+	// handshake, so Close can tear it down (and, for a proxy
+	// command, its process).
+	dialing net.Conn
+
+	// attempts not yet returned by ConnectAttempts
+	attempts []ConnectAttempt
+
+	// outage is the current outage, or the last one if it hasn't
+	// been returned by Outage yet
+	outage *Outage
+
+	watchdog updateWatchdog
+
 	frontScreen        *Screen
 	backScreen         *Screen
 	backUpdated        bool
@@ -68,6 +155,18 @@ type VNCSession struct {
 	pauseUpdates       bool
 	updated            *sync.Cond
 
+	// unreceived counts the updates the connection has started
+	// painting into the back screen that maintainFrameBuffer hasn't
+	// received yet. Flip waits for it to be zero, so that each update
+	// is flipped to whole, along with its message.
+	unreceived int
+
+	// painted are the rectangles the connection has painted into the
+	// back screen since the last flip. They become stale: painted in
+	// the front screen but not yet the back.
+	painted []vncclient.Rectangle
+	stale   []vncclient.Rectangle
+
 	renderer       Renderer
 	rendererActive bool
 
@@ -120,6 +219,14 @@ func NewVNCSession(name string, c VNCSessionConfig) *VNCSession {
 		c.Encoding = "tight"
 	}
 
+	if c.PixelFormat == "" {
+		c.PixelFormat = "rgb888"
+	}
+
+	if c.Listener != nil && c.Address == "" {
+		c.Address = "listen:" + c.Listener.Addr().String()
+	}
+
 	lock := &sync.Mutex{}
 	session := &VNCSession{
 		name:               name,
@@ -133,6 +240,12 @@ func NewVNCSession(name string, c VNCSessionConfig) *VNCSession {
 		label: fmt.Sprintf("%s:%s", name, c.Address),
 	}
 	id++
+	if err := checkPixelFormat(c.PixelFormat); err != nil {
+		// No server could help, so don't connect to one; Step
+		// returns the error.
+		session.err = err
+		return session
+	}
 	session.start()
 	return session
 }
@@ -146,6 +259,7 @@ func (c *VNCSession) start() {
 
 	// Maintains the connection to the remote
 	go func() {
+		defer c.recoverPanic()
 		err := c.connect(updates)
 		if err != nil {
 			// Try reporting the error
@@ -180,6 +294,11 @@ func (c *VNCSession) Close() error {
 		log.Debugf("[%s] closing connection to VNC server", c.label)
 		c.conn.Close()
 	}
+	if c.dialing != nil {
+		log.Debugf("[%s] aborting connection attempt", c.label)
+		c.dialing.Close()
+		c.dialing = nil
+	}
 	if c.rendererActive {
 		// This can *only* be called from the main thread, so
 		// we can't auto-clean it up on error.
@@ -192,6 +311,7 @@ func (c *VNCSession) Step(events []VNCEvent) (*Screen, []*vncclient.FramebufferU
 	c.lock.Lock()
 	conn := c.conn
 	err := c.err
+	reconnecting := c.reconnecting()
 	c.lock.Unlock()
 
 	if err != nil {
@@ -202,12 +322,38 @@ func (c *VNCSession) Step(events []VNCEvent) (*Screen, []*vncclient.FramebufferU
 		return nil, nil, nil
 	}
 
+	if reconnecting {
+		// Nobody to send the events to, but the last screen
+		// is still worth returning.
+		if len(events) > 0 {
+			log.Debugf("[%s] dropping %d events while reconnecting", c.label, len(events))
+		}
+		events = nil
+	}
+
+	// Send the events in one write, other than any that can't be
+	// batched, which go in order after those before them.
+	var batch vncclient.EventBatch
 	for _, event := range events {
-		err := event.Execute(conn)
+		if event, ok := event.(batchedEvent); ok {
+			event.addTo(&batch)
+			continue
+		}
+		if err = conn.SendEvents(&batch); err == nil {
+			err = event.Execute(conn)
+		}
 		if err != nil {
-			return nil, nil, errors.Annotatef(err, "could not step %s", c.config.Address)
+			break
 		}
 	}
+	if err == nil {
+		err = conn.SendEvents(&batch)
+	}
+	if err != nil && c.config.Reconnect {
+		c.connFailed(errors.Annotate(err, "could not send event"))
+	} else if err != nil {
+		return nil, nil, errors.Annotatef(err, "could not step %s", c.config.Address)
+	}
 
 	screen, updates := c.Flip()
 	return screen, updates, nil
@@ -262,10 +408,12 @@ func (c *VNCSession) Flip() (*Screen, []*vncclient.FramebufferUpdateMessage) {
 
 	var updates []*vncclient.FramebufferUpdateMessage
 
-	if c.backUpdated {
+	if c.backUpdated && c.unreceived == 0 {
 		c.frontScreen, c.backScreen = c.backScreen, c.frontScreen
 		c.backUpdated = false
+		c.painted, c.stale = c.stale, c.painted
 		updates = c.deferredUpdates
+		c.deferredUpdates = nil
 		go func() {
 			c.updated.L.Lock()
 			defer c.updated.L.Unlock()
@@ -278,78 +426,86 @@ func (c *VNCSession) Flip() (*Screen, []*vncclient.FramebufferUpdateMessage) {
 				log.Infof("[%s] resuming updates", c.label)
 				err := c.requestUpdate()
 				if err != nil {
-					select {
-					case c.mgr.Error <- err:
-					default:
-					}
+					c.connFailed(errors.Annotate(err, "could not send framebuffer update request"))
 				}
 			}
 		}()
 
 		if c.rendererActive {
 			// Keep the GL screen fed
-			c.renderer.Apply(updates)
+			c.renderer.Apply(c.frontScreen.Data, updates)
 		}
 	}
 
 	return c.frontScreen, updates
 }
 
-// Apply any deferred updates *while holding the lock*
-func (c *VNCSession) applyDeferred() error {
+// Bring the back screen up to date with the front, by copying across
+// the stale rectangles, *while holding the lock*
+func (c *VNCSession) applyDeferred() {
 	if c.backUpdated {
-		return nil
+		return
 	}
 	c.backUpdated = true
 
-	for _, update := range c.deferredUpdates {
-		c.applyUpdate(update)
+	width := int(c.backScreen.Width)
+	for _, rect := range c.stale {
+		for y := int(rect.Y); y < int(rect.Y)+int(rect.Height); y++ {
+			start := y*width + int(rect.X)
+			end := start + int(rect.Width)
+			copy(c.backScreen.Data[start:end], c.frontScreen.Data[start:end])
+		}
 	}
-	c.deferredUpdates = nil
-	return nil
+	c.stale = c.stale[:0]
 }
 
-// Apply an update *while holding the lock*
-func (c *VNCSession) applyUpdate(update *vncclient.FramebufferUpdateMessage) error {
-	var bytes uint32
-	start := time.Now().UnixNano()
-	for _, rect := range update.Rectangles {
-		switch enc := rect.Enc.(type) {
-		case *vncclient.RawEncoding:
-			bytes += c.applyRect(c.conn, rect, enc.Colors)
-		case *vncclient.ZRLEEncoding:
-			bytes += c.applyRect(c.conn, rect, enc.Colors)
-		case *vncclient.TightEncoding:
-			bytes += c.applyRect(c.conn, rect, enc.Colors)
-		default:
-			return errors.Errorf("unsupported encoding: %T", enc)
-		}
-	}
-	delta := time.Now().UnixNano() - start
-	log.Debugf("[%s] Update complete: time=%dus type=%T rectangles=%+v bytes=%d", c.label, delta/1000, update, len(update.Rectangles), bytes)
-	return nil
+// lockBack takes the lock for the connection to paint rect into the
+// back screen, bringing it up to date first, and returns rect's pixels
+// in it. If there is no back screen yet, or rect doesn't fit in it, it
+// returns nil without taking the lock.
+func (c *VNCSession) lockBack(rect *vncclient.Rectangle) ([]vncclient.Color, int) {
+	c.updated.L.Lock()
+	screen := c.backScreen
+	if screen == nil || int(rect.X)+int(rect.Width) > int(screen.Width) || int(rect.Y)+int(rect.Height) > int(screen.Height) {
+		c.updated.L.Unlock()
+		return nil, 0
+	}
+	c.applyDeferred()
+	return screen.Data[int(rect.Y)*int(screen.Width)+int(rect.X):], int(screen.Width)
 }
 
-func (c *VNCSession) applyRect(conn *vncclient.ClientConn, rect vncclient.Rectangle, colors []vncclient.Color) uint32 {
-	var bytes uint32
-	// var wg sync.WaitGroup
-	// wg.Add(int(rect.Height))
-	for y := uint32(0); y < uint32(rect.Height); y++ {
-		// go func(y uint32) {
-		encStart := uint32(rect.Width) * y
-		encEnd := encStart + uint32(rect.Width)
-
-		screenStart := uint32(conn.FramebufferWidth)*(uint32(rect.Y)+y) + uint32(rect.X)
-		screenEnd := screenStart + uint32(rect.Width)
+// beginUpdate notes that the connection is starting to paint an update
+// into the back screen.
+func (c *VNCSession) beginUpdate() {
+	c.updated.L.Lock()
+	c.unreceived++
+	c.updated.L.Unlock()
+}
 
-		bytes += encEnd - encStart
+// abandonUpdate notes that an update the connection started painting
+// failed, and so will never be received.
+func (c *VNCSession) abandonUpdate() {
+	c.updated.L.Lock()
+	c.received()
+	c.updated.L.Unlock()
+}
 
-		copy(c.backScreen.Data[screenStart:screenEnd], colors[encStart:encEnd])
-		// wg.Done()
-		// }(y)
+// received notes that an update has been received or abandoned, *while
+// holding the lock*. Updates painted before the session was attached
+// to a reverse connection were never counted.
+func (c *VNCSession) received() {
+	if c.unreceived > 0 {
+		c.unreceived--
 	}
-	// wg.Wait()
-	return bytes
+}
+
+// unlockBack records that rect has been painted into the back screen,
+// and releases the lock taken by lockBack.
+func (c *VNCSession) unlockBack(rect *vncclient.Rectangle) {
+	painted := *rect
+	painted.Enc = nil
+	c.painted = append(c.painted, painted)
+	c.updated.L.Unlock()
 }
 
 func (c *VNCSession) maintainFrameBuffer(updates chan *vncclient.FramebufferUpdateMessage) error {
@@ -359,15 +515,10 @@ func (c *VNCSession) maintainFrameBuffer(updates chan *vncclient.FramebufferUpda
 		select {
 		case update := <-updates:
 			c.updated.L.Lock()
-			if err := c.applyDeferred(); err != nil {
-				c.updated.L.Unlock()
-				return errors.Annotate(err, "when applying deferred updates")
-			}
-
-			if err := c.applyUpdate(update); err != nil {
-				c.updated.L.Unlock()
-				return errors.Annotate(err, "when applying new update")
-			}
+			// The connection has already painted the update into the
+			// back screen, so it just needs passing on at the next flip
+			c.applyDeferred()
+			c.received()
 			c.deferredUpdates = append(c.deferredUpdates, update)
 
 			if len(c.deferredUpdates) >= c.deferredUpdatesMax && !c.pauseUpdates {
@@ -390,22 +541,57 @@ func (c *VNCSession) maintainFrameBuffer(updates chan *vncclient.FramebufferUpda
 	}
 }
 
+// recoverPanic is deferred by each of the session's goroutines, so that
+// a panic fails just this session rather than the whole process.
+// Panics while decoding are already DecodeErrors by the time they get
+// here, so anything else is a bug of ours, and reported as such.
+func (c *VNCSession) recoverPanic() {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := errors.Errorf("[%s] internal error: panic: %v\n%s", c.label, v, debug.Stack())
+	log.Error(err)
+	select {
+	case c.mgr.Error <- err:
+	default:
+	}
+}
+
 func (c *VNCSession) SetSubscription(subs []Region) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 	c.config.Subscription = subs
 }
 
+// requestUpdate continues the incremental update cycle. It does
+// nothing while reconnecting, since the new connection will start
+// with a full update.
 func (c *VNCSession) requestUpdate() error {
+	c.lock.Lock()
+	conn := c.conn
+	reconnecting := c.reconnecting()
+	c.lock.Unlock()
+
+	if reconnecting {
+		return nil
+	}
+	c.watchdog.requested(time.Now())
+	return c.sendUpdateRequest(conn, true)
+}
+
+// sendUpdateRequest asks for the subscribed regions, or else the
+// whole screen.
+func (c *VNCSession) sendUpdateRequest(conn *vncclient.ClientConn, incremental bool) error {
 	if c.config.Subscription != nil {
 		for _, sub := range c.config.Subscription {
-			err := c.conn.FramebufferUpdateRequest(true, sub.X, sub.Y, sub.Width, sub.Height)
+			err := conn.FramebufferUpdateRequest(incremental, sub.X, sub.Y, sub.Width, sub.Height)
 			if err != nil {
 				return err
 			}
 		}
 	} else {
-		err := c.conn.FramebufferUpdateRequest(true, 0, 0, c.conn.FramebufferWidth, c.conn.FramebufferHeight)
+		err := conn.FramebufferUpdateRequest(incremental, 0, 0, conn.FramebufferWidth, conn.FramebufferHeight)
 		if err != nil {
 			return err
 		}
@@ -414,18 +600,108 @@ func (c *VNCSession) requestUpdate() error {
 }
 
 func (c *VNCSession) connect(updates chan *vncclient.FramebufferUpdateMessage) error {
+	conn, serverMessageCh, errorCh, err := c.open(c.config.StartTimeout, c.config.StartTimeout > 0)
+	if err != nil {
+		return err
+	}
+	if err := c.setup(conn, false); err != nil {
+		return err
+	}
+
+	// Spin up a screenbuffer thread
+	go func() {
+		defer c.recoverPanic()
+		err := c.maintainFrameBuffer(updates)
+		if err != nil {
+			// Report the error, if any
+			select {
+			case c.mgr.Error <- err:
+			default:
+			}
+		}
+	}()
+
+	for {
+		err := c.serve(conn, updates, serverMessageCh, errorCh)
+		if err == nil || !c.config.Reconnect {
+			return err
+		}
+		conn, serverMessageCh, errorCh, err = c.reconnect(conn, updates, err)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// open establishes a new connection to the server, either by dialing
+// it or by waiting for it on the Listener. timeout bounds how long
+// that may take, and retry is whether to retry soft dial failures.
+func (c *VNCSession) open(timeout time.Duration, retry bool) (*vncclient.ClientConn, chan vncclient.ServerMessage, chan error, error) {
+	if c.config.Listener != nil {
+		log.Infof("[%s] waiting for reverse connection from VNC server", c.label)
+
+		rc, err := c.config.Listener.accept(c.config.ListenID, c.config.ListenDesktopName, timeout, c.mgr.Done)
+		if err != nil {
+			return nil, nil, nil, errors.Annotate(err, "could not establish reverse VNC connection")
+		}
+		rc.painter.attach(c)
+		return rc.conn, rc.serverMessageCh, rc.errorCh, nil
+	}
+
 	log.Infof("[%s] opening connection to VNC server", c.label)
 
 	errorCh := make(chan error, 1)
 	serverMessageCh := make(chan vncclient.ServerMessage)
+	conn, err := c.dial(timeout, retry, serverMessageCh, errorCh)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return conn, serverMessageCh, errorCh, nil
+}
+
+// dial connects to the configured address. If retry is set, soft
+// failures are retried according to Backoff until timeout (if
+// positive) has been spent waiting. It gives up as soon as the
+// session is closed.
+func (c *VNCSession) dial(timeout time.Duration, retry bool, serverMessageCh chan vncclient.ServerMessage, errorCh chan error) (*vncclient.ClientConn, error) {
+	dialer, network, address, err := c.config.dialer()
+	if err != nil {
+		return nil, errors.Annotate(err, "could not establish VNC connection to server")
+	}
+	if c.config.Repeater != "" {
+		if err := validateRepeater(c.config.Repeater); err != nil {
+			return nil, errors.Annotate(err, "could not establish VNC connection to server")
+		}
+	}
+
+	backoff := c.config.Backoff.withDefaults()
+	retries := retry || backoff.MaxAttempts > 0
+
+	ctx, cancel := c.context()
+	defer cancel()
 
 	var conn *vncclient.ClientConn
 	totalSleep := 0 * time.Second
-	for i := 0; ; i++ {
+	for attempt := 1; ; attempt++ {
 		soft := true
-		target, err := net.Dial("tcp", c.config.Address)
+		target, err := dialContext(ctx, dialer, network, address)
+		if err != nil {
+			err = &vncclient.ConnectError{Err: err, Retry: true}
+		}
+		if err == nil && !c.setDialing(target) {
+			target.Close()
+			return nil, c.closedEarly()
+		}
+		if err == nil {
+			setKeepAlive(target, c.config.KeepAlive)
+		}
+		if err == nil && c.config.Repeater != "" {
+			if err, soft = repeaterHandshake(target, c.config.Repeater); err != nil {
+				target.Close()
+			}
+		}
 		if err == nil {
-			conn, err, soft = vncclient.Client(target, &vncclient.ClientConfig{
+			conn, err, soft = vncclient.ClientWithContext(ctx, target, &vncclient.ClientConfig{
 				Auth: []vncclient.ClientAuth{
 					&vncclient.PasswordAuth{
 						Password: c.config.Password,
@@ -433,58 +709,112 @@ func (c *VNCSession) connect(updates chan *vncclient.FramebufferUpdateMessage) e
 				},
 				ServerMessageCh: serverMessageCh,
 				ErrorCh:         errorCh,
+				ReadTimeout:     c.config.ReadTimeout,
+				WriteTimeout:    c.config.WriteTimeout,
+				Limits:          c.config.Limits,
+				JPEGWorkers:     c.config.JPEGWorkers,
+				FlushEachEvent:  c.config.FlushEachEvent,
+				Framebuffer:     &screenPainter{session: c},
 			})
 		}
-		if soft == true && c.config.StartTimeout > 0 {
-			if totalSleep >= c.config.StartTimeout {
-				return errors.Annotatef(err, "could not establish VNC connection to server, and exceeded start timeout of %s by sleeping for %s", c.config.StartTimeout, totalSleep)
-			}
-
-			// Choose how long to sleep
-			sleepTarget := time.Duration(2*(i+1)) * time.Second
-			if sleepTarget > time.Duration(30)*time.Second {
-				sleepTarget = time.Duration(30) * time.Second
-			}
-
-			log.Infof("[%s] Waiting on VNC server: %s. Retrying in %s (%s/%s)", c.label, err, sleepTarget, totalSleep, c.config.StartTimeout)
+		c.setDialing(nil)
 
-			totalSleep += sleepTarget
-			time.Sleep(sleepTarget)
-		} else if err != nil {
-			return errors.Annotate(err, "could not establish VNC connection to server")
-		} else {
+		if err == nil {
+			c.recordAttempt(ConnectAttempt{Attempt: attempt})
 			break
 		}
-	}
+		if ctx.Err() != nil {
+			return nil, c.closedEarly()
+		}
 
-	defer func() {
-		c.lock.Lock()
-		if c.conn == nil {
-			// We never stored the conn object, so we need
-			// to close it ourselves.
-			log.Infof("[%s] autoclosing connection to VNC server", c.label)
-			conn.Close()
+		if !soft || !retries {
+			c.recordAttempt(ConnectAttempt{Attempt: attempt, Err: err})
+			return nil, errors.Annotate(err, "could not establish VNC connection to server")
 		}
-		c.lock.Unlock()
-	}()
+		if timeout > 0 && totalSleep >= timeout {
+			c.recordAttempt(ConnectAttempt{Attempt: attempt, Err: err})
+			return nil, errors.Annotatef(err, "could not establish VNC connection to server, and exceeded timeout of %s by sleeping for %s", timeout, totalSleep)
+		}
+		if backoff.MaxAttempts > 0 && attempt >= backoff.MaxAttempts {
+			c.recordAttempt(ConnectAttempt{Attempt: attempt, Err: err})
+			return nil, errors.Annotatef(err, "could not establish VNC connection to server after %d attempts", attempt)
+		}
+
+		delay := backoff.delay(attempt)
+		c.recordAttempt(ConnectAttempt{Attempt: attempt, Err: err, Retry: true, Delay: delay})
+		log.Infof("[%s] Waiting on VNC server: %s. Retrying in %s (%s/%s)", c.label, err, delay, totalSleep, timeout)
 
+		totalSleep += delay
+		select {
+		case <-time.After(delay):
+		case <-c.mgr.Done:
+			return nil, c.closedEarly()
+		}
+	}
+	return conn, nil
+}
+
+// context returns a context that is cancelled once the session is
+// closed, or cancel is called.
+func (c *VNCSession) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		select {
-		case err := <-errorCh:
-			c.mgr.Error <- errors.Annotatef(err, "[%s] vnc error", c.label)
 		case <-c.mgr.Done:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
+	return ctx, cancel
+}
 
-	// While the VNC protocol supports more exotic formats, we
-	// only want straight RGB with 1 byte per color.
-	c.frontScreen = NewScreen(conn.FramebufferWidth, conn.FramebufferHeight)
-	c.backScreen = NewScreen(conn.FramebufferWidth, conn.FramebufferHeight)
+// recordAttempt queues a connection attempt to be reported by
+// ConnectAttempts.
+func (c *VNCSession) recordAttempt(attempt ConnectAttempt) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.attempts = append(c.attempts, attempt)
+}
+
+// ConnectAttempts returns the connection attempts made since it was
+// last called.
+func (c *VNCSession) ConnectAttempts() []ConnectAttempt {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	attempts := c.attempts
+	c.attempts = nil
+	return attempts
+}
+
+// closedEarly is the error for a connection abandoned because the
+// session was closed while it was being set up.
+func (c *VNCSession) closedEarly() error {
+	return &vncclient.ClosedError{
+		Err: errors.Errorf("[%s] VNCSession object was closed before connection was established", c.label),
+	}
+}
+
+// setDialing records the transport of the connection attempt in
+// progress. It returns false if the session has already been closed.
+func (c *VNCSession) setDialing(target net.Conn) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.dialing = target
+	return true
+}
 
-	err := conn.SetPixelFormat(&vncclient.PixelFormat{
+// pixelFormats are the formats VNCSessionConfig.PixelFormat can name.
+// Whichever is asked for, vncclient expands pixels to 8 bits per
+// channel.
+var pixelFormats = map[string]vncclient.PixelFormat{
+	// 4 bytes a pixel, one each of red, green and blue
+	"rgb888": {
 		BPP:        32,
 		Depth:      24,
-		BigEndian:  false,
 		TrueColor:  true,
 		RedMax:     255,
 		GreenMax:   255,
@@ -492,7 +822,84 @@ func (c *VNCSession) connect(updates chan *vncclient.FramebufferUpdateMessage) e
 		RedShift:   0,
 		GreenShift: 8,
 		BlueShift:  16,
-	})
+	},
+	// 2 bytes a pixel: 5 bits of red, 6 of green and 5 of blue
+	"rgb565": {
+		BPP:        16,
+		Depth:      16,
+		TrueColor:  true,
+		RedMax:     31,
+		GreenMax:   63,
+		BlueMax:    31,
+		RedShift:   11,
+		GreenShift: 5,
+		BlueShift:  0,
+	},
+	// 1 byte a pixel: 3 bits of red, 3 of green and 2 of blue
+	"rgb332": {
+		BPP:        8,
+		Depth:      8,
+		TrueColor:  true,
+		RedMax:     7,
+		GreenMax:   7,
+		BlueMax:    3,
+		RedShift:   5,
+		GreenShift: 2,
+		BlueShift:  0,
+	},
+	// 1 byte a pixel, indexing a color map the server sets with
+	// SetColorMapEntries. Servers such as libvncserver's fill it with
+	// a BGR233 palette.
+	"bgr233": {
+		BPP:   8,
+		Depth: 8,
+	},
+}
+
+// checkPixelFormat returns an error unless VNCSessionConfig.PixelFormat
+// can name format.
+func checkPixelFormat(format string) error {
+	if _, ok := pixelFormats[format]; !ok {
+		return errors.Errorf("invalid pixel format: %s", format)
+	}
+	return nil
+}
+
+// setup takes an established connection through pixel format and
+// encoding negotiation, asks for its first update, and then makes it
+// the session's connection. A reconnection asks for a full update, and
+// must have the same framebuffer size as the connection it replaces.
+func (c *VNCSession) setup(conn *vncclient.ClientConn, reconnect bool) error {
+	defer func() {
+		c.lock.Lock()
+		if c.conn != conn {
+			// We never stored the conn object, so we need
+			// to close it ourselves.
+			log.Infof("[%s] autoclosing connection to VNC server", c.label)
+			conn.Close()
+		}
+		c.lock.Unlock()
+	}()
+
+	if !reconnect {
+		// Whatever the pixel format, screens are straight RGB with
+		// 1 byte per color.
+		c.updated.L.Lock()
+		c.frontScreen = NewScreen(conn.FramebufferWidth, conn.FramebufferHeight)
+		c.backScreen = NewScreen(conn.FramebufferWidth, conn.FramebufferHeight)
+		c.updated.L.Unlock()
+	} else {
+		c.updated.L.Lock()
+		width, height := c.frontScreen.Width, c.frontScreen.Height
+		c.updated.L.Unlock()
+		if conn.FramebufferWidth != width || conn.FramebufferHeight != height {
+			return errors.Errorf("[%s] framebuffer changed size from %dx%d to %dx%d across reconnect", c.label, width, height, conn.FramebufferWidth, conn.FramebufferHeight)
+		}
+	}
+
+	// NewVNCSession checked the pixel format.
+	format := pixelFormats[c.config.PixelFormat]
+	err := conn.SetPixelFormat(&format)
 	if err != nil {
 		return errors.Annotate(err, "could not set pixel format")
 	}
@@ -530,58 +937,87 @@ func (c *VNCSession) connect(updates chan *vncclient.FramebufferUpdateMessage) e
 		return errors.Annotate(err, "could not set encodings")
 	}
 
-	c.lock.Lock()
-	// Make the connection visible so it can be used in requestUpdate
-	c.conn = conn
-
-	err = c.requestUpdate()
+	err = c.sendUpdateRequest(conn, !reconnect)
 	if err != nil {
-		c.conn = nil
-		c.lock.Unlock()
 		return errors.Annotate(err, "could not send framebuffer update request")
 	}
+	c.watchdog.received()
+	c.watchdog.requested(time.Now())
 
+	c.lock.Lock()
 	if c.closed {
-		c.conn = nil
 		c.lock.Unlock()
 		// Conn will be closed by our earlier defer
-		return errors.Errorf("[%s] VNCSession object was closed before connection was established", c.label)
+		return c.closedEarly()
+	}
+	// Make the connection visible so it can be used in requestUpdate
+	c.conn = conn
+	if reconnect {
+		c.outage.Reconnected = true
+		c.outage.Duration = time.Since(c.outage.Start)
+		log.Infof("[%s] connection re-established after %s", c.label, c.outage.Duration)
+	} else {
+		log.Infof("[%s] connection established", c.label)
 	}
-	log.Infof("[%s] connection established", c.label)
 	c.lock.Unlock()
+	return nil
+}
 
-	// Spin up a screenbuffer thread
-	go func() {
-		err := c.maintainFrameBuffer(updates)
-		if err != nil {
-			// Report the error, if any
-			select {
-			case c.mgr.Error <- err:
-			default:
-			}
-		}
-	}()
+// serve pumps updates from conn until the session is closed, which
+// returns nil, or the connection fails.
+func (c *VNCSession) serve(conn *vncclient.ClientConn, updates chan *vncclient.FramebufferUpdateMessage, serverMessageCh chan vncclient.ServerMessage, errorCh chan error) error {
+	// A nil channel never fires, so without an UpdateTimeout the
+	// watchdog stays out of the way.
+	var watchdogTick <-chan time.Time
+	if c.config.UpdateTimeout > 0 {
+		ticker := time.NewTicker(watchdogInterval(c.config.UpdateTimeout))
+		defer ticker.Stop()
+		watchdogTick = ticker.C
+	}
 
 	for {
 		select {
+		case now := <-watchdogTick:
+			switch c.watchdog.check(now, c.config.UpdateTimeout) {
+			case watchdogRefresh:
+				log.Infof("[%s] no framebuffer update within %s of requesting one; requesting a full refresh", c.label, c.config.UpdateTimeout)
+				err := c.sendUpdateRequest(conn, false)
+				if err != nil {
+					return errors.Annotate(err, "could not send framebuffer refresh request")
+				}
+			case watchdogDead:
+				return &vncclient.ConnectError{
+					Err:   errors.Errorf("[%s] no framebuffer update within %s of requesting one; server appears stalled", c.label, c.config.UpdateTimeout),
+					Retry: true,
+				}
+			}
 		case msg := <-serverMessageCh:
 			log.Debugf("[%s] Just received: %T %+v", c.label, msg, msg)
 			switch msg := msg.(type) {
 			case *vncclient.FramebufferUpdateMessage:
+				c.watchdog.received()
 				updates <- msg
 				// Keep re-requesting!
 				c.updated.L.Lock()
+				var err error
 				if !c.pauseUpdates {
-					err := c.requestUpdate()
-					if err != nil {
-						select {
-						case c.mgr.Error <- err:
-						default:
-						}
-					}
+					err = c.requestUpdate()
 				}
 				c.updated.L.Unlock()
+				if err != nil {
+					return errors.Annotate(err, "could not send framebuffer update request")
+				}
 			}
+		case err := <-errorCh:
+			return c.vncError(err)
+		case <-conn.Done():
+			select {
+			case <-c.mgr.Done:
+				// Closed along with the session
+				return nil
+			default:
+			}
+			return c.vncError(conn.Err())
 		case <-c.mgr.Done:
 			log.Debugf("[%s] server message goroutine exiting", c.label)
 			return nil
@@ -589,13 +1025,24 @@ func (c *VNCSession) connect(updates chan *vncclient.FramebufferUpdateMessage) e
 	}
 }
 
+// vncError annotates an error that ended the connection, logging where
+// it panicked if it was a panic the ClientConn recovered from.
+func (c *VNCSession) vncError(err error) error {
+	if e, ok := err.(*vncclient.DecodeError); ok && e.Stack != nil {
+		log.Errorf("[%s] %s\n%s", c.label, e, e.Stack)
+	}
+	return errors.Annotatef(err, "[%s] vnc error", c.label)
+}
+
 type VNCBatch struct {
-	sessions map[string]*VNCSession
+	sessions  map[string]*VNCSession
+	listeners map[string]*Listener
 }
 
 func NewVNCBatch() *VNCBatch {
 	return &VNCBatch{
-		sessions: map[string]*VNCSession{},
+		sessions:  map[string]*VNCSession{},
+		listeners: map[string]*Listener{},
 	}
 }
 
@@ -603,17 +1050,72 @@ func (v *VNCBatch) Close(name string) error {
 	if session, ok := v.sessions[name]; ok {
 		session.Close()
 		delete(v.sessions, name)
+		v.releaseListener(session.config.Listener)
 	}
 	return nil
 }
 
+// Listen opens a session that waits for a reverse connection on
+// address. Sessions listening on the same address share a Listener,
+// which must be opened with the same password.
+func (v *VNCBatch) Listen(name, address, password string, config VNCSessionConfig) error {
+	if config.PixelFormat != "" {
+		if err := checkPixelFormat(config.PixelFormat); err != nil {
+			return err
+		}
+	}
+
+	listener, ok := v.listeners[address]
+	if !ok {
+		var err error
+		listener, err = Listen(address, password)
+		if err != nil {
+			return err
+		}
+		v.listeners[address] = listener
+	} else if listener.password != password {
+		return errors.Errorf("already listening on %s with a different password", address)
+	}
+
+	config.Listener = listener
+	return v.Open(name, config)
+}
+
+// releaseListener closes listener once no session uses it.
+func (v *VNCBatch) releaseListener(listener *Listener) {
+	if listener == nil {
+		return
+	}
+	for _, session := range v.sessions {
+		if session.config.Listener == listener {
+			return
+		}
+	}
+	for address, l := range v.listeners {
+		if l == listener {
+			listener.Close()
+			delete(v.listeners, address)
+		}
+	}
+}
+
 func (v *VNCBatch) Open(name string, config VNCSessionConfig) error {
-	if evicted, ok := v.sessions[name]; ok {
+	if config.PixelFormat != "" {
+		if err := checkPixelFormat(config.PixelFormat); err != nil {
+			return err
+		}
+	}
+
+	evicted, ok := v.sessions[name]
+	if ok {
 		evicted.Close()
 	}
 
 	session := NewVNCSession(name, config)
 	v.sessions[name] = session
+	if ok {
+		v.releaseListener(evicted.config.Listener)
+	}
 	return nil
 }
 
@@ -633,6 +1135,16 @@ func (v *VNCBatch) Step(actions map[string][]VNCEvent) (map[string]*Screen, map[
 	return observationN, updatesN, errN
 }
 
+func (v *VNCBatch) ConnectAttempts() map[string][]ConnectAttempt {
+	attemptsN := map[string][]ConnectAttempt{}
+	for name, session := range v.sessions {
+		if attempts := session.ConnectAttempts(); len(attempts) > 0 {
+			attemptsN[name] = attempts
+		}
+	}
+	return attemptsN
+}
+
 func (v *VNCBatch) SetSubscription(name string, subs []Region) error {
 	if session, ok := v.sessions[name]; ok {
 		session.SetSubscription(subs)
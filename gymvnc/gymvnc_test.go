@@ -0,0 +1,550 @@
+package gymvnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// newOverflowingServer starts a server that sends a single raw
+// rectangle hanging off the bottom right of its 640x480 desktop.
+func newOverflowingServer(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := serveMockRFB(c, "overflowing"); err != nil {
+			return
+		}
+		// Wait to be asked, so the client is done setting up
+		for {
+			msgType, _, err := readClientMessage(c)
+			if err != nil {
+				return
+			} else if msgType == 3 {
+				break
+			}
+		}
+		// A 100x1 rectangle at (600, 479)
+		msg := []byte{0, 0, 0, 1, 0x02, 0x58, 0x01, 0xdf, 0, 100, 0, 1, 0, 0, 0, 0}
+		msg = append(msg, make([]byte, 4*100)...)
+		if _, err := c.Write(msg); err != nil {
+			return
+		}
+		for {
+			if _, _, err := readClientMessage(c); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestBatch_BadRectangleFailsOnlyItsSession(t *testing.T) {
+	badAddr, closeBad := newOverflowingServer(t)
+	defer closeBad()
+	goodAddr, closeGood := newMockRFBServer(t, "good")
+	defer closeGood()
+
+	batch := NewVNCBatch()
+	batch.Open("bad", VNCSessionConfig{Address: badAddr, Encoding: "raw"})
+	defer batch.Close("bad")
+	batch.Open("good", VNCSessionConfig{Address: goodAddr, Encoding: "raw"})
+	defer batch.Close("good")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, _, errs := batch.Step(map[string][]VNCEvent{"bad": nil, "good": nil})
+		if errs["good"] != nil {
+			t.Fatalf("error in the good session: %s", errs["good"])
+		}
+		if err := errs["bad"]; err != nil {
+			if _, ok := errors.Cause(err).(*vncclient.ProtocolError); !ok {
+				t.Fatalf("got %T %v, want a ProtocolError for the bad rectangle", errors.Cause(err), err)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("bad rectangle never failed its session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, _, errs := batch.Step(map[string][]VNCEvent{"good": {KeyEvent{Keysym: 0x61, Down: true}}})
+	if errs["good"] != nil {
+		t.Errorf("good session failed along with the bad one: %s", errs["good"])
+	}
+}
+
+func TestVNCSession_RecoverPanic(t *testing.T) {
+	session := &VNCSession{mgr: NewSessionMgr()}
+	func() {
+		defer session.recoverPanic()
+		var screen *Screen
+		_ = screen.Data[0]
+	}()
+
+	// It's a bug of ours, not the server's pixel data
+	select {
+	case err := <-session.mgr.Error:
+		if _, ok := errors.Cause(err).(*vncclient.DecodeError); ok {
+			t.Errorf("got a DecodeError for a panic outside decoding: %v", err)
+		}
+		if msg := err.Error(); !strings.Contains(msg, "internal error: panic: ") || !strings.Contains(msg, "TestVNCSession_RecoverPanic") {
+			t.Errorf("got %v, want an internal error with the panic's stack", err)
+		}
+	default:
+		t.Error("panic not reported")
+	}
+}
+
+// newUpdatingServer starts a server that answers every update request
+// with perUpdate raw 64x64 rectangles, out of a row of rects across the
+// top of its desktop, taking turns, after waiting delay. The nth
+// update paints its rectangles a single gray of intensity n+1.
+func newUpdatingServer(tb testing.TB, rects, perUpdate int, delay time.Duration) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("error listening: %s", err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := serveMockRFB(c, "updating"); err != nil {
+			return
+		}
+
+		var update []byte
+		var n byte
+		next := 0
+		for {
+			msgType, _, err := readClientMessage(c)
+			if err != nil {
+				return
+			} else if msgType != 3 {
+				continue
+			}
+			time.Sleep(delay)
+
+			update = append(update[:0], 0, 0, 0, byte(perUpdate))
+			for i := 0; i < perUpdate; i++ {
+				x := 64 * next
+				next = (next + 1) % rects
+				update = append(update, byte(x>>8), byte(x), 0, 0, 0, 64, 0, 64, 0, 0, 0, 0)
+				for p := 0; p < 64*64; p++ {
+					update = append(update, n+1, n+1, n+1, 0)
+				}
+			}
+			n++
+			if _, err := c.Write(update); err != nil {
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+// TestVNCSession_FlipKeepsScreensConsistent checks that the screen a
+// session flips to has every update painted into it, including those
+// painted into the other screen before the previous flip.
+func TestVNCSession_FlipKeepsScreensConsistent(t *testing.T) {
+	// Updates slow enough that most flips come between them
+	addr, closeServer := newUpdatingServer(t, 4, 1, 3*time.Millisecond)
+	defer closeServer()
+
+	batch := NewVNCBatch()
+	batch.Open("flip", VNCSessionConfig{Address: addr, Encoding: "raw"})
+	defer batch.Close("flip")
+	waitForScreen(t, batch, "flip")
+
+	var seen int
+	deadline := time.Now().Add(5 * time.Second)
+	for seen < 40 {
+		screens, updates, errs := batch.Step(map[string][]VNCEvent{"flip": nil})
+		if errs["flip"] != nil {
+			t.Fatalf("unexpected error: %s", errs["flip"])
+		}
+		seen += len(updates["flip"])
+		if time.Now().After(deadline) {
+			t.Fatalf("only saw %d updates", seen)
+		}
+
+		// Each rectangle should be a single gray, and the latest
+		// update to paint it should be newer than those to paint the
+		// rectangles before it
+		screen := screens["flip"]
+		var grays [4]uint8
+		for i := range grays {
+			grays[i] = screen.Data[64*i].R
+			for y := 0; y < 64; y++ {
+				for x := 64 * i; x < 64*(i+1); x++ {
+					if c := screen.Data[y*int(screen.Width)+x]; c != (vncclient.Color{R: grays[i], G: grays[i], B: grays[i]}) {
+						t.Fatalf("pixel (%d, %d) is %v in a rectangle of %d", x, y, c, grays[i])
+					}
+				}
+			}
+		}
+		latest := 0
+		for i := range grays {
+			if grays[i] >= grays[latest] {
+				latest = i
+			}
+		}
+		for i := 1; i < 4; i++ {
+			prev := (latest - i + 4) % 4
+			if grays[latest] > uint8(i) && grays[prev] != grays[latest]-uint8(i) {
+				t.Fatalf("rectangles painted %v", grays)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestVNCSession_FlipMidUpdate flips while the connection is between
+// the two rectangles of an update, which shouldn't be flipped to until
+// it's whole.
+func TestVNCSession_FlipMidUpdate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer ln.Close()
+	release := make(chan bool)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := serveMockRFB(c, "halves"); err != nil {
+			return
+		}
+		for {
+			msgType, _, err := readClientMessage(c)
+			if err != nil {
+				return
+			} else if msgType == 3 {
+				break
+			}
+		}
+		// Two white 1x1 raw rectangles at (0, 0) and (1, 0), with a
+		// pause between them
+		first := []byte{0, 0, 0, 2, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 255, 255, 255, 0}
+		second := []byte{0, 1, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 255, 255, 255, 0}
+		if _, err := c.Write(first); err != nil {
+			return
+		}
+		<-release
+		if _, err := c.Write(second); err != nil {
+			return
+		}
+		for {
+			if _, _, err := readClientMessage(c); err != nil {
+				return
+			}
+		}
+	}()
+
+	batch := NewVNCBatch()
+	batch.Open("halves", VNCSessionConfig{Address: ln.Addr().String(), Encoding: "raw"})
+	defer batch.Close("halves")
+	waitForScreen(t, batch, "halves")
+
+	white := vncclient.Color{R: 255, G: 255, B: 255}
+	session := batch.sessions["halves"]
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		session.updated.L.Lock()
+		painted := session.backScreen.Data[0] == white
+		session.updated.L.Unlock()
+		if painted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first rectangle never painted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	screens, updates, errs := batch.Step(map[string][]VNCEvent{"halves": nil})
+	if errs["halves"] != nil {
+		t.Fatalf("unexpected error: %s", errs["halves"])
+	}
+	if screen := screens["halves"]; screen.Data[0] != (vncclient.Color{}) || len(updates["halves"]) != 0 {
+		t.Fatalf("flipped to half an update: pixel %v, %d updates", screen.Data[0], len(updates["halves"]))
+	}
+
+	close(release)
+	for {
+		screens, updates, errs = batch.Step(map[string][]VNCEvent{"halves": nil})
+		if errs["halves"] != nil {
+			t.Fatalf("unexpected error: %s", errs["halves"])
+		}
+		if len(updates["halves"]) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("update never flipped to")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if screen := screens["halves"]; screen.Data[0] != white || screen.Data[1] != white {
+		t.Errorf("got pixels %v and %v, want both white", screen.Data[0], screen.Data[1])
+	}
+}
+
+// BenchmarkVNCSession_Update measures a session receiving updates of
+// ten 64x64 raw rectangles and flipping them onto its screen.
+func BenchmarkVNCSession_Update(b *testing.B) {
+	// Log as in production, rather than formatting every message
+	ConfigureLogging()
+
+	addr, closeServer := newUpdatingServer(b, 10, 10, 0)
+	defer closeServer()
+
+	batch := NewVNCBatch()
+	batch.Open("bench", VNCSessionConfig{Address: addr, Encoding: "raw"})
+	defer batch.Close("bench")
+
+	step := func() int {
+		_, updates, errs := batch.Step(map[string][]VNCEvent{"bench": nil})
+		if errs["bench"] != nil {
+			b.Fatalf("unexpected error: %s", errs["bench"])
+		}
+		return len(updates["bench"])
+	}
+	for step() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; {
+		if got := step(); got > 0 {
+			n += got
+		} else {
+			// Spinning would starve the session's goroutines
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+}
+
+// soloKeyEvent is a VNCEvent that Step can't batch.
+type soloKeyEvent struct{ KeyEvent }
+
+func (k soloKeyEvent) Execute(c *vncclient.ClientConn) error {
+	return c.KeyEvent(k.Keysym, k.Down)
+}
+
+func TestVNCSession_StepSendsEventsInOrder(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer ln.Close()
+	events := make(chan []byte, 16)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := serveMockRFB(c, "events"); err != nil {
+			return
+		}
+		for {
+			msgType, body, err := readClientMessage(c)
+			if err != nil {
+				return
+			} else if msgType == 4 || msgType == 5 {
+				events <- append([]byte{msgType}, body...)
+			}
+		}
+	}()
+
+	batch := NewVNCBatch()
+	batch.Open("events", VNCSessionConfig{Address: ln.Addr().String(), Encoding: "raw"})
+	defer batch.Close("events")
+	waitForScreen(t, batch, "events")
+
+	_, _, errs := batch.Step(map[string][]VNCEvent{"events": {
+		KeyEvent{Keysym: 0x61, Down: true},
+		PointerEvent{Mask: vncclient.ButtonLeft, X: 1, Y: 2},
+		soloKeyEvent{KeyEvent{Keysym: 0x62, Down: true}},
+		KeyEvent{Keysym: 0x63, Down: true},
+	}})
+	if errs["events"] != nil {
+		t.Fatalf("unexpected error: %s", errs["events"])
+	}
+
+	expected := [][]byte{
+		{4, 1, 0, 0, 0, 0, 0, 0x61},
+		{5, 1, 0, 1, 0, 2},
+		{4, 1, 0, 0, 0, 0, 0, 0x62},
+		{4, 1, 0, 0, 0, 0, 0, 0x63},
+	}
+	for i, want := range expected {
+		select {
+		case got := <-events:
+			if !bytes.Equal(got, want) {
+				t.Fatalf("event %d: got %v, want %v", i, got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d never arrived", i)
+		}
+	}
+}
+
+// newPixelFormatServer starts a server that answers the first update
+// request with a 2x1 raw rectangle of the given pixels, having first
+// sent a BGR233 color map if the client asked for a color map format.
+// The format the client asked for is sent on the returned channel.
+func newPixelFormatServer(t *testing.T, pixels []byte) (string, <-chan vncclient.PixelFormat, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	formats := make(chan vncclient.PixelFormat, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if err := serveMockRFB(c, "pixels"); err != nil {
+			return
+		}
+
+		var format vncclient.PixelFormat
+		sent := false
+		for {
+			msgType, body, err := readClientMessage(c)
+			if err != nil {
+				return
+			}
+			switch {
+			case msgType == 0:
+				if err := vncclient.ReadPixelFormat(bytes.NewReader(body[3:]), &format); err != nil {
+					return
+				}
+				formats <- format
+			case msgType == 3 && !sent:
+				var msg bytes.Buffer
+				if !format.TrueColor {
+					// As libvncserver does: 3 bits of red, 3 of
+					// green and 2 of blue, from the bottom up
+					msg.Write([]byte{1, 0, 0, 0, 1, 0})
+					for i := 0; i < 256; i++ {
+						binary.Write(&msg, binary.BigEndian, []uint16{
+							uint16((i & 7) * 65535 / 7),
+							uint16((i >> 3 & 7) * 65535 / 7),
+							uint16((i >> 6) * 65535 / 3),
+						})
+					}
+				}
+				msg.Write([]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0})
+				msg.Write(pixels)
+				if _, err := c.Write(msg.Bytes()); err != nil {
+					return
+				}
+				sent = true
+			}
+		}
+	}()
+	return ln.Addr().String(), formats, func() { ln.Close() }
+}
+
+func TestVNCSession_PixelFormats(t *testing.T) {
+	white := vncclient.Color{R: 255, G: 255, B: 255}
+	tests := []struct {
+		name      string
+		bpp       uint8
+		trueColor bool
+		pixels    []byte
+		want      vncclient.Color
+	}{
+		{"rgb888", 32, true, []byte{0x10, 0x20, 0x30, 0, 0xff, 0xff, 0xff, 0}, vncclient.Color{R: 0x10, G: 0x20, B: 0x30}},
+		// Intensities of 16, 32 and 16: half of each channel's range
+		{"rgb565", 16, true, []byte{0x10, 0x84, 0xff, 0xff}, vncclient.Color{R: 132, G: 130, B: 132}},
+		// Intensities of 4, 2 and 1
+		{"rgb332", 8, true, []byte{0x89, 0xff}, vncclient.Color{R: 146, G: 73, B: 85}},
+		// Entry 0x89 of the color map is red 1/7, green 1/7 and blue 2/3
+		{"bgr233", 8, false, []byte{0x89, 0xff}, vncclient.Color{R: 36, G: 36, B: 170}},
+	}
+	for _, tt := range tests {
+		addr, formats, closeServer := newPixelFormatServer(t, tt.pixels)
+		batch := NewVNCBatch()
+		batch.Open(tt.name, VNCSessionConfig{Address: addr, Encoding: "raw", PixelFormat: tt.name})
+
+		select {
+		case format := <-formats:
+			if format.BPP != tt.bpp || format.TrueColor != tt.trueColor {
+				t.Errorf("%s: asked for %d bits per pixel, true color %v; want %d, %v", tt.name, format.BPP, format.TrueColor, tt.bpp, tt.trueColor)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: pixel format never set", tt.name)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			screens, _, errs := batch.Step(map[string][]VNCEvent{tt.name: nil})
+			if errs[tt.name] != nil {
+				t.Fatalf("%s: unexpected error: %s", tt.name, errs[tt.name])
+			}
+			if screen := screens[tt.name]; screen != nil && screen.Data[1] != (vncclient.Color{}) {
+				if screen.Data[0] != tt.want || screen.Data[1] != white {
+					t.Errorf("%s: got %v and %v, want %v and %v", tt.name, screen.Data[0], screen.Data[1], tt.want, white)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: update never painted", tt.name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		batch.Close(tt.name)
+		closeServer()
+	}
+}
+
+func TestVNCSession_InvalidPixelFormat(t *testing.T) {
+	// Nothing listens here: an invalid pixel format should fail
+	// without connecting.
+	config := VNCSessionConfig{Address: "127.0.0.1:1", Encoding: "raw", PixelFormat: "rgb444"}
+
+	batch := NewVNCBatch()
+	if err := batch.Open("invalid", config); err == nil || !strings.Contains(err.Error(), "invalid pixel format: rgb444") {
+		t.Errorf("Open: got %v, want an invalid pixel format error", err)
+	}
+	if _, ok := batch.sessions["invalid"]; ok {
+		t.Error("Open kept a session with an invalid pixel format")
+	}
+	if err := batch.Listen("invalid", "127.0.0.1:0", "", config); err == nil || !strings.Contains(err.Error(), "invalid pixel format: rgb444") {
+		t.Errorf("Listen: got %v, want an invalid pixel format error", err)
+	}
+	if len(batch.listeners) != 0 {
+		t.Error("Listen opened a listener for an invalid pixel format")
+	}
+
+	session := NewVNCSession("invalid", config)
+	defer session.Close()
+	if _, _, err := session.Step(nil); err == nil || !strings.Contains(err.Error(), "invalid pixel format: rgb444") {
+		t.Errorf("Step: got %v, want an invalid pixel format error", err)
+	}
+}
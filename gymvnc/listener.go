@@ -0,0 +1,313 @@
+package gymvnc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// reverseHandshakeTimeout bounds how long an incoming connection may
+// take to send its ID and complete the RFB handshake.
+const reverseHandshakeTimeout = 30 * time.Second
+
+// maxPendingReverseConns bounds how many connections no session has
+// asked for yet a Listener keeps. Past that, it hangs up on the oldest,
+// so peers announcing made-up IDs can't pile them up.
+const maxPendingReverseConns = 16
+
+// idPreambleLen is the size of the "ID:xxxx" block UltraVNC-style
+// servers send ahead of the RFB greeting, NUL padded.
+const idPreambleLen = 250
+
+// A Listener accepts reverse connections: VNC servers that connect to
+// us (as with "vncviewer -listen") rather than the other way around.
+//
+// The Listener completes the RFB handshake itself, authenticating
+// with its own password, and hands each connection to a session
+// waiting on it. A session may ask for a specific ID (sent by the
+// server as an UltraVNC-style "ID:xxxx" preamble) or desktop name;
+// otherwise it takes whatever arrives first.
+type Listener struct {
+	ln       net.Listener
+	password string
+
+	lock    sync.Mutex
+	pending []*reverseConn
+	waiters []*listenWaiter
+	closed  bool
+}
+
+// reverseConn is an incoming connection that has completed the RFB
+// handshake, along with the channels its ClientConn reports on.
+type reverseConn struct {
+	id              string
+	conn            *vncclient.ClientConn
+	serverMessageCh chan vncclient.ServerMessage
+	errorCh         chan error
+
+	// painter paints into the screens of the session that accepts
+	// the connection, once there is one.
+	painter *screenPainter
+}
+
+type listenWaiter struct {
+	id          string
+	desktopName string
+	ch          chan *reverseConn
+}
+
+func (w *listenWaiter) matches(rc *reverseConn) bool {
+	return (w.id == "" || w.id == rc.id) && (w.desktopName == "" || w.desktopName == rc.conn.DesktopName)
+}
+
+// specificity orders waiters, so a connection goes to the session
+// that asked for it over one that takes anything.
+func (w *listenWaiter) specificity() int {
+	s := 0
+	if w.id != "" {
+		s++
+	}
+	if w.desktopName != "" {
+		s++
+	}
+	return s
+}
+
+// Listen starts accepting reverse connections on address (e.g.
+// ":5500"). Servers are authenticated with password.
+func Listen(address, password string) (*Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Annotatef(err, "could not listen on %s", address)
+	}
+
+	l := &Listener{
+		ln:       ln,
+		password: password,
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+func (l *Listener) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.closeLocked()
+}
+
+// closeLocked closes the listener, and hangs up on its pending
+// connections and waiters. Must hold the lock.
+func (l *Listener) closeLocked() error {
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	for _, rc := range l.pending {
+		rc.conn.Close()
+	}
+	l.pending = nil
+	for _, w := range l.waiters {
+		close(w.ch)
+	}
+	l.waiters = nil
+
+	return l.ln.Close()
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		c, err := l.ln.Accept()
+		if err != nil {
+			l.lock.Lock()
+			closed := l.closed
+			l.lock.Unlock()
+			if !closed {
+				log.Errorf("reverse connection listener on %s failed: %s", l.ln.Addr(), err)
+				l.Close()
+			}
+			return
+		}
+		go l.handle(c)
+	}
+}
+
+func (l *Listener) handle(c net.Conn) {
+	log.Infof("accepted reverse connection from %s", c.RemoteAddr())
+
+	setKeepAlive(c, 0)
+	c.SetDeadline(time.Now().Add(reverseHandshakeTimeout))
+	id, c, err := readIDPreamble(c)
+	if err != nil {
+		log.Infof("dropping reverse connection from %s: %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	rc := &reverseConn{
+		id:              id,
+		serverMessageCh: make(chan vncclient.ServerMessage),
+		errorCh:         make(chan error, 1),
+		painter:         &screenPainter{},
+	}
+	conn, err, _ := vncclient.Client(c, &vncclient.ClientConfig{
+		Auth: []vncclient.ClientAuth{
+			&vncclient.PasswordAuth{
+				Password: l.password,
+			},
+			new(vncclient.ClientAuthNone),
+		},
+		ServerMessageCh: rc.serverMessageCh,
+		ErrorCh:         rc.errorCh,
+		Framebuffer:     rc.painter,
+	})
+	if err != nil {
+		log.Infof("dropping reverse connection from %s: %s", c.RemoteAddr(), err)
+		return
+	}
+	c.SetDeadline(time.Time{})
+	rc.conn = conn
+
+	log.Infof("reverse connection from %s: id=%q desktop=%q", c.RemoteAddr(), id, conn.DesktopName)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		conn.Close()
+		return
+	}
+	l.offer(rc)
+}
+
+// offer hands rc to the most specific matching waiter, or queues it
+// until one arrives. Must hold the lock.
+func (l *Listener) offer(rc *reverseConn) {
+	best := -1
+	for i, w := range l.waiters {
+		if w.matches(rc) && (best == -1 || w.specificity() > l.waiters[best].specificity()) {
+			best = i
+		}
+	}
+	if best == -1 {
+		if len(l.pending) == maxPendingReverseConns {
+			oldest := l.pending[0]
+			log.Infof("dropping unclaimed reverse connection id=%q desktop=%q to make room", oldest.id, oldest.conn.DesktopName)
+			oldest.conn.Close()
+			l.pending = append(l.pending[:0], l.pending[1:]...)
+		}
+		l.pending = append(l.pending, rc)
+		return
+	}
+
+	w := l.waiters[best]
+	l.waiters = append(l.waiters[:best], l.waiters[best+1:]...)
+	w.ch <- rc
+}
+
+// accept waits for a connection matching id and desktopName (either
+// may be empty to match anything). It gives up after timeout, if
+// positive, or once done is closed.
+func (l *Listener) accept(id, desktopName string, timeout time.Duration, done chan bool) (*reverseConn, error) {
+	w := &listenWaiter{
+		id:          id,
+		desktopName: desktopName,
+		ch:          make(chan *reverseConn, 1),
+	}
+
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil, errors.New("listener is closed")
+	}
+	for i, rc := range l.pending {
+		if w.matches(rc) {
+			l.pending = append(l.pending[:i], l.pending[i+1:]...)
+			l.lock.Unlock()
+			return rc, nil
+		}
+	}
+	l.waiters = append(l.waiters, w)
+	l.lock.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	var err error
+	select {
+	case rc, ok := <-w.ch:
+		if !ok {
+			return nil, errors.New("listener was closed")
+		}
+		return rc, nil
+	case <-timeoutCh:
+		err = &vncclient.ConnectError{Err: errors.Errorf("no matching reverse connection within %s", timeout), Retry: true}
+	case <-done:
+		err = &vncclient.ClosedError{Err: errors.New("session was closed while waiting for a reverse connection")}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, other := range l.waiters {
+		if other == w {
+			l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+			return nil, err
+		}
+	}
+	// We lost a race with offer, which left us a connection to pass
+	// on, or with Close, which closed the channel.
+	rc, ok := <-w.ch
+	if !ok {
+		return nil, err
+	}
+	if l.closed {
+		rc.conn.Close()
+	} else {
+		l.offer(rc)
+	}
+	return nil, err
+}
+
+// readIDPreamble reads the optional "ID:xxxx" block some servers send
+// before the RFB greeting. It returns the ID, if any, and a conn from
+// which the RFB stream can be read.
+func readIDPreamble(c net.Conn) (string, net.Conn, error) {
+	var prefix [3]byte
+	if _, err := io.ReadFull(c, prefix[:]); err != nil {
+		return "", c, errors.Annotate(err, "could not read greeting")
+	}
+	if string(prefix[:]) != "ID:" {
+		return "", &prefixConn{Conn: c, r: io.MultiReader(bytes.NewReader(prefix[:]), c)}, nil
+	}
+
+	rest := make([]byte, idPreambleLen-len(prefix))
+	if _, err := io.ReadFull(c, rest); err != nil {
+		return "", c, errors.Annotate(err, "could not read ID preamble")
+	}
+	if i := bytes.IndexByte(rest, 0); i != -1 {
+		rest = rest[:i]
+	}
+	return string(rest), c, nil
+}
+
+// prefixConn replays bytes already consumed from a conn.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (p *prefixConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
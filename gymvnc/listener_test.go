@@ -0,0 +1,210 @@
+package gymvnc
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// reverseConnect plays a VNC server connecting out to a listener,
+// optionally announcing an UltraVNC-style ID first.
+func reverseConnect(t *testing.T, address, id, name string) {
+	c, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("error connecting to listener: %s", err)
+	}
+	go func() {
+		defer c.Close()
+		if id != "" {
+			preamble := make([]byte, idPreambleLen)
+			copy(preamble, "ID:"+id)
+			if _, err := c.Write(preamble); err != nil {
+				return
+			}
+		}
+		if err := serveMockRFB(c, name); err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, c)
+	}()
+}
+
+func TestListener_Matching(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	type result struct {
+		rc  *reverseConn
+		err error
+	}
+	done := make(chan bool)
+	defer close(done)
+
+	accept := func(id, desktopName string) chan result {
+		ch := make(chan result, 1)
+		go func() {
+			rc, err := l.accept(id, desktopName, 5*time.Second, done)
+			ch <- result{rc, err}
+		}()
+		return ch
+	}
+	byID := accept("1234", "")
+	byName := accept("", "desktop-b")
+
+	// Let both waiters register, so neither connection is queued
+	time.Sleep(50 * time.Millisecond)
+	reverseConnect(t, l.Addr().String(), "", "desktop-b")
+	reverseConnect(t, l.Addr().String(), "1234", "desktop-a")
+
+	r := <-byID
+	if r.err != nil {
+		t.Fatalf("unexpected error: %s", r.err)
+	}
+	if r.rc.id != "1234" || r.rc.conn.DesktopName != "desktop-a" {
+		t.Errorf("ID waiter got id=%q desktop=%q", r.rc.id, r.rc.conn.DesktopName)
+	}
+	r.rc.conn.Close()
+
+	r = <-byName
+	if r.err != nil {
+		t.Fatalf("unexpected error: %s", r.err)
+	}
+	if r.rc.conn.DesktopName != "desktop-b" {
+		t.Errorf("desktop name waiter got desktop=%q", r.rc.conn.DesktopName)
+	}
+	r.rc.conn.Close()
+
+	// A connection that arrives before anyone waits is queued
+	reverseConnect(t, l.Addr().String(), "", "desktop-c")
+	time.Sleep(50 * time.Millisecond)
+	r = <-accept("", "")
+	if r.err != nil {
+		t.Fatalf("unexpected error: %s", r.err)
+	}
+	if r.rc.conn.DesktopName != "desktop-c" {
+		t.Errorf("got desktop=%q, want desktop-c", r.rc.conn.DesktopName)
+	}
+	r.rc.conn.Close()
+}
+
+func TestListener_Timeout(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, err := l.accept("", "", 10*time.Millisecond, make(chan bool)); err == nil {
+		t.Fatal("expected timeout")
+	}
+}
+
+func TestListener_PendingCap(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	pending := func() []*reverseConn {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return append([]*reverseConn(nil), l.pending...)
+	}
+	var first *reverseConn
+	for i := 0; i <= maxPendingReverseConns; i++ {
+		reverseConnect(t, l.Addr().String(), fmt.Sprintf("made-up-%d", i), "unclaimed")
+		want := i + 1
+		if want > maxPendingReverseConns {
+			want = maxPendingReverseConns
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for len(pending()) < want || i == maxPendingReverseConns && pending()[0] == first {
+			if time.Now().After(deadline) {
+				t.Fatalf("connection %d never queued", i)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if i == 0 {
+			first = pending()[0]
+		}
+	}
+
+	if n := len(pending()); n != maxPendingReverseConns {
+		t.Errorf("%d connections pending, want %d", n, maxPendingReverseConns)
+	}
+	select {
+	case <-first.conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Error("oldest pending connection was not closed")
+	}
+}
+
+// TestListener_CloseWhileGivingUp closes the listener after a waiter
+// has given up but before it can take itself off the list.
+func TestListener_CloseWhileGivingUp(t *testing.T) {
+	l, err := Listen("127.0.0.1:0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	done := make(chan bool)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := l.accept("", "", 0, done)
+		errCh <- err
+	}()
+	for waiting := false; !waiting; {
+		time.Sleep(time.Millisecond)
+		l.lock.Lock()
+		waiting = len(l.waiters) == 1
+		l.lock.Unlock()
+	}
+
+	l.lock.Lock()
+	close(done)
+	// Give accept time to block on the lock
+	time.Sleep(20 * time.Millisecond)
+	l.closeLocked()
+	l.lock.Unlock()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept never returned")
+	}
+}
+
+func TestVNCBatch_Listen(t *testing.T) {
+	batch := NewVNCBatch()
+	err := batch.Listen("reverse", "127.0.0.1:0", "secret", VNCSessionConfig{
+		StartTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batch.Close("reverse")
+
+	listener := batch.sessions["reverse"].config.Listener
+	reverseConnect(t, listener.Addr().String(), "", "reverse")
+
+	screen := waitForScreen(t, batch, "reverse")
+	if screen.Width != 640 || screen.Height != 480 {
+		t.Errorf("unexpected screen size %dx%d", screen.Width, screen.Height)
+	}
+
+	batch.Close("reverse")
+	if len(batch.listeners) != 0 {
+		t.Error("listener still open after its only session closed")
+	}
+}
@@ -0,0 +1,151 @@
+package gymvnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// newMockRFBServer starts a server that completes an RFB 3.8 handshake
+// with any password (or none), announcing a 640x480 desktop with the given
+// name, and then discards whatever the client sends.
+func newMockRFBServer(t *testing.T, name string) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if err := serveMockRFB(c, name); err != nil {
+					return
+				}
+				io.Copy(ioutil.Discard, c)
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func serveMockRFB(c net.Conn, name string) error {
+	if _, err := io.WriteString(c, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(c, version[:]); err != nil {
+		return err
+	}
+
+	// Security types None and VNC auth; any password is accepted.
+	if _, err := c.Write([]byte{2, 1, 2}); err != nil {
+		return err
+	}
+	var chosen [1]byte
+	if _, err := io.ReadFull(c, chosen[:]); err != nil {
+		return err
+	}
+	if chosen[0] == 2 {
+		var challenge [16]byte
+		if _, err := c.Write(challenge[:]); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, challenge[:]); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(c, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+
+	var shared [1]byte
+	if _, err := io.ReadFull(c, shared[:]); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(640))
+	binary.Write(&buf, binary.BigEndian, uint16(480))
+	pf, err := vncclient.WritePixelFormat(&vncclient.PixelFormat{
+		BPP:        32,
+		Depth:      24,
+		TrueColor:  true,
+		RedMax:     255,
+		GreenMax:   255,
+		BlueMax:    255,
+		RedShift:   16,
+		GreenShift: 8,
+	})
+	if err != nil {
+		return err
+	}
+	buf.Write(pf)
+	binary.Write(&buf, binary.BigEndian, uint32(len(name)))
+	buf.WriteString(name)
+	_, err = c.Write(buf.Bytes())
+	return err
+}
+
+// readClientMessage reads one message from an RFB client, returning
+// its type and the rest of the message.
+func readClientMessage(c net.Conn) (byte, []byte, error) {
+	var msgType [1]byte
+	if _, err := io.ReadFull(c, msgType[:]); err != nil {
+		return 0, nil, err
+	}
+
+	var body []byte
+	switch msgType[0] {
+	case 0: // SetPixelFormat
+		body = make([]byte, 19)
+	case 2: // SetEncodings
+		var header [3]byte
+		if _, err := io.ReadFull(c, header[:]); err != nil {
+			return 0, nil, err
+		}
+		n := binary.BigEndian.Uint16(header[1:])
+		body = append(header[:], make([]byte, 4*int(n))...)
+		_, err := io.ReadFull(c, body[3:])
+		return msgType[0], body, err
+	case 3: // FramebufferUpdateRequest
+		body = make([]byte, 9)
+	case 4: // KeyEvent
+		body = make([]byte, 7)
+	case 5: // PointerEvent
+		body = make([]byte, 5)
+	default:
+		return 0, nil, fmt.Errorf("unexpected client message type %d", msgType[0])
+	}
+	_, err := io.ReadFull(c, body)
+	return msgType[0], body, err
+}
+
+// waitForScreen steps the named session until it has a screen,
+// failing the test on error or if it takes too long.
+func waitForScreen(t *testing.T, batch *VNCBatch, name string) *Screen {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screens, _, errs := batch.Step(map[string][]VNCEvent{name: nil})
+		if errs[name] != nil {
+			t.Fatalf("unexpected error: %s", errs[name])
+		}
+		if screens[name] != nil {
+			return screens[name]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session %s never connected", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,253 @@
+package gymvnc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+const (
+	// proxyCommandGrace is how long a proxy command gets to exit
+	// after its stdin is closed, before it is killed.
+	proxyCommandGrace = 500 * time.Millisecond
+
+	// proxyCommandStderrMax bounds how much of the command's stderr
+	// is kept for error messages.
+	proxyCommandStderrMax = 4096
+)
+
+// CommandDialer speaks RFB over the stdin and stdout of a command, in
+// the manner of OpenSSH's ProxyCommand: for example
+//
+//	ssh -W %h:%p bastion
+//	kubectl exec -i vnc-pod -- nc localhost %p
+//
+// The command is run with "sh -c". %h and %p are replaced with the
+// host and port being dialed, and %% with a literal %.
+type CommandDialer struct {
+	Command string
+}
+
+func (d *CommandDialer) Dial(network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.Annotatef(err, "proxy command: invalid address %s", address)
+	}
+	command := expandProxyCommand(d.Command, host, port)
+
+	// Use our own pipes rather than cmd.StdinPipe/StdoutPipe, so
+	// reads and writes support deadlines.
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Annotate(err, "proxy command: could not create pipe")
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, errors.Annotate(err, "proxy command: could not create pipe")
+	}
+
+	c := &commandConn{
+		command: command,
+		stdin:   stdinW,
+		stdout:  stdoutR,
+		exited:  make(chan struct{}),
+	}
+	c.cmd = exec.Command("sh", "-c", command)
+	c.cmd.Stdin = stdinR
+	c.cmd.Stdout = stdoutW
+	c.cmd.Stderr = &c.stderr
+	setProcessGroup(c.cmd)
+
+	err = c.cmd.Start()
+	// The child has its own copies now
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, errors.Annotatef(err, "proxy command: could not start %q", command)
+	}
+	log.Debugf("started proxy command %q (pid %d)", command, c.cmd.Process.Pid)
+
+	go func() {
+		c.waitErr = c.cmd.Wait()
+		close(c.exited)
+	}()
+	return c, nil
+}
+
+// expandProxyCommand substitutes %h, %p and %% in command.
+func expandProxyCommand(command, host, port string) string {
+	var out bytes.Buffer
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			out.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			out.WriteString(host)
+		case 'p':
+			out.WriteString(port)
+		case '%':
+			out.WriteByte('%')
+		default:
+			out.WriteByte('%')
+			out.WriteByte(command[i])
+		}
+	}
+	return out.String()
+}
+
+// commandConn is a net.Conn over a running proxy command.
+type commandConn struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   *os.File
+	stdout  *os.File
+	stderr  stderrBuffer
+
+	exited  chan struct{}
+	waitErr error
+
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err != nil {
+		err = c.annotate(err)
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	n, err := c.stdin.Write(b)
+	if err != nil {
+		err = c.annotate(err)
+	}
+	return n, err
+}
+
+// annotate explains an I/O error in terms of the command's exit
+// status and stderr. Only EOF and a broken pipe mean the command may
+// be exiting, so only those wait briefly for it to do so; deadline
+// timeouts and the like are returned as they are. A clean exit with
+// nothing on stderr is a plain EOF.
+func (c *commandConn) annotate(err error) error {
+	if !isPipeClosed(err) {
+		return err
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(100 * time.Millisecond):
+		return err
+	}
+
+	stderr := c.stderr.String()
+	if c.waitErr == nil && stderr == "" {
+		return err
+	}
+
+	status := "exited"
+	if c.waitErr != nil {
+		status = c.waitErr.Error()
+	}
+	if stderr == "" {
+		return errors.Errorf("proxy command %q %s", c.command, status)
+	}
+	return errors.Errorf("proxy command %q %s: %s", c.command, status, stderr)
+}
+
+// isPipeClosed reports whether err means the command closed its end
+// of a pipe.
+func isPipeClosed(err error) bool {
+	if err == io.EOF {
+		return true
+	}
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.EPIPE
+}
+
+// Close closes the command's stdin, which is how e.g. ssh -W learns
+// to exit, and kills it if it hasn't exited shortly after. Close
+// doesn't wait for either: it's called with the session's lock held.
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		c.stdout.Close()
+		go func() {
+			select {
+			case <-c.exited:
+			case <-time.After(proxyCommandGrace):
+				log.Debugf("killing proxy command %q (pid %d)", c.command, c.cmd.Process.Pid)
+				killProcessGroup(c.cmd)
+			}
+		}()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return commandAddr(c.command)
+}
+
+func (c *commandConn) RemoteAddr() net.Addr {
+	return commandAddr(c.command)
+}
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	if err := c.stdout.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.stdin.SetWriteDeadline(t)
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	return c.stdout.SetReadDeadline(t)
+}
+
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	return c.stdin.SetWriteDeadline(t)
+}
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "proxycommand" }
+func (a commandAddr) String() string  { return string(a) }
+
+// stderrBuffer keeps the tail of a command's stderr.
+type stderrBuffer struct {
+	lock sync.Mutex
+	buf  []byte
+}
+
+func (s *stderrBuffer) Write(p []byte) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.buf = append(s.buf, p...)
+	if len(s.buf) > proxyCommandStderrMax {
+		s.buf = s.buf[len(s.buf)-proxyCommandStderrMax:]
+	}
+	return len(p), nil
+}
+
+func (s *stderrBuffer) String() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return strings.TrimSpace(string(s.buf))
+}
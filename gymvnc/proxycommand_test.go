@@ -0,0 +1,141 @@
+package gymvnc
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHelperProcess isn't a real test: it's run as a proxy command,
+// relaying stdio to the host and port it's given.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GYMVNC_PROXY_HELPER") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: -- host port\n")
+		os.Exit(2)
+	}
+
+	c, err := net.Dial("tcp", net.JoinHostPort(args[1], args[2]))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+	go func() {
+		// Exit once our stdin is closed, as ssh -W does
+		io.Copy(c, os.Stdin)
+		os.Exit(0)
+	}()
+	io.Copy(os.Stdout, c)
+	os.Exit(0)
+}
+
+func helperProxyCommand() string {
+	return fmt.Sprintf("GYMVNC_PROXY_HELPER=1 exec '%s' -test.run=TestHelperProcess -- %%h %%p", os.Args[0])
+}
+
+func TestProxyCommand(t *testing.T) {
+	server, closeServer := newMockRFBServer(t, "proxied")
+	defer closeServer()
+
+	batch := NewVNCBatch()
+	batch.Open("proxied", VNCSessionConfig{
+		Address:      server,
+		ProxyCommand: helperProxyCommand(),
+	})
+	defer batch.Close("proxied")
+
+	waitForScreen(t, batch, "proxied")
+	if name := batch.sessions["proxied"].conn.DesktopName; name != "proxied" {
+		t.Errorf("DesktopName = %q, want proxied", name)
+	}
+}
+
+func TestCommandDialer_Stderr(t *testing.T) {
+	dialer := &CommandDialer{Command: "echo could not resolve %h:%p >&2; exit 255"}
+	c, err := dialer.Dial("tcp", "vnc.example:5901")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = c.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"could not resolve vnc.example:5901", "exit status 255"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestCommandDialer_Close(t *testing.T) {
+	// sleep ignores its stdin closing, so has to be killed
+	dialer := &CommandDialer{Command: "sleep 60"}
+	c, err := dialer.Dial("tcp", "localhost:5900")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Close is called with the session's lock held, so mustn't wait
+	// out the grace period
+	start := time.Now()
+	c.Close()
+	if elapsed := time.Since(start); elapsed >= proxyCommandGrace {
+		t.Errorf("Close took %s", elapsed)
+	}
+	select {
+	case <-c.(*commandConn).exited:
+	case <-time.After(5 * time.Second):
+		t.Error("proxy command still running after Close")
+	}
+}
+
+func TestCommandDialer_ReadTimeout(t *testing.T) {
+	dialer := &CommandDialer{Command: "sleep 60"}
+	c, err := dialer.Dial("tcp", "localhost:5900")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A timeout says nothing about the command, so shouldn't wait
+	// to see whether it exits
+	c.SetReadDeadline(time.Now())
+	start := time.Now()
+	_, err = c.Read(make([]byte, 1))
+	if !os.IsTimeout(err) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= 50*time.Millisecond {
+		t.Errorf("Read took %s to time out", elapsed)
+	}
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+	}{
+		{"ssh -W %h:%p bastion", "ssh -W vnc:5901 bastion"},
+		{"nc %h %p", "nc vnc 5901"},
+		{"echo 100%%", "echo 100%"},
+		{"echo %x %", "echo %x %"},
+	}
+
+	for _, tt := range tests {
+		if actual := expandProxyCommand(tt.command, "vnc", "5901"); actual != tt.expected {
+			t.Errorf("expandProxyCommand(%q) = %q, want %q", tt.command, actual, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package gymvnc
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,111 @@
+package gymvnc
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// An Outage is a period during which a session with Reconnect set had
+// lost its connection to the server.
+type Outage struct {
+	// Err is what broke the connection.
+	Err error
+
+	Start time.Time
+
+	// Duration is how long the connection was down, or while
+	// Reconnected is false, how long it has been down so far.
+	Duration time.Duration
+
+	Reconnected bool
+}
+
+// Outage returns the session's current outage, if it is reconnecting,
+// or else the outage it has since recovered from, the first time it is
+// called after recovering. Otherwise it returns nil.
+func (c *VNCSession) Outage() *Outage {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.outage == nil {
+		return nil
+	}
+	outage := *c.outage
+	if outage.Reconnected {
+		c.outage = nil
+	} else {
+		outage.Duration = time.Since(outage.Start)
+	}
+	return &outage
+}
+
+// reconnecting reports whether an outage is in progress. Must hold the
+// lock.
+func (c *VNCSession) reconnecting() bool {
+	return c.outage != nil && !c.outage.Reconnected
+}
+
+// reconnect replaces conn, which failed with cause, with a new
+// connection set up the same way. updates is where conn's updates were
+// queued for maintainFrameBuffer.
+func (c *VNCSession) reconnect(conn *vncclient.ClientConn, updates chan *vncclient.FramebufferUpdateMessage, cause error) (*vncclient.ClientConn, chan vncclient.ServerMessage, chan error, error) {
+	log.Infof("[%s] lost connection to VNC server: %s; reconnecting", c.label, cause)
+
+	c.lock.Lock()
+	c.outage = &Outage{Err: cause, Start: time.Now()}
+	c.lock.Unlock()
+
+	// It may only be stalled, rather than gone
+	conn.Close()
+
+	// An update it was painting, or had painted but couldn't pass on,
+	// will never be received; only those already queued will be.
+	c.updated.L.Lock()
+	c.unreceived = len(updates)
+	c.updated.L.Unlock()
+
+	conn, serverMessageCh, errorCh, err := c.open(c.config.ReconnectTimeout, true)
+	if err != nil {
+		return nil, nil, nil, errors.Annotatef(err, "could not reconnect after: %s", cause)
+	}
+	if err := c.setup(conn, true); err != nil {
+		return nil, nil, nil, errors.Annotatef(err, "could not reconnect after: %s", cause)
+	}
+	return conn, serverMessageCh, errorCh, nil
+}
+
+// connFailed handles an error using the connection from outside serve.
+// Without Reconnect it fails the session. With it, the connection is
+// closed, which serve notices and reconnects.
+func (c *VNCSession) connFailed(err error) {
+	if !c.config.Reconnect {
+		select {
+		case c.mgr.Error <- err:
+		default:
+		}
+		return
+	}
+
+	c.lock.Lock()
+	conn := c.conn
+	reconnecting := c.reconnecting()
+	c.lock.Unlock()
+
+	if !reconnecting && conn != nil {
+		log.Infof("[%s] %s; dropping connection", c.label, err)
+		conn.Close()
+	}
+}
+
+// Outages returns each session's Outage, for those that have one.
+func (v *VNCBatch) Outages() map[string]*Outage {
+	outageN := map[string]*Outage{}
+	for name, session := range v.sessions {
+		if outage := session.Outage(); outage != nil {
+			outageN[name] = outage
+		}
+	}
+	return outageN
+}
@@ -0,0 +1,205 @@
+package gymvnc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// restartingServer plays a VNC server that restarts: the first client
+// connection gets a single white pixel and is dropped once drop is
+// closed, and the next is only served once resume is closed. The
+// messages the second client sends up to its first update request are
+// reported on negotiated.
+type restartingServer struct {
+	ln         net.Listener
+	drop       chan struct{}
+	resume     chan struct{}
+	negotiated chan []byte
+}
+
+func newRestartingServer(t *testing.T) *restartingServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	s := &restartingServer{
+		ln:         ln,
+		drop:       make(chan struct{}),
+		resume:     make(chan struct{}),
+		negotiated: make(chan []byte, 1),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *restartingServer) serve() {
+	c, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer c.Close()
+		if err := serveMockRFB(c, "restarting"); err != nil {
+			return
+		}
+		for {
+			msgType, _, err := readClientMessage(c)
+			if err != nil {
+				return
+			}
+			if msgType == 3 {
+				break
+			}
+		}
+		// One raw 1x1 white rectangle at the origin
+		c.Write([]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 255, 255, 255, 0})
+		<-s.drop
+	}()
+
+	c2, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer c2.Close()
+	<-s.resume
+	if err := serveMockRFB(c2, "restarting"); err != nil {
+		return
+	}
+	var types []byte
+	for {
+		msgType, body, err := readClientMessage(c2)
+		if err != nil {
+			return
+		}
+		types = append(types, msgType)
+		if msgType == 3 {
+			// Record whether it was incremental
+			types = append(types, body[0])
+			break
+		}
+	}
+	s.negotiated <- types
+	for {
+		if _, _, err := readClientMessage(c2); err != nil {
+			return
+		}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	server := newRestartingServer(t)
+	defer server.ln.Close()
+
+	batch := NewVNCBatch()
+	batch.Open("restarting", VNCSessionConfig{
+		Address:          server.ln.Addr().String(),
+		Encoding:         "raw",
+		Reconnect:        true,
+		ReconnectTimeout: 5 * time.Second,
+		Backoff:          Backoff{InitialDelay: 10 * time.Millisecond},
+	})
+	defer batch.Close("restarting")
+
+	white := vncclient.Color{R: 255, G: 255, B: 255}
+	deadline := time.Now().Add(5 * time.Second)
+	for waitForScreen(t, batch, "restarting").Data[0] != white {
+		if time.Now().After(deadline) {
+			t.Fatal("never received the first update")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(server.drop)
+	deadline = time.Now().Add(5 * time.Second)
+	for batch.Outages()["restarting"] == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("no outage reported after the server went away")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// While reconnecting, steps keep the last screen and drop events
+	screens, _, errs := batch.Step(map[string][]VNCEvent{
+		"restarting": {KeyEvent{Keysym: 0x61, Down: true}},
+	})
+	if errs["restarting"] != nil {
+		t.Fatalf("error while reconnecting: %s", errs["restarting"])
+	}
+	if screens["restarting"] == nil || screens["restarting"].Data[0] != white {
+		t.Error("last screen not kept while reconnecting")
+	}
+	if outage := batch.Outages()["restarting"]; outage == nil || outage.Reconnected || outage.Err == nil {
+		t.Errorf("unexpected outage while reconnecting: %+v", outage)
+	}
+
+	close(server.resume)
+	select {
+	case types := <-server.negotiated:
+		// SetPixelFormat, SetEncodings, then a non-incremental
+		// FramebufferUpdateRequest
+		if string(types) != string([]byte{0, 2, 3, 0}) {
+			t.Errorf("reconnection sent messages %v", types)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("session never reconnected")
+	}
+
+	deadline = time.Now().Add(5 * time.Second)
+	for {
+		outage := batch.Outages()["restarting"]
+		if outage != nil && outage.Reconnected {
+			if outage.Duration <= 0 {
+				t.Errorf("outage has no duration: %+v", outage)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outage never ended: %+v", outage)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if outages := batch.Outages(); len(outages) != 0 {
+		t.Errorf("outage reported again after recovery: %+v", outages)
+	}
+
+	_, _, errs = batch.Step(map[string][]VNCEvent{"restarting": nil})
+	if errs["restarting"] != nil {
+		t.Errorf("error after reconnecting: %s", errs["restarting"])
+	}
+}
+
+func TestReconnect_Disabled(t *testing.T) {
+	server := newRestartingServer(t)
+	defer server.ln.Close()
+	defer close(server.resume)
+
+	session := NewVNCSession("restarting", VNCSessionConfig{
+		Address:  server.ln.Addr().String(),
+		Encoding: "raw",
+	})
+	defer session.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		screen, _, err := session.Step(nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if screen != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session never connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(server.drop)
+	sessionErr(t, session, 5*time.Second)
+	if outage := session.Outage(); outage != nil {
+		t.Errorf("outage reported without Reconnect: %+v", outage)
+	}
+}
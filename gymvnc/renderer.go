@@ -4,7 +4,9 @@ import "github.com/openai/go-vncdriver/vncclient"
 
 type Renderer interface {
 	Init(width, height uint16, name string, screen []vncclient.Color) error
-	Apply(colors []*vncclient.FramebufferUpdateMessage)
+	// Apply brings the renderer up to date with the updates, which
+	// have been painted into screen.
+	Apply(screen []vncclient.Color, updates []*vncclient.FramebufferUpdateMessage)
 	Render()
 	Close() error
 }
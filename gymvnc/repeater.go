@@ -0,0 +1,58 @@
+package gymvnc
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// An UltraVNC repeater greets viewers with this fake protocol
+// version, and then expects a fixed-size block naming the server to
+// be connected to.
+const (
+	repeaterGreeting    = "RFB 000.000\n"
+	repeaterPreambleLen = 250
+)
+
+// validateRepeater checks that repeater is a usable preamble: either
+// "ID:xxxx" (mode 2) or a host:port (mode 1).
+func validateRepeater(repeater string) error {
+	if len(repeater) >= repeaterPreambleLen {
+		return errors.Errorf("repeater target is too long (%d bytes, max %d): %s", len(repeater), repeaterPreambleLen-1, repeater)
+	}
+	if strings.HasPrefix(repeater, "ID:") {
+		if len(repeater) == len("ID:") {
+			return errors.Errorf("empty repeater ID: %s", repeater)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(repeater); err != nil {
+		return errors.Errorf("repeater target must be ID:xxxx or host:port: %s", repeater)
+	}
+	return nil
+}
+
+// repeaterHandshake asks the repeater on the other end of c to put us
+// through to the server named by repeater. Like vncclient.Client, it
+// returns whether a failure is worth retrying: I/O errors are (the
+// repeater drops us if the server isn't reachable yet), but talking
+// to something that isn't a repeater is not.
+func repeaterHandshake(c net.Conn, repeater string) (error, bool) {
+	var greeting [len(repeaterGreeting)]byte
+	if _, err := io.ReadFull(c, greeting[:]); err != nil {
+		return &vncclient.ConnectError{Err: errors.Annotate(err, "could not read repeater greeting"), Retry: true}, true
+	}
+	if string(greeting[:]) != repeaterGreeting {
+		return &vncclient.ProtocolError{Err: errors.Errorf("expected repeater greeting %q, got %q (is the address a repeater?)", repeaterGreeting, greeting)}, false
+	}
+
+	preamble := make([]byte, repeaterPreambleLen)
+	copy(preamble, repeater)
+	if _, err := c.Write(preamble); err != nil {
+		return &vncclient.ConnectError{Err: errors.Annotate(err, "could not send repeater target"), Retry: true}, true
+	}
+	return nil, false
+}
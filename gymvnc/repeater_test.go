@@ -0,0 +1,145 @@
+package gymvnc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newMockRepeater starts a stand-in for an UltraVNC repeater. Mode 2
+// requests for id are served by a mock server directly; mode 1
+// requests are relayed to the named host:port.
+func newMockRepeater(t *testing.T, id string) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.WriteString(c, repeaterGreeting)
+				preamble := make([]byte, repeaterPreambleLen)
+				if _, err := io.ReadFull(c, preamble); err != nil {
+					return
+				}
+				target := string(bytes.TrimRight(preamble, "\x00"))
+
+				if strings.HasPrefix(target, "ID:") {
+					if target != "ID:"+id {
+						return
+					}
+					if err := serveMockRFB(c, "mode2"); err != nil {
+						return
+					}
+					io.Copy(ioutil.Discard, c)
+					return
+				}
+
+				server, err := net.Dial("tcp", target)
+				if err != nil {
+					return
+				}
+				defer server.Close()
+				go io.Copy(server, c)
+				io.Copy(c, server)
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func TestRepeater_Mode1(t *testing.T) {
+	server, closeServer := newMockRFBServer(t, "mode1")
+	defer closeServer()
+	repeater, closeRepeater := newMockRepeater(t, "")
+	defer closeRepeater()
+
+	batch := NewVNCBatch()
+	batch.Open("repeated", VNCSessionConfig{
+		Address:  repeater,
+		Repeater: server,
+	})
+	defer batch.Close("repeated")
+
+	waitForScreen(t, batch, "repeated")
+	if name := batch.sessions["repeated"].conn.DesktopName; name != "mode1" {
+		t.Errorf("DesktopName = %q, want mode1", name)
+	}
+}
+
+func TestRepeater_Mode2(t *testing.T) {
+	repeater, closeRepeater := newMockRepeater(t, "1234")
+	defer closeRepeater()
+
+	batch := NewVNCBatch()
+	batch.Open("repeated", VNCSessionConfig{
+		Address:  repeater,
+		Repeater: "ID:1234",
+	})
+	defer batch.Close("repeated")
+
+	waitForScreen(t, batch, "repeated")
+	if name := batch.sessions["repeated"].conn.DesktopName; name != "mode2" {
+		t.Errorf("DesktopName = %q, want mode2", name)
+	}
+}
+
+func TestRepeater_NotARepeater(t *testing.T) {
+	// A plain server's greeting is a hard error: it should fail
+	// straight away rather than retrying until StartTimeout.
+	server, closeServer := newMockRFBServer(t, "plain")
+	defer closeServer()
+
+	batch := NewVNCBatch()
+	batch.Open("repeated", VNCSessionConfig{
+		Address:      server,
+		Repeater:     "ID:1234",
+		StartTimeout: time.Minute,
+	})
+	defer batch.Close("repeated")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, _, errs := batch.Step(map[string][]VNCEvent{"repeated": nil})
+		if errs["repeated"] != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a hard error")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestValidateRepeater(t *testing.T) {
+	tests := []struct {
+		repeater string
+		isErr    bool
+	}{
+		{"ID:1234", false},
+		{"10.0.0.1:5901", false},
+		{"[::1]:5900", false},
+		{"ID:", true},
+		{"host", true},
+		{"ID:" + strings.Repeat("x", repeaterPreambleLen), true},
+	}
+
+	for _, tt := range tests {
+		err := validateRepeater(tt.repeater)
+		if err != nil && !tt.isErr {
+			t.Errorf("validateRepeater(%q) unexpected error %v", tt.repeater, err)
+		}
+		if err == nil && tt.isErr {
+			t.Errorf("validateRepeater(%q) expected error", tt.repeater)
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package gymvnc
 
-import "github.com/openai/go-vncdriver/vncclient"
+import (
+	"sync"
+
+	"github.com/openai/go-vncdriver/vncclient"
+)
 
 type Screen struct {
 	Data   []vncclient.Color
@@ -15,3 +19,62 @@ func NewScreen(width, height uint16) *Screen {
 		Height: height,
 	}
 }
+
+// screenPainter is the vncclient.Framebuffer a connection paints its
+// updates into: the back screen of its session. A reverse connection
+// is made before the session it belongs to is known, so its session
+// is attached afterwards; until then, what it paints is thrown away.
+type screenPainter struct {
+	lock    sync.Mutex
+	session *VNCSession
+
+	// locked is the session Lock locked, for Unlock to unlock, and
+	// updating the session BeginUpdate told, for EndUpdate to tell.
+	// Only the connection's reader goroutine touches them.
+	locked   *VNCSession
+	updating *VNCSession
+}
+
+func (p *screenPainter) attach(session *VNCSession) {
+	p.lock.Lock()
+	p.session = session
+	p.lock.Unlock()
+}
+
+func (p *screenPainter) Lock(rect *vncclient.Rectangle) ([]vncclient.Color, int) {
+	p.lock.Lock()
+	session := p.session
+	p.lock.Unlock()
+
+	if session != nil {
+		if pix, stride := session.lockBack(rect); pix != nil {
+			p.locked = session
+			return pix, stride
+		}
+	}
+	return make([]vncclient.Color, rect.Area()), int(rect.Width)
+}
+
+func (p *screenPainter) Unlock(rect *vncclient.Rectangle) {
+	if p.locked != nil {
+		p.locked.unlockBack(rect)
+		p.locked = nil
+	}
+}
+
+func (p *screenPainter) BeginUpdate() {
+	p.lock.Lock()
+	p.updating = p.session
+	p.lock.Unlock()
+
+	if p.updating != nil {
+		p.updating.beginUpdate()
+	}
+}
+
+func (p *screenPainter) EndUpdate(err error) {
+	if p.updating != nil && err != nil {
+		p.updating.abandonUpdate()
+	}
+	p.updating = nil
+}
@@ -0,0 +1,97 @@
+package gymvnc
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultKeepAlive is the TCP keepalive period used when
+// VNCSessionConfig.KeepAlive is zero.
+const defaultKeepAlive = 30 * time.Second
+
+// setKeepAlive enables TCP keepalives on conn, if it is a TCP
+// connection. A negative period leaves them off.
+func setKeepAlive(conn net.Conn, period time.Duration) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok || period < 0 {
+		return
+	}
+	if period == 0 {
+		period = defaultKeepAlive
+	}
+	tc.SetKeepAlive(true)
+	tc.SetKeepAlivePeriod(period)
+}
+
+// updateWatchdog notices when a requested framebuffer update never
+// arrives, which is how a server that has stopped sending (but not
+// closed the connection) shows up.
+type updateWatchdog struct {
+	lock sync.Mutex
+
+	// pending is when the outstanding request was sent; zero if
+	// there is none.
+	pending time.Time
+
+	// refreshed is set once a full refresh has been requested for
+	// the outstanding request.
+	refreshed bool
+}
+
+// requested records that an update was requested. The window runs
+// from the earliest unanswered request.
+func (w *updateWatchdog) requested(now time.Time) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.pending.IsZero() {
+		w.pending = now
+	}
+}
+
+// received records that an update arrived.
+func (w *updateWatchdog) received() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.pending = time.Time{}
+	w.refreshed = false
+}
+
+// watchdogInterval is how often to check on an UpdateTimeout: often
+// enough that a stall is noticed within a fraction of the window.
+func watchdogInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	return interval
+}
+
+// watchdogVerdict is what to do about an overdue update.
+type watchdogVerdict int
+
+const (
+	watchdogOK watchdogVerdict = iota
+	watchdogRefresh
+	watchdogDead
+)
+
+// check reports whether the outstanding request has been waiting
+// longer than timeout. The first time it has, the caller should ask for
+// a full refresh, and the window starts over: a server only answers an
+// incremental request once something changes, so only an unanswered
+// refresh means it has stalled.
+func (w *updateWatchdog) check(now time.Time, timeout time.Duration) watchdogVerdict {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.pending.IsZero() || now.Sub(w.pending) < timeout {
+		return watchdogOK
+	}
+	if !w.refreshed {
+		w.refreshed = true
+		w.pending = now
+		return watchdogRefresh
+	}
+	return watchdogDead
+}
@@ -0,0 +1,133 @@
+package gymvnc
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdateWatchdog_Check(t *testing.T) {
+	var w updateWatchdog
+	start := time.Now()
+
+	if v := w.check(start.Add(time.Hour), time.Second); v != watchdogOK {
+		t.Errorf("with nothing requested, got verdict %d", v)
+	}
+
+	w.requested(start)
+	w.requested(start.Add(500 * time.Millisecond))
+	if v := w.check(start.Add(900*time.Millisecond), time.Second); v != watchdogOK {
+		t.Errorf("within the window, got verdict %d", v)
+	}
+	// The window runs from the first request
+	if v := w.check(start.Add(time.Second), time.Second); v != watchdogRefresh {
+		t.Errorf("first overdue check, got verdict %d, want refresh", v)
+	}
+	if v := w.check(start.Add(1500*time.Millisecond), time.Second); v != watchdogOK {
+		t.Errorf("after refresh, got verdict %d, want a fresh window", v)
+	}
+	if v := w.check(start.Add(2*time.Second), time.Second); v != watchdogDead {
+		t.Errorf("unanswered refresh, got verdict %d, want dead", v)
+	}
+
+	w.received()
+	w.requested(start.Add(3 * time.Second))
+	if v := w.check(start.Add(4*time.Second), time.Second); v != watchdogRefresh {
+		t.Errorf("after an update, got verdict %d, want refresh to be allowed again", v)
+	}
+}
+
+func TestUpdateWatchdog_Stalled(t *testing.T) {
+	// The mock server never sends an update
+	server, closeServer := newMockRFBServer(t, "stalled")
+	defer closeServer()
+
+	session := NewVNCSession("stalled", VNCSessionConfig{
+		Address:       server,
+		UpdateTimeout: 100 * time.Millisecond,
+	})
+	defer session.Close()
+
+	err := sessionErr(t, session, 5*time.Second)
+	if !strings.Contains(err.Error(), "no framebuffer update within 100ms") {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// serveRefreshOnly runs an RFB server that ignores incremental update
+// requests, and answers full ones with an empty update. Each full
+// request's region is reported on refreshes.
+func serveRefreshOnly(c net.Conn, refreshes chan<- Region) error {
+	if err := serveMockRFB(c, "refresh"); err != nil {
+		return err
+	}
+	for {
+		msgType, body, err := readClientMessage(c)
+		if err != nil {
+			return err
+		}
+		if msgType != 3 || body[0] != 0 {
+			continue
+		}
+		if _, err := c.Write([]byte{0, 0, 0, 0}); err != nil {
+			return err
+		}
+		region := Region{
+			X:      binary.BigEndian.Uint16(body[1:]),
+			Y:      binary.BigEndian.Uint16(body[3:]),
+			Width:  binary.BigEndian.Uint16(body[5:]),
+			Height: binary.BigEndian.Uint16(body[7:]),
+		}
+		select {
+		case refreshes <- region:
+		default:
+		}
+	}
+}
+
+func TestUpdateWatchdog_Refresh(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer ln.Close()
+
+	refreshes := make(chan Region, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		serveRefreshOnly(c, refreshes)
+	}()
+
+	// An idle desktop, as far as incremental requests go
+	sub := Region{X: 10, Y: 20, Width: 30, Height: 40}
+	session := NewVNCSession("refresh", VNCSessionConfig{
+		Address:       ln.Addr().String(),
+		UpdateTimeout: 100 * time.Millisecond,
+		Subscription:  []Region{sub},
+	})
+	defer session.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case region := <-refreshes:
+			if region != sub {
+				t.Errorf("refresh requested %+v, want the subscription %+v", region, sub)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no full refresh requested (saw %d)", i)
+		}
+	}
+
+	session.lock.Lock()
+	err = session.err
+	session.lock.Unlock()
+	if err != nil {
+		t.Errorf("session failed even though refreshes were answered: %s", err)
+	}
+}
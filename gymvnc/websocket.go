@@ -0,0 +1,328 @@
+package gymvnc
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// websocketGUID is appended to the client's key to compute the
+// server's accept token. See RFC 6455 Section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes, RFC 6455 Section 5.2
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocketDialer connects to servers fronted by websockify (or noVNC's
+// proxy), which carry the RFB stream in binary WebSocket frames. The
+// address passed to Dial is a ws:// or wss:// URL; the network is
+// ignored.
+type WebSocketDialer struct {
+	// TLSConfig is used for wss:// addresses. If nil, the default
+	// configuration is used.
+	TLSConfig *tls.Config
+
+	// Origin, if set, is sent as the Origin header. Some websockify
+	// deployments check it.
+	Origin string
+
+	// Forward is used to open the underlying TCP connection.
+	// Defaults to a plain net.Dialer.
+	Forward Dialer
+}
+
+func (w *WebSocketDialer) Dial(network, address string) (net.Conn, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, errors.Annotatef(err, "websocket: could not parse address %s", address)
+	}
+
+	var defaultPort string
+	switch u.Scheme {
+	case "ws":
+		defaultPort = "80"
+	case "wss":
+		defaultPort = "443"
+	default:
+		return nil, errors.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	hostport := u.Host
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(u.Host, "[]"), defaultPort)
+	}
+
+	conn, err := forwardDialer(w.Forward).Dial("tcp", hostport)
+	if err != nil {
+		return nil, errors.Annotatef(err, "websocket: could not connect to %s", hostport)
+	}
+
+	if u.Scheme == "wss" {
+		cfg := w.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg = cfg.Clone()
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Annotatef(err, "websocket: TLS handshake with %s failed", hostport)
+		}
+		conn = tlsConn
+	}
+
+	wsConn, err := w.handshake(conn, u)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Annotatef(err, "websocket: handshake with %s failed", address)
+	}
+	return wsConn, nil
+}
+
+func (w *WebSocketDialer) handshake(conn net.Conn, u *url.URL) (*websocketConn, error) {
+	var nonce [16]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	path := u.RequestURI()
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Protocol: binary\r\n"
+	if w.Origin != "" {
+		req += "Origin: " + w.Origin + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := io.WriteString(conn, req); err != nil {
+		return nil, err
+	}
+
+	// The server may start sending frames right after the handshake
+	// (RFB servers speak first), so the same buffered reader must be
+	// used for the frames that follow.
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		return nil, errors.Annotate(err, "could not read handshake response")
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, errors.Errorf("unexpected status: %s", resp.Status)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return nil, errors.Errorf("unexpected Upgrade header: %q", resp.Header.Get("Upgrade"))
+	}
+
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	if accept := base64.StdEncoding.EncodeToString(h.Sum(nil)); resp.Header.Get("Sec-WebSocket-Accept") != accept {
+		return nil, errors.New("invalid Sec-WebSocket-Accept")
+	}
+
+	// Older websockify versions don't echo the subprotocol, but
+	// still speak binary frames. Anything else (e.g. "base64") we
+	// can't handle.
+	if p := resp.Header.Get("Sec-WebSocket-Protocol"); p != "" && p != "binary" {
+		return nil, errors.Errorf("server chose unsupported subprotocol %q", p)
+	}
+
+	return &websocketConn{Conn: conn, br: br}, nil
+}
+
+// websocketConn adapts a WebSocket connection carrying binary frames to
+// a net.Conn, so vncclient.Client can use it unchanged.
+type websocketConn struct {
+	net.Conn
+	br *bufio.Reader
+
+	// remaining is the number of unread payload bytes in the
+	// current frame.
+	remaining uint64
+	mask      [4]byte
+	masked    bool
+	maskPos   int
+	readErr   error
+
+	writeLock sync.Mutex
+	closed    bool
+}
+
+func (w *websocketConn) Read(p []byte) (int, error) {
+	for w.remaining == 0 {
+		if w.readErr != nil {
+			return 0, w.readErr
+		}
+		if err := w.nextFrame(); err != nil {
+			w.readErr = err
+			return 0, err
+		}
+	}
+
+	if uint64(len(p)) > w.remaining {
+		p = p[:w.remaining]
+	}
+	n, err := w.br.Read(p)
+	if w.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= w.mask[w.maskPos&3]
+			w.maskPos++
+		}
+	}
+	w.remaining -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextFrame reads frame headers until it finds one carrying data,
+// answering control frames along the way.
+func (w *websocketConn) nextFrame() error {
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(w.br, hdr[:]); err != nil {
+			return err
+		}
+		opcode := hdr[0] & 0x0f
+		w.masked = hdr[1]&0x80 != 0
+		length := uint64(hdr[1] & 0x7f)
+
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(w.br, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(w.br, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+
+		if w.masked {
+			if _, err := io.ReadFull(w.br, w.mask[:]); err != nil {
+				return err
+			}
+		}
+		w.maskPos = 0
+
+		switch opcode {
+		case wsBinary, wsContinuation:
+			if length == 0 {
+				continue
+			}
+			w.remaining = length
+			return nil
+		case wsPing, wsPong, wsClose:
+			if length > 125 {
+				return errors.Errorf("websocket: control frame too long: %d", length)
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(w.br, payload); err != nil {
+				return err
+			}
+			if w.masked {
+				for i := range payload {
+					payload[i] ^= w.mask[i&3]
+				}
+			}
+			switch opcode {
+			case wsPing:
+				if err := w.writeFrame(wsPong, payload); err != nil {
+					return err
+				}
+			case wsClose:
+				w.writeFrame(wsClose, payload)
+				return io.EOF
+			}
+		case wsText:
+			return errors.New("websocket: got a text frame; only the binary subprotocol is supported")
+		default:
+			return errors.Errorf("websocket: unknown opcode %d", opcode)
+		}
+	}
+}
+
+func (w *websocketConn) Write(p []byte) (int, error) {
+	if err := w.writeFrame(wsBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a single, final, masked frame as required of
+// clients by RFC 6455 Section 5.3.
+func (w *websocketConn) writeFrame(opcode byte, payload []byte) error {
+	w.writeLock.Lock()
+	defer w.writeLock.Unlock()
+
+	if w.closed {
+		return errors.New("websocket: connection closed")
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch l := len(payload); {
+	case l < 126:
+		frame = append(frame, 0x80|byte(l))
+	case l <= 0xffff:
+		frame = append(frame, 0x80|126, byte(l>>8), byte(l))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(l))
+		frame = append(frame, 0x80|127)
+		frame = append(frame, ext[:]...)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(rand.Reader, mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i&3])
+	}
+
+	if opcode == wsClose {
+		w.closed = true
+	}
+	_, err := w.Conn.Write(frame)
+	return err
+}
+
+func (w *websocketConn) Close() error {
+	// Best effort; the server may already be gone.
+	w.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	w.writeFrame(wsClose, []byte{0x03, 0xe8}) // 1000: normal closure
+	return w.Conn.Close()
+}
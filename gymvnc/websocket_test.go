@@ -0,0 +1,202 @@
+package gymvnc
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/openai/go-vncdriver/vncclient"
+)
+
+// websockifyProxy is a minimal stand-in for websockify: it upgrades
+// requests to WebSocket and relays binary frames to a TCP target. To
+// exercise the client's framing, it splits what the target sends into
+// tiny fragments and pings before each message.
+type websockifyProxy struct {
+	target   string
+	protocol string
+	pongs    int32
+}
+
+func (p *websockifyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		r.Header.Get("Sec-WebSocket-Protocol") != "binary" {
+		http.Error(w, "bad upgrade request", http.StatusBadRequest)
+		return
+	}
+
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer target.Close()
+
+	c, brw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	h := sha1.New()
+	io.WriteString(h, r.Header.Get("Sec-WebSocket-Key")+websocketGUID)
+	io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: "+base64.StdEncoding.EncodeToString(h.Sum(nil))+"\r\n"+
+		"Sec-WebSocket-Protocol: "+p.protocol+"\r\n\r\n")
+
+	go p.clientToTarget(brw.Reader, target)
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := target.Read(buf)
+		if err != nil {
+			writeServerFrame(c, wsClose, nil, true)
+			return
+		}
+		writeServerFrame(c, wsPing, []byte("ping"), true)
+		for i := 0; i < n; i += 3 {
+			end := i + 3
+			if end > n {
+				end = n
+			}
+			opcode := byte(wsContinuation)
+			if i == 0 {
+				opcode = wsBinary
+			}
+			writeServerFrame(c, opcode, buf[i:end], end == n)
+		}
+	}
+}
+
+func (p *websockifyProxy) clientToTarget(r *bufio.Reader, target net.Conn) {
+	defer target.Close()
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		if hdr[1]&0x80 == 0 {
+			// Clients must mask
+			return
+		}
+		length := uint64(hdr[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			io.ReadFull(r, ext[:])
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			io.ReadFull(r, ext[:])
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		var mask [4]byte
+		io.ReadFull(r, mask[:])
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		for i := range payload {
+			payload[i] ^= mask[i&3]
+		}
+
+		switch hdr[0] & 0x0f {
+		case wsBinary, wsContinuation:
+			target.Write(payload)
+		case wsPong:
+			if string(payload) == "ping" {
+				atomic.AddInt32(&p.pongs, 1)
+			}
+		case wsClose:
+			return
+		}
+	}
+}
+
+func writeServerFrame(w io.Writer, opcode byte, payload []byte, fin bool) {
+	b := opcode
+	if fin {
+		b |= 0x80
+	}
+	// Server frames are unmasked, and these are always short.
+	w.Write(append([]byte{b, byte(len(payload))}, payload...))
+}
+
+func newWebsockifyServer(t *testing.T, useTLS bool, protocol string) (*httptest.Server, *websockifyProxy, func()) {
+	target, closeTarget := newMockRFBServer(t, "websockify")
+	proxy := &websockifyProxy{target: target, protocol: protocol}
+	var srv *httptest.Server
+	if useTLS {
+		srv = httptest.NewTLSServer(proxy)
+	} else {
+		srv = httptest.NewServer(proxy)
+	}
+	return srv, proxy, func() {
+		srv.Close()
+		closeTarget()
+	}
+}
+
+func connectWebSocket(t *testing.T, dialer Dialer, network, address string, proxy *websockifyProxy) {
+	c, err := dialer.Dial(network, address)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	conn, err, _ := vncclient.Client(c, &vncclient.ClientConfig{})
+	if err != nil {
+		t.Fatalf("RFB handshake over WebSocket failed: %s", err)
+	}
+	defer conn.Close()
+
+	if conn.DesktopName != "websockify" {
+		t.Errorf("DesktopName = %q, want %q", conn.DesktopName, "websockify")
+	}
+	if atomic.LoadInt32(&proxy.pongs) == 0 {
+		t.Error("expected a pong in reply to the proxy's pings")
+	}
+}
+
+func TestWebSocketDialer(t *testing.T) {
+	srv, proxy, cleanup := newWebsockifyServer(t, false, "binary")
+	defer cleanup()
+
+	dialer, network, address, err := ParseAddress("ws://" + srv.Listener.Addr().String() + "/websockify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectWebSocket(t, dialer, network, address, proxy)
+}
+
+func TestWebSocketDialer_TLS(t *testing.T) {
+	srv, proxy, cleanup := newWebsockifyServer(t, true, "binary")
+	defer cleanup()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	dialer := &WebSocketDialer{TLSConfig: &tls.Config{RootCAs: roots}}
+	connectWebSocket(t, dialer, "tcp", "wss://"+srv.Listener.Addr().String()+"/websockify", proxy)
+}
+
+func TestWebSocketDialer_UnsupportedProtocol(t *testing.T) {
+	srv, _, cleanup := newWebsockifyServer(t, false, "base64")
+	defer cleanup()
+
+	dialer := &WebSocketDialer{}
+	if _, err := dialer.Dial("tcp", "ws://"+srv.Listener.Addr().String()+"/"); err == nil {
+		t.Fatal("expected error for the base64 subprotocol")
+	}
+}
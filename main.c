@@ -48,6 +48,26 @@ typedef struct {
 
 static PyObject *go_vncdriver_Error;
 
+/* Subclasses of go_vncdriver.Error, one per error code (see errorCode
+   in main.go). Each one's base is the class at that index. */
+#define GO_VNCDRIVER_NUM_ERRORS 7
+
+static struct {
+  const char *name;
+  const char *code;
+  int base;
+} go_vncdriver_error_kinds[GO_VNCDRIVER_NUM_ERRORS] = {
+  {"Error", "error", 0},
+  {"AuthError", "auth", 0},
+  {"ConnectError", "connect", 0},
+  {"ProtocolError", "protocol", 0},
+  {"UnsupportedEncodingError", "unsupported_encoding", 3},
+  {"DecodeError", "decode", 0},
+  {"ClosedError", "closed", 0},
+};
+
+static PyObject *go_vncdriver_errors[GO_VNCDRIVER_NUM_ERRORS];
+
 /* Go functions exposed directly to Python */
 // PyObject * GoVNCDriver_VNCSession_peek(PyObject *, PyObject *);
 // PyObject * GoVNCDriver_VNCSession_flip(PyObject *, PyObject *);
@@ -55,6 +75,7 @@ PyObject * GoVNCDriver_VNCSession_step(PyObject *, PyObject *);
 PyObject * GoVNCDriver_VNCSession_close(PyObject *, PyObject *, PyObject *);
 PyObject * GoVNCDriver_VNCSession_render(PyObject *, PyObject *, PyObject *);
 PyObject * GoVNCDriver_VNCSession_connect(PyObject *, PyObject *, PyObject *);
+PyObject * GoVNCDriver_VNCSession_listen(PyObject *, PyObject *, PyObject *);
 PyObject * GoVNCDriver_VNCSession_update(PyObject *, PyObject *, PyObject *);
 
 /* Go functions which are called only from C */
@@ -71,6 +92,46 @@ void PyErr_SetGoVNCDriverError(char* msg) {
     free(msg);
 }
 
+// Returns a new instance of the exception class for code, with code
+// and retryable attributes set, or NULL with the Python error set.
+// Unknown codes get go_vncdriver.Error. Frees msg.
+PyObject *GoVNCDriver_NewError(char* code, char* msg, int retryable) {
+    int kind = 0, i;
+    for (i = 0; i < GO_VNCDRIVER_NUM_ERRORS; i++) {
+        if (strcmp(code, go_vncdriver_error_kinds[i].code) == 0) {
+            kind = i;
+            break;
+        }
+    }
+
+    PyObject *err = PyObject_CallFunction(go_vncdriver_errors[kind], "s", msg);
+    free(msg);
+    if (err == NULL) {
+        return NULL;
+    }
+
+    PyObject *codePy = PyUnicode_FromString(go_vncdriver_error_kinds[kind].code);
+    if (codePy == NULL || PyObject_SetAttrString(err, "code", codePy) < 0 ||
+        PyObject_SetAttrString(err, "retryable", retryable ? Py_True : Py_False) < 0) {
+        Py_XDECREF(codePy);
+        Py_DECREF(err);
+        return NULL;
+    }
+    Py_DECREF(codePy);
+    return err;
+}
+
+// Like PyErr_SetGoVNCDriverError, but raising the exception class for
+// code.
+void PyErr_SetGoVNCDriverErrorCode(char* code, char* msg, int retryable) {
+    PyObject *err = GoVNCDriver_NewError(code, msg, retryable);
+    if (err == NULL) {
+        return;
+    }
+    PyErr_SetObject((PyObject *) Py_TYPE(err), err);
+    Py_DECREF(err);
+}
+
 PyObject *GoPyArray_SimpleNew(int nd, npy_intp* dims, int typenum) {
     return PyArray_SimpleNew(nd, dims, typenum);
 }
@@ -115,6 +176,7 @@ static PyMemberDef go_vncdriver_VNCSession_members[] = {
 
 static PyMethodDef go_vncdriver_VNCSession_methods[] = {
   {"connect", (PyCFunction)GoVNCDriver_VNCSession_connect, METH_VARARGS|METH_KEYWORDS, "Connect an index to a new remote"},
+  {"listen", (PyCFunction)GoVNCDriver_VNCSession_listen, METH_VARARGS|METH_KEYWORDS, "Wait for a remote to connect to us on a port"},
   {"close", (PyCFunction)GoVNCDriver_VNCSession_close, METH_VARARGS|METH_KEYWORDS, "Closes the connection"},
   //  {"flip", (PyCFunction)GoVNCDriver_VNCSession_flip, METH_NOARGS, "Flips to the most recently updates screen"},
   //  {"peek", (PyCFunction)GoVNCDriver_VNCSession_peek, METH_NOARGS, "Peek at the last returned screen"},
@@ -208,6 +270,20 @@ initgo_vncdriver(void)
     Py_INCREF(go_vncdriver_Error);
     PyModule_AddObject(module, "Error", go_vncdriver_Error);
 
+    int i;
+    go_vncdriver_errors[0] = go_vncdriver_Error;
+    for (i = 1; i < GO_VNCDRIVER_NUM_ERRORS; i++) {
+        char qualname[64];
+        snprintf(qualname, sizeof(qualname), "go_vncdriver.%s", go_vncdriver_error_kinds[i].name);
+        go_vncdriver_errors[i] = PyErr_NewException(qualname, go_vncdriver_errors[go_vncdriver_error_kinds[i].base], NULL);
+        if (go_vncdriver_errors[i] == NULL) {
+            Py_DECREF(module);
+            INITERROR;
+        }
+        Py_INCREF(go_vncdriver_errors[i]);
+        PyModule_AddObject(module, go_vncdriver_error_kinds[i].name, go_vncdriver_errors[i]);
+    }
+
     go_vncdriver_VNCSession_type.tp_dealloc = (destructor)go_vncdriver_VNCSession_dealloc;
     go_vncdriver_VNCSession_type.tp_flags = Py_TPFLAGS_DEFAULT | Py_TPFLAGS_BASETYPE;
     go_vncdriver_VNCSession_type.tp_doc = "VNCSession objects";
@@ -11,6 +11,8 @@ PyObject *get_go_vncdriver_VNCSession_type();
 PyObject *GoPyArray_SimpleNew(int nd, npy_intp* dims, int typenum);
 PyObject *GoPyArray_SimpleNewFromData(int nd, npy_intp* dims, int typenum, void *data);
 void PyErr_SetGoVNCDriverError(char* msg);
+void PyErr_SetGoVNCDriverErrorCode(char* code, char* msg, int retryable);
+PyObject *GoVNCDriver_NewError(char* code, char* msg, int retryable);
 
 // Workaround missing variadic function support
 // https://github.com/golang/go/issues/975
@@ -18,9 +20,39 @@ static int PyArg_ParseTuple_list_O(PyObject *args, PyObject **a) {
     return PyArg_ParseTuple(args, "O", &PyList_Type, a);
 }
 
-static int PyArg_ParseTuple_connect(PyObject *args, PyObject *kwds, char **name, char **address, char **password, char **encoding, int *quality_level, int *compress_level, int *fine_quality_level, int *subsample_level, unsigned long *start_timeout, PyObject **subscription) {
-    static char *kwlist[] = {"name", "address", "password", "encoding", "quality_level", "compress_level", "fine_quality_level", "subsample_level", "start_timeout", "subscription", NULL};
-    return PyArg_ParseTupleAndKeywords(args, kwds, "ss|ssiiiikO", kwlist, name, address, password, encoding, quality_level, compress_level, fine_quality_level, subsample_level, start_timeout, subscription);
+// session_options holds the arguments connect and listen share, plus
+// the few that are only for one of them.
+typedef struct {
+    char *name;
+    char *address;
+    int port;
+    char *id;
+    char *desktop_name;
+
+    char *password;
+    char *encoding;
+    int quality_level;
+    int compress_level;
+    int fine_quality_level;
+    int subsample_level;
+    unsigned long start_timeout;
+    PyObject *subscription;
+    char *proxy_command;
+    double update_timeout;
+    int update_timeout_refresh;
+    int reconnect;
+    double reconnect_timeout;
+    int flush_each_event;
+    char *pixel_format;
+} session_options;
+
+static int PyArg_ParseTuple_session(PyObject *args, PyObject *kwds, int listen, session_options *o) {
+    static char *connect_kwlist[] = {"name", "address", "password", "encoding", "quality_level", "compress_level", "fine_quality_level", "subsample_level", "start_timeout", "subscription", "proxy_command", "update_timeout", "update_timeout_refresh", "reconnect", "reconnect_timeout", "flush_each_event", "pixel_format", NULL};
+    static char *listen_kwlist[] = {"name", "port", "password", "id", "desktop_name", "encoding", "quality_level", "compress_level", "fine_quality_level", "subsample_level", "start_timeout", "subscription", "update_timeout", "update_timeout_refresh", "reconnect", "reconnect_timeout", "flush_each_event", "pixel_format", NULL};
+    if (listen) {
+        return PyArg_ParseTupleAndKeywords(args, kwds, "si|ssssiiiikOdiidis", listen_kwlist, &o->name, &o->port, &o->password, &o->id, &o->desktop_name, &o->encoding, &o->quality_level, &o->compress_level, &o->fine_quality_level, &o->subsample_level, &o->start_timeout, &o->subscription, &o->update_timeout, &o->update_timeout_refresh, &o->reconnect, &o->reconnect_timeout, &o->flush_each_event, &o->pixel_format);
+    }
+    return PyArg_ParseTupleAndKeywords(args, kwds, "ss|ssiiiikOsdiidis", connect_kwlist, &o->name, &o->address, &o->password, &o->encoding, &o->quality_level, &o->compress_level, &o->fine_quality_level, &o->subsample_level, &o->start_timeout, &o->subscription, &o->proxy_command, &o->update_timeout, &o->update_timeout_refresh, &o->reconnect, &o->reconnect_timeout, &o->flush_each_event, &o->pixel_format);
 }
 
 static int PyArg_ParseTuple_close(PyObject *args, PyObject *kwds, char **name) {
@@ -88,6 +120,9 @@ var (
 	vncUpdatesPixels     *C.PyObject
 	vncUpdatesRectangles *C.PyObject
 	vncUpdatesBytes      *C.PyObject
+	vncConnectAttempts   *C.PyObject
+	vncReconnected       *C.PyObject
+	vncOutage            *C.PyObject
 
 	setup sync.Once
 )
@@ -98,7 +133,7 @@ func init() {
 		if err == nil {
 			fmt.Printf("%s\n", out)
 		} else {
-			fmt.Println("Error calling lsof: %v", err)
+			fmt.Printf("Error calling lsof: %v\n", err)
 		}
 	}
 }
@@ -110,6 +145,9 @@ func setupOnce() {
 	vncUpdatesPixels = C.PyUnicode_FromString(C.CString("stats.vnc.updates.pixels"))
 	vncUpdatesRectangles = C.PyUnicode_FromString(C.CString("stats.vnc.updates.rectangles"))
 	vncUpdatesBytes = C.PyUnicode_FromString(C.CString("stats.vnc.updates.bytes"))
+	vncConnectAttempts = C.PyUnicode_FromString(C.CString("vnc.connect.attempts"))
+	vncReconnected = C.PyUnicode_FromString(C.CString("vnc.reconnected"))
+	vncOutage = C.PyUnicode_FromString(C.CString("vnc.outage"))
 
 	gymvnc.ConfigureLogging()
 }
@@ -152,38 +190,7 @@ func GoVNCDriver_VNCSession_connect(self, args, kwds *C.PyObject) *C.PyObject {
 		return nil
 	}
 
-	_ = info
-
-	nameC := new(*C.char)
-	addressC := new(*C.char)
-	passwordC := new(*C.char)
-	encodingC := new(*C.char)
-	qualityLevelC := new(C.int)
-	compressLevelC := new(C.int)
-	fineQualityLevelC := new(C.int)
-	subsampleLevelC := new(C.int)
-	startTimeoutC := new(C.ulong)
-	subscriptionPy := new(*C.PyObject)
-
-	*compressLevelC = C.int(-1)
-	*qualityLevelC = C.int(-1)
-	*fineQualityLevelC = C.int(-1)
-	*subsampleLevelC = C.int(-1)
-
-	if C.PyArg_ParseTuple_connect(args, kwds, nameC, addressC, passwordC, encodingC, qualityLevelC, compressLevelC, fineQualityLevelC, subsampleLevelC, startTimeoutC, subscriptionPy) == 0 {
-		return nil
-	}
-
-	name := C.GoString(*nameC)
-	address := C.GoString(*addressC)
-	password := C.GoString(*passwordC)
-	encoding := C.GoString(*encodingC)
-	qualityLevel := int(*qualityLevelC)
-	compressLevel := int(*compressLevelC)
-	fineQualityLevel := int(*fineQualityLevelC)
-	subsampleLevel := int(*subsampleLevelC)
-	startTimeout := int(*startTimeoutC)
-	subscription, ok := convertSubscriptionPy(*subscriptionPy)
+	name, _, config, ok := parseSessionOptions(args, kwds, false)
 	if !ok {
 		return nil
 	}
@@ -193,24 +200,7 @@ func GoVNCDriver_VNCSession_connect(self, args, kwds *C.PyObject) *C.PyObject {
 		info.close(name)
 	}
 
-	if password == "" {
-		// our default password!
-		password = "openai"
-	}
-
-	err := info.batch.Open(name, gymvnc.VNCSessionConfig{
-		Address:  address,
-		Password: password,
-		Encoding: encoding,
-
-		QualityLevel:     qualityLevel,
-		CompressLevel:    compressLevel,
-		FineQualityLevel: fineQualityLevel,
-		SubsampleLevel:   subsampleLevel,
-		StartTimeout:     time.Duration(startTimeout) * time.Second,
-
-		Subscription: subscription,
-	})
+	err := info.batch.Open(name, config)
 	if err != nil {
 		setError(err)
 		return nil
@@ -230,6 +220,100 @@ func GoVNCDriver_VNCSession_connect(self, args, kwds *C.PyObject) *C.PyObject {
 	return Py_None
 }
 
+//export GoVNCDriver_VNCSession_listen
+func GoVNCDriver_VNCSession_listen(self, args, kwds *C.PyObject) *C.PyObject {
+	batchLock.Lock()
+	defer batchLock.Unlock()
+
+	ptr := uintptr(unsafe.Pointer(self))
+	info, ok := batchMgr[ptr]
+	if !ok {
+		setError(errors.New("VNCSession is already closed"))
+		return nil
+	}
+
+	name, port, config, ok := parseSessionOptions(args, kwds, true)
+	if !ok {
+		return nil
+	}
+
+	if _, ok := info.names[name]; ok {
+		log.Infof("disconnecting existing connection %s", name)
+		info.close(name)
+	}
+
+	err := info.batch.Listen(name, fmt.Sprintf(":%d", port), config.Password, config)
+	if err != nil {
+		setError(err)
+		return nil
+	}
+	info.open(name)
+
+	C.go_vncdriver_incref(Py_None)
+	return Py_None
+}
+
+// parseSessionOptions parses the arguments to connect, or to listen
+// if listen is set, which share all the options that configure the
+// session itself. It returns false with a Python exception set if the
+// arguments are invalid.
+func parseSessionOptions(args, kwds *C.PyObject, listen bool) (name string, port int, config gymvnc.VNCSessionConfig, ok bool) {
+	var o C.session_options
+	o.quality_level = C.int(-1)
+	o.compress_level = C.int(-1)
+	o.fine_quality_level = C.int(-1)
+	o.subsample_level = C.int(-1)
+
+	listenC := C.int(0)
+	if listen {
+		listenC = C.int(1)
+	}
+	if C.PyArg_ParseTuple_session(args, kwds, listenC, &o) == 0 {
+		return "", 0, config, false
+	}
+
+	subscription, ok := convertSubscriptionPy(o.subscription)
+	if !ok {
+		return "", 0, config, false
+	}
+
+	password := C.GoString(o.password)
+	if password == "" {
+		// our default password!
+		password = "openai"
+	}
+
+	config = gymvnc.VNCSessionConfig{
+		Address:  C.GoString(o.address),
+		Password: password,
+		Encoding: C.GoString(o.encoding),
+
+		PixelFormat: C.GoString(o.pixel_format),
+
+		ProxyCommand: C.GoString(o.proxy_command),
+
+		ListenID:          C.GoString(o.id),
+		ListenDesktopName: C.GoString(o.desktop_name),
+
+		QualityLevel:     int(o.quality_level),
+		CompressLevel:    int(o.compress_level),
+		FineQualityLevel: int(o.fine_quality_level),
+		SubsampleLevel:   int(o.subsample_level),
+		StartTimeout:     time.Duration(o.start_timeout) * time.Second,
+
+		UpdateTimeout:        time.Duration(float64(o.update_timeout) * float64(time.Second)),
+		UpdateTimeoutRefresh: o.update_timeout_refresh != 0,
+
+		Reconnect:        o.reconnect != 0,
+		ReconnectTimeout: time.Duration(float64(o.reconnect_timeout) * float64(time.Second)),
+
+		FlushEachEvent: o.flush_each_event != 0,
+
+		Subscription: subscription,
+	}
+	return C.GoString(o.name), int(o.port), config, true
+}
+
 //export GoVNCDriver_VNCSession_step
 func GoVNCDriver_VNCSession_step(self, actionDict *C.PyObject) (rep *C.PyObject) {
 	// defer func() {
@@ -288,10 +372,12 @@ func GoVNCDriver_VNCSession_step(self, actionDict *C.PyObject) (rep *C.PyObject)
 
 	// Put together the Python objects
 	observationN, updatesN, errN := info.batch.Step(batchEvents)
+	attemptsN := info.batch.ConnectAttempts()
+	outageN := info.batch.Outages()
 	if ok := info.populateScreenPyDict(observationN); !ok {
 		return nil
 	}
-	if ok := info.populateInfoPyDict(updatesN); !ok {
+	if ok := info.populateInfoPyDict(updatesN, attemptsN, outageN); !ok {
 		return nil
 	}
 	if ok := info.populateErrorPyDict(errN); !ok {
@@ -678,7 +764,33 @@ func GoVNCDriver_VNCSession_c_dealloc(self *C.go_vncdriver_VNCSession_object) {
 }
 
 func setError(err error) {
-	C.PyErr_SetGoVNCDriverError(C.CString(errors.ErrorStack(err)))
+	code, retryable := errorCode(err)
+	codeC := C.CString(code)
+	defer C.free(unsafe.Pointer(codeC))
+	C.PyErr_SetGoVNCDriverErrorCode(codeC, C.CString(errors.ErrorStack(err)), C.int(boolToLong(retryable)))
+}
+
+// errorCode maps err to the code of a Python exception class set up
+// in main.c, and whether it's retryable.
+func errorCode(err error) (string, bool) {
+	var code string
+	switch errors.Cause(err).(type) {
+	case *vncclient.AuthError:
+		code = "auth"
+	case *vncclient.ConnectError:
+		code = "connect"
+	case *vncclient.ProtocolError:
+		code = "protocol"
+	case *vncclient.UnsupportedEncodingError:
+		code = "unsupported_encoding"
+	case *vncclient.DecodeError:
+		code = "decode"
+	case *vncclient.ClosedError:
+		code = "closed"
+	default:
+		code = "error"
+	}
+	return code, vncclient.IsRetryable(err)
 }
 
 type sessionInfo struct {
@@ -742,7 +854,7 @@ func (b *sessionInfo) populateScreenPyDict(screens map[string]*gymvnc.Screen) bo
 	return true
 }
 
-func (b *sessionInfo) populateInfoPyDict(updateN map[string][]*vncclient.FramebufferUpdateMessage) bool {
+func (b *sessionInfo) populateInfoPyDict(updateN map[string][]*vncclient.FramebufferUpdateMessage, attemptsN map[string][]gymvnc.ConnectAttempt, outageN map[string]*gymvnc.Outage) bool {
 	C.PyDict_Clear(b.infoPyDict)
 
 	for name, update := range updateN {
@@ -805,19 +917,119 @@ func (b *sessionInfo) populateInfoPyDict(updateN map[string][]*vncclient.Framebu
 		if ok != C.int(0) {
 			return false
 		}
+
+		// Only present while (re)connecting
+		if attempts := attemptsN[name]; len(attempts) > 0 {
+			attemptsPy := convertAttemptsPy(attempts)
+			if attemptsPy == nil {
+				return false
+			}
+			ok = C.PyDict_SetItem(dict, vncConnectAttempts, attemptsPy)
+			C.go_vncdriver_decref(attemptsPy)
+			if ok != C.int(0) {
+				return false
+			}
+		}
+
+		// Only present while reconnecting, and on the first step
+		// after
+		if outage := outageN[name]; outage != nil {
+			reconnectedPy := C.PyBool_FromLong(boolToLong(outage.Reconnected))
+			ok = C.PyDict_SetItem(dict, vncReconnected, reconnectedPy)
+			C.go_vncdriver_decref(reconnectedPy)
+			if ok != C.int(0) {
+				return false
+			}
+
+			outagePy := C.PyFloat_FromDouble(C.double(outage.Duration.Seconds()))
+			ok = C.PyDict_SetItem(dict, vncOutage, outagePy)
+			C.go_vncdriver_decref(outagePy)
+			if ok != C.int(0) {
+				return false
+			}
+		}
 	}
 
 	return true
 }
 
+// convertAttemptsPy builds a list of dicts of the form
+// {"attempt": 1, "error": "...", "retry": True, "delay": 2.0}. Returns
+// a new reference, or nil with the Python error set.
+func convertAttemptsPy(attempts []gymvnc.ConnectAttempt) *C.PyObject {
+	listPy := C.PyList_New(C.Py_ssize_t(len(attempts)))
+	if listPy == nil {
+		return nil
+	}
+
+	for i, attempt := range attempts {
+		dict := C.PyDict_New()
+		if dict == nil {
+			C.go_vncdriver_decref(listPy)
+			return nil
+		}
+		// PyList_SetItem steals our reference
+		C.PyList_SetItem(listPy, C.Py_ssize_t(i), dict)
+
+		var errPy *C.PyObject
+		if attempt.Err != nil {
+			errC := C.CString(attempt.Err.Error())
+			errPy = C.PyUnicode_FromString(errC)
+			C.free(unsafe.Pointer(errC))
+		} else {
+			C.go_vncdriver_incref(Py_None)
+			errPy = Py_None
+		}
+
+		items := []struct {
+			key   string
+			value *C.PyObject
+		}{
+			{"attempt", C.PyLong_FromLong(C.long(attempt.Attempt))},
+			{"error", errPy},
+			{"retry", C.PyBool_FromLong(boolToLong(attempt.Retry))},
+			{"delay", C.PyFloat_FromDouble(C.double(attempt.Delay.Seconds()))},
+		}
+		failed := false
+		for _, item := range items {
+			if item.value == nil {
+				failed = true
+				continue
+			}
+			if !failed {
+				keyC := C.CString(item.key)
+				failed = C.PyDict_SetItemString(dict, keyC, item.value) != C.int(0)
+				C.free(unsafe.Pointer(keyC))
+			}
+			C.go_vncdriver_decref(item.value)
+		}
+		if failed {
+			C.go_vncdriver_decref(listPy)
+			return nil
+		}
+	}
+	return listPy
+}
+
+func boolToLong(b bool) C.long {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func (b *sessionInfo) populateErrorPyDict(errN map[string]error) bool {
 	C.PyDict_Clear(b.errPyDict)
 
 	for name, err := range errN {
 		if err != nil {
-			errC := C.CString(err.Error())
-			errPy := C.PyUnicode_FromString(errC)
-			C.free(unsafe.Pointer(errC))
+			// An exception instance, so it can be raised as-is;
+			// its code and retryable attributes say what went
+			// wrong.
+			code, retryable := errorCode(err)
+			codeC := C.CString(code)
+			errPy := C.GoVNCDriver_NewError(codeC, C.CString(err.Error()), C.int(boolToLong(retryable)))
+			C.free(unsafe.Pointer(codeC))
 			if errPy == nil {
 				return false
 			}
@@ -5,12 +5,15 @@
 package vncclient
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/juju/errors"
@@ -26,6 +29,35 @@ type ClientConn struct {
 	config   *ClientConfig
 	inflator *flexzlib.Inflator
 
+	// All reads from the server go through r, and all writes through
+	// w, which is flushed after each message. wbuf is scratch space
+	// for encoding messages into; w and wbuf need the send lock.
+	r    *bufio.Reader
+	w    *bufio.Writer
+	wbuf []byte
+
+	// jpegJobs feeds the JPEG workers, if there are any. jpegs holds
+	// the JPEG rectangles of the FramebufferUpdate being read that have
+	// gone to them; only the reader touches it.
+	jpegJobs chan *jpegJob
+	jpegs    *jpegQueue
+
+	// zrleBuf is what ZRLE rectangles are inflated into; only the
+	// reader touches it.
+	zrleBuf bytes.Buffer
+
+	// compressed holds zlib data too long to peek at in r; only the
+	// reader touches it.
+	compressed bytes.Buffer
+
+	// pixels converts pixels in PixelFormat into Colors; only the
+	// reader touches it. pendingFormat is the format SetPixelFormat
+	// last sent, for the reader to switch to at the start of the next
+	// message, and needs formatLock.
+	pixels        *pixelConverter
+	formatLock    sync.Mutex
+	pendingFormat *PixelFormat
+
 	// If the pixel format uses a color map, then this is the color
 	// map that is used. This should not be modified directly, since
 	// the data comes from the server.
@@ -46,12 +78,43 @@ type ClientConn struct {
 
 	// The pixel format associated with the connection. This shouldn't
 	// be modified. If you wish to set a new pixel format, use the
-	// SetPixelFormat method.
+	// SetPixelFormat method, which the reader picks up at the start of
+	// the next message from the server.
 	PixelFormat PixelFormat
 
+	// The negotiated minor protocol version (3, 7 or 8).
+	protocolMinor uint
+
 	errorCh chan error
+
+	// closing is closed by Close, to unblock the reader goroutine;
+	// done is closed once the reader goroutine has exited.
+	closing   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	done      chan struct{}
+
+	errLock sync.Mutex
+	err     error
 }
 
+// The sizes of the buffers for reading from and writing to the server.
+// Client messages are small, and the larger reads of pixel data bypass
+// the read buffer anyway.
+const (
+	readBufferSize  = 64 << 10
+	writeBufferSize = 4 << 10
+)
+
+// maxKeptBuffer is the most memory a scratch buffer keeps from one
+// rectangle to the next, so that one huge rectangle doesn't leave its
+// buffer's worth resident for the rest of the connection.
+const maxKeptBuffer = 4 << 20
+
+// ErrClosed is what Err returns once a connection has been shut down
+// by Close, rather than by an error.
+var ErrClosed error = &ClosedError{}
+
 // A ClientConfig structure is used to configure a ClientConn. After
 // one has been passed to initialize a connection, it must not be modified.
 type ClientConfig struct {
@@ -66,9 +129,9 @@ type ClientConfig struct {
 
 	// The channel that all messages received from the server will be
 	// sent on. If the channel blocks, then the goroutine reading data
-	// from the VNC server may block indefinitely. It is up to the user
-	// of the library to ensure that this channel is properly read.
-	// If this is not set, then all messages will be discarded.
+	// from the VNC server blocks until it is read or the connection
+	// is closed. If this is not set, then all messages will be
+	// discarded.
 	ServerMessageCh chan<- ServerMessage
 
 	// A slice of supported messages that can be read from the server.
@@ -76,7 +139,40 @@ type ClientConfig struct {
 	// need to explicitly contain the RFC-required messages.
 	ServerMessages []ServerMessage
 
+	// The channel the error that stops the reader goroutine is sent
+	// on. The send never blocks: if the channel has no buffer space
+	// and nobody is receiving, the error is dropped. Either way it is
+	// available from Err once Done is closed.
 	ErrorCh chan error
+
+	// ReadTimeout, if positive, bounds how long reading a single
+	// server message may take once its first byte has arrived.
+	// Waiting for a message to start is not bounded, since servers
+	// only send when there's something to say.
+	ReadTimeout time.Duration
+
+	// WriteTimeout, if positive, is the deadline for sending each
+	// client message.
+	WriteTimeout time.Duration
+
+	// Limits bounds what the server can make us allocate.
+	Limits Limits
+
+	// Framebuffer, if set, is where framebuffer updates are painted,
+	// rather than into Colors of their own. If it's an
+	// UpdateFramebuffer, it's also told where each update begins and
+	// ends.
+	Framebuffer Framebuffer
+
+	// JPEGWorkers is how many goroutines decode Tight JPEG rectangles,
+	// while the reader carries on with the rest of their update. Zero
+	// means GOMAXPROCS; if negative, the reader decodes them itself.
+	JPEGWorkers int
+
+	// FlushEachEvent makes SendEvents write each event to the server
+	// on its own, as KeyEvent and PointerEvent do, rather than the
+	// whole batch at once. It's meant for latency experiments.
+	FlushEachEvent bool
 }
 
 type ByteReader struct {
@@ -100,25 +196,156 @@ func (br *ByteReader) ReadByte() (byte, error) {
 }
 
 func Client(c net.Conn, cfg *ClientConfig) (*ClientConn, error, bool) {
+	return ClientWithContext(context.Background(), c, cfg)
+}
+
+// ClientWithContext is like Client, but aborts the handshake if ctx is
+// done first. As with net.Dialer.DialContext, ctx only covers
+// establishing the connection; use Close to shut it down afterwards.
+func ClientWithContext(ctx context.Context, c net.Conn, cfg *ClientConfig) (*ClientConn, error, bool) {
 	conn := &ClientConn{
 		c:        c,
 		config:   cfg,
 		inflator: flexzlib.NewInflator(),
+		r:        bufio.NewReaderSize(c, readBufferSize),
+		w:        bufio.NewWriterSize(c, writeBufferSize),
 		errorCh:  cfg.ErrorCh,
-	}
-
-	if err, soft := conn.handshake(); err != nil {
-		conn.Close()
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// Events are tiny, and shouldn't wait on Nagle's algorithm. Go
+	// turns it off by default, but don't rely on the dialer not to
+	// have turned it back on.
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetNoDelay(true)
+	}
+
+	if err := ctx.Err(); err != nil {
+		err = &ClosedError{Err: errors.Annotate(err, "handshake aborted")}
+		conn.abort(err)
+		return nil, err, false
+	}
+
+	// Interrupt any blocked I/O if ctx is done mid-handshake. We
+	// must know the watcher has stopped before handing the conn on,
+	// so it can't interfere with the reader.
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	err, soft := conn.handshake()
+	close(stop)
+	<-stopped
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = &ClosedError{Err: errors.Annotate(ctxErr, "handshake aborted")}
+		conn.abort(err)
+		return nil, err, false
+	} else if err != nil {
+		err = asConnectError(err, soft)
+		conn.abort(err)
 		return nil, err, soft
 	}
 
+	conn.startJPEGWorkers(jpegWorkers(cfg))
 	go conn.mainLoop()
 
 	return conn, nil, false
 }
 
+// abort tears down a connection whose reader was never started.
+func (c *ClientConn) abort(err error) {
+	c.setErr(err)
+	c.shutdown()
+	close(c.done)
+}
+
+// Close closes the connection, and waits for the goroutine reading
+// from the server to exit. It is safe to call more than once.
 func (c *ClientConn) Close() error {
-	return c.c.Close()
+	c.shutdown()
+	<-c.done
+	return c.closeErr
+}
+
+// shutdown closes the underlying connection without waiting for the
+// reader, so the reader can use it on its way out.
+func (c *ClientConn) shutdown() {
+	c.closeOnce.Do(func() {
+		close(c.closing)
+		c.closeErr = c.c.Close()
+	})
+}
+
+// Done returns a channel that is closed once the goroutine reading
+// from the server has exited, whether due to an error or Close.
+func (c *ClientConn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns nil while the connection is running. Once Done is
+// closed, it returns the error that stopped the reader, or ErrClosed
+// if it was stopped by Close.
+func (c *ClientConn) Err() error {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	return c.err
+}
+
+// setErr records the first error to stop the connection.
+func (c *ClientConn) setErr(err error) {
+	c.errLock.Lock()
+	defer c.errLock.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+// rawWrite writes b to the server and flushes it.
+func (c *ClientConn) rawWrite(b []byte) error {
+	if _, err := c.w.Write(b); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// write sends one client message, within WriteTimeout. If that fails
+// because the reader has already stopped the connection, it returns
+// the reader's error. Must hold the send lock.
+func (c *ClientConn) write(b []byte) error {
+	if c.config.WriteTimeout > 0 {
+		c.c.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+		defer c.c.SetWriteDeadline(time.Time{})
+	}
+	err := c.rawWrite(b)
+	if err == nil {
+		return nil
+	}
+	select {
+	case <-c.closing:
+		if readErr := c.Err(); readErr != nil && readErr != ErrClosed {
+			return readErr
+		}
+		return &ClosedError{Err: err}
+	default:
+	}
+	if isTimeout(err) {
+		err = errors.Annotatef(err, "timed out after %s sending to server", c.config.WriteTimeout)
+	}
+	return asConnectError(err, true)
+}
+
+func isTimeout(err error) bool {
+	ne, ok := errors.Cause(err).(net.Error)
+	return ok && ne.Timeout()
 }
 
 // CutText tells the server that the client has new text in its cut buffer.
@@ -131,39 +358,19 @@ func (c *ClientConn) CutText(text string) error {
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	var buf bytes.Buffer
-
-	// This is the fixed size data we'll send
-	fixedData := []interface{}{
-		uint8(6),
-		uint8(0),
-		uint8(0),
-		uint8(0),
-		uint32(len(text)),
-	}
-
-	for _, val := range fixedData {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			return err
-		}
-	}
-
+	// The length is filled in once the text is encoded, since
+	// Latin-1 is shorter than UTF-8.
+	b := append(c.wbuf[:0], 6, 0, 0, 0, 0, 0, 0, 0)
 	for _, char := range text {
 		if char > unicode.MaxLatin1 {
-			return errors.Errorf("Character '%s' is not valid Latin-1", char)
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, uint8(char)); err != nil {
-			return err
+			return errors.Errorf("Character %q is not valid Latin-1", char)
 		}
+		b = append(b, uint8(char))
 	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)-8))
+	c.wbuf = b
 
-	dataLength := 8 + len(text)
-	if _, err := c.c.Write(buf.Bytes()[0:dataLength]); err != nil {
-		return err
-	}
-
-	return nil
+	return c.write(b)
 }
 
 // FramebufferUpdateRequest requests a framebuffer update from the server.
@@ -175,30 +382,14 @@ func (c *ClientConn) FramebufferUpdateRequest(incremental bool, x, y, width, hei
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	var buf bytes.Buffer
-	var incrementalByte uint8
-
-	if incremental {
-		incrementalByte = 1
-	}
-
-	data := []interface{}{
-		uint8(3),
-		incrementalByte,
-		x, y, width, height,
-	}
-
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			return err
-		}
-	}
+	b := appendBool(append(c.wbuf[:0], 3), incremental)
+	b = appendU16(b, x)
+	b = appendU16(b, y)
+	b = appendU16(b, width)
+	b = appendU16(b, height)
+	c.wbuf = b
 
-	if _, err := c.c.Write(buf.Bytes()[0:10]); err != nil {
-		return err
-	}
-
-	return nil
+	return c.write(b)
 }
 
 // KeyEvent indiciates a key press or release and sends it to the server.
@@ -211,31 +402,10 @@ func (c *ClientConn) KeyEvent(keysym uint32, down bool) error {
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	buf := bytes.NewBuffer(make([]byte, 0, 8))
-
-	var downFlag uint8
-	if down {
-		downFlag = 1
-	}
-
-	data := []interface{}{
-		uint8(4),
-		downFlag,
-		uint8(0),
-		uint8(0),
-		keysym,
-	}
-	for _, val := range data {
-		if err := binary.Write(buf, binary.BigEndian, val); err != nil {
-			return err
-		}
-	}
-
-	if _, err := c.c.Write(buf.Bytes()[0:8]); err != nil {
-		return err
-	}
+	b := appendKeyEvent(c.wbuf[:0], keysym, down)
+	c.wbuf = b
 
-	return nil
+	return c.write(b)
 }
 
 // PointerEvent indicates that pointer movement or a pointer button
@@ -249,25 +419,79 @@ func (c *ClientConn) PointerEvent(mask ButtonMask, x, y uint16) error {
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	buf := bytes.NewBuffer(make([]byte, 0, 6))
+	b := appendPointerEvent(c.wbuf[:0], mask, x, y)
+	c.wbuf = b
+
+	return c.write(b)
+}
+
+func appendKeyEvent(b []byte, keysym uint32, down bool) []byte {
+	b = appendBool(append(b, 4), down)
+	b = append(b, 0, 0)
+	return appendU32(b, keysym)
+}
+
+func appendPointerEvent(b []byte, mask ButtonMask, x, y uint16) []byte {
+	b = append(b, 5, uint8(mask))
+	b = appendU16(b, x)
+	return appendU16(b, y)
+}
+
+// An EventBatch collects key and pointer events for SendEvents to send
+// together. The zero value is an empty batch.
+type EventBatch struct {
+	b []byte
+}
 
-	data := []interface{}{
-		uint8(5),
-		uint8(mask),
-		x,
-		y,
+// KeyEvent adds a key press or release to the batch. See
+// ClientConn.KeyEvent.
+func (e *EventBatch) KeyEvent(keysym uint32, down bool) {
+	e.b = appendKeyEvent(e.b, keysym, down)
+}
+
+// PointerEvent adds pointer movement or a button press or release to
+// the batch. See ClientConn.PointerEvent.
+func (e *EventBatch) PointerEvent(mask ButtonMask, x, y uint16) {
+	e.b = appendPointerEvent(e.b, mask, x, y)
+}
+
+// Empty reports whether there are no events in the batch.
+func (e *EventBatch) Empty() bool {
+	return len(e.b) == 0
+}
+
+// Reset empties the batch, keeping its memory for reuse.
+func (e *EventBatch) Reset() {
+	e.b = e.b[:0]
+}
+
+// SendEvents sends the events in batch to the server in the order they
+// were added, with a single write unless FlushEachEvent is set, and
+// empties the batch. Sending an empty batch does nothing.
+func (c *ClientConn) SendEvents(batch *EventBatch) error {
+	b := batch.b
+	batch.Reset()
+	if len(b) == 0 {
+		return nil
 	}
 
-	for _, val := range data {
-		if err := binary.Write(buf, binary.BigEndian, val); err != nil {
+	c.send.Lock()
+	defer c.send.Unlock()
+
+	if !c.config.FlushEachEvent {
+		return c.write(b)
+	}
+	for len(b) > 0 {
+		// Key events are 8 bytes, and pointer events 6
+		n := 8
+		if b[0] == 5 {
+			n = 6
+		}
+		if err := c.write(b[:n]); err != nil {
 			return err
 		}
+		b = b[n:]
 	}
-
-	if _, err := c.c.Write(buf.Bytes()[0:6]); err != nil {
-		return err
-	}
-
 	return nil
 }
 
@@ -280,24 +504,14 @@ func (c *ClientConn) SetEncodings(encs []Encoding) error {
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	data := make([]interface{}, 3+len(encs))
-	data[0] = uint8(2)
-	data[1] = uint8(0)
-	data[2] = uint16(len(encs))
-
-	for i, enc := range encs {
-		data[3+i] = int32(enc.Type())
-	}
-
-	var buf bytes.Buffer
-	for _, val := range data {
-		if err := binary.Write(&buf, binary.BigEndian, val); err != nil {
-			return err
-		}
+	b := append(c.wbuf[:0], 2, 0)
+	b = appendU16(b, uint16(len(encs)))
+	for _, enc := range encs {
+		b = appendU32(b, uint32(enc.Type()))
 	}
+	c.wbuf = b
 
-	dataLength := 4 + (4 * len(encs))
-	if _, err := c.c.Write(buf.Bytes()[0:dataLength]); err != nil {
+	if err := c.write(b); err != nil {
 		return err
 	}
 
@@ -307,34 +521,57 @@ func (c *ClientConn) SetEncodings(encs []Encoding) error {
 }
 
 // SetPixelFormat sets the format in which pixel values should be sent
-// in FramebufferUpdate messages from the server.
+// in FramebufferUpdate messages from the server. Messages the server
+// sent before it got this one are still in the old format, but are
+// read as if in the new one, so it's best not to change format while
+// an update is outstanding.
 //
 // See RFC 6143 Section 7.5.1
 func (c *ClientConn) SetPixelFormat(format *PixelFormat) error {
 	c.send.Lock()
 	defer c.send.Unlock()
 
-	var keyEvent [20]byte
-	keyEvent[0] = 0
-
-	pfBytes, err := WritePixelFormat(format)
-	if err != nil {
-		return err
-	}
+	b := appendPixelFormat(append(c.wbuf[:0], 0, 0, 0, 0), format)
+	c.wbuf = b
 
-	// Copy the pixel format bytes into the proper slice location
-	copy(keyEvent[4:], pfBytes)
+	// The reply could come before the write returns, so the reader
+	// has to be told first
+	pending := *format
+	c.formatLock.Lock()
+	c.pendingFormat = &pending
+	c.formatLock.Unlock()
 
 	// Send the data down the connection
-	if _, err := c.c.Write(keyEvent[:]); err != nil {
-		return err
+	return c.write(b)
+}
+
+// switchPixelFormat adopts the format SetPixelFormat last sent, if it
+// hasn't been already. Only the reader may call it.
+func (c *ClientConn) switchPixelFormat() {
+	c.formatLock.Lock()
+	format := c.pendingFormat
+	c.pendingFormat = nil
+	c.formatLock.Unlock()
+	if format == nil {
+		return
 	}
 
+	c.PixelFormat = *format
 	// Reset the color map as according to RFC.
-	var newColorMap [256]Color
-	c.ColorMap = newColorMap
+	c.ColorMap = [256]Color{}
+}
 
-	return nil
+// converter returns the pixelConverter for PixelFormat, building it
+// if the format has changed since it was last asked for.
+func (c *ClientConn) converter() (*pixelConverter, error) {
+	if c.pixels == nil || c.pixels.format != c.PixelFormat {
+		p, err := newPixelConverter(&c.PixelFormat, &c.ColorMap)
+		if err != nil {
+			return nil, err
+		}
+		c.pixels = p
+	}
+	return c.pixels, nil
 }
 
 const pvLen = 12 // ProtocolVersion message length.
@@ -357,37 +594,59 @@ func ParseProtocolVersion(pv []byte) (uint, uint, error) {
 	return major, minor, nil
 }
 
-func (c *ClientConn) securityHandshakeVersion8() error {
-	var err error
+// negotiateVersion picks the protocol version we'll speak given the
+// version advertised by the server. Anything newer than 3.8 (e.g. Apple's
+// 3.889 or RealVNC's 4.x) is answered with 3.8, and the non-standard
+// 3.4-3.6 versions are treated as 3.3, per RFC 6143 Section 7.1.1.
+func negotiateVersion(major, minor uint) (uint, error) {
+	if major < 3 {
+		return 0, errors.Errorf("unsupported major version, less than 3: %d", major)
+	}
+	if major == 3 && minor < 3 {
+		return 0, errors.Errorf("unsupported minor version, less than 3: %d", minor)
+	}
 
-	// Respond with the version we will support
-	if _, err = c.c.Write([]byte("RFB 003.008\n")); err != nil {
-		return err
+	switch {
+	case major > 3 || minor >= 8:
+		return 8, nil
+	case minor == 7:
+		return 7, nil
+	default:
+		return 3, nil
+	}
+}
+
+func (c *ClientConn) clientSecurityTypes() []ClientAuth {
+	if c.config.Auth == nil {
+		return []ClientAuth{new(ClientAuthNone)}
 	}
+	return c.config.Auth
+}
+
+// securityHandshakeVersion7 performs the security handshake used by
+// versions 3.7 and 3.8, where the server offers a list of security types
+// and the client picks one.
+func (c *ClientConn) securityHandshakeVersion7() (ClientAuth, error) {
+	var err error
 
 	// 7.1.2 Security Handshake from server
-	var numSecurityTypes uint8
-	if err = binary.Read(c.c, binary.BigEndian, &numSecurityTypes); err != nil {
-		return err
+	numSecurityTypes, err := readU8(c.r)
+	if err != nil {
+		return nil, err
 	}
 
 	if numSecurityTypes == 0 {
-		return errors.Errorf("no security types: %s", c.readErrorReason())
+		return nil, &AuthError{errors.Errorf("no security types: %s", c.readErrorReason())}
 	}
 
 	securityTypes := make([]uint8, numSecurityTypes)
-	if err = binary.Read(c.c, binary.BigEndian, &securityTypes); err != nil {
-		return err
-	}
-
-	clientSecurityTypes := c.config.Auth
-	if clientSecurityTypes == nil {
-		clientSecurityTypes = []ClientAuth{new(ClientAuthNone)}
+	if _, err = io.ReadFull(c.r, securityTypes); err != nil {
+		return nil, err
 	}
 
 	var auth ClientAuth
 FindAuth:
-	for _, curAuth := range clientSecurityTypes {
+	for _, curAuth := range c.clientSecurityTypes() {
 		for _, securityType := range securityTypes {
 			if curAuth.SecurityType() == securityType {
 				// We use the first matching supported authentication
@@ -398,44 +657,41 @@ FindAuth:
 	}
 
 	if auth == nil {
-		return errors.Errorf("no suitable auth schemes found. server supported: %#v", securityTypes)
+		return nil, &AuthError{errors.Errorf("no suitable auth schemes found. server supported: %#v", securityTypes)}
 	}
 
 	// Respond back with the security type we'll use
-	if err = binary.Write(c.c, binary.BigEndian, auth.SecurityType()); err != nil {
-		return err
+	if err = c.rawWrite([]byte{auth.SecurityType()}); err != nil {
+		return nil, err
 	}
 
-	if err = auth.Handshake(c.c); err != nil {
-		return err
+	if err = auth.Handshake(bufferedConn{c.c, c.r}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return auth, nil
 }
 
-func (c *ClientConn) securityHandshakeVersion3() error {
+// securityHandshakeVersion3 performs the version 3.3 security handshake,
+// where the server decides on the security type by itself.
+func (c *ClientConn) securityHandshakeVersion3() (ClientAuth, error) {
 	var err error
 
-	// Respond with the version we will support
-	if _, err = c.c.Write([]byte("RFB 003.003\n")); err != nil {
-		return err
-	}
-
 	// 7.1.2 Security Handshake from server
-	var securityType uint32
-	if err = binary.Read(c.c, binary.BigEndian, &securityType); err != nil {
-		return err
+	securityType, err := readU32(c.r)
+	if err != nil {
+		return nil, err
 	}
 
-	clientSecurityTypes := c.config.Auth
-	if clientSecurityTypes == nil {
-		clientSecurityTypes = []ClientAuth{new(ClientAuthNone)}
+	if securityType == 0 {
+		// The server rejected our connection, and tells us why.
+		return nil, &AuthError{errors.Errorf("server rejected connection: %s", c.readErrorReason())}
 	}
 
 	var auth ClientAuth
 FindAuth:
-	for _, curAuth := range clientSecurityTypes {
-		if curAuth.SecurityType() == uint8(securityType) {
+	for _, curAuth := range c.clientSecurityTypes() {
+		if uint32(curAuth.SecurityType()) == securityType {
 			// We use the first matching supported authentication
 			auth = curAuth
 			break FindAuth
@@ -443,59 +699,65 @@ FindAuth:
 	}
 
 	if auth == nil {
-		if securityType == 0 {
-			return errors.Errorf("server returned an invalid security type: %#v. That means it likely rejected our connection.", securityType)
-		} else {
-			return errors.Errorf("did not support server-requested auth scheme: %#v", securityType)
-		}
+		return nil, &AuthError{errors.Errorf("did not support server-requested auth scheme: %#v", securityType)}
 	}
 
-	if err = auth.Handshake(c.c); err != nil {
-		return err
+	if err = auth.Handshake(bufferedConn{c.c, c.r}); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return auth, nil
 }
 
 func (c *ClientConn) handshake() (error, bool) {
 	var protocolVersion [pvLen]byte
 
 	// 7.1.1, read the ProtocolVersion message sent by the server.
-	if _, err := io.ReadFull(c.c, protocolVersion[:]); err != nil {
+	if _, err := io.ReadFull(c.r, protocolVersion[:]); err != nil {
 		return err, true
 	}
 
 	maxMajor, maxMinor, err := ParseProtocolVersion(protocolVersion[:])
 	if err != nil {
-		return err, false
+		return &ProtocolError{err}, false
 	}
-	if maxMajor < 3 {
-		return errors.Errorf("unsupported major version, less than 3: %d", maxMajor), false
+
+	c.protocolMinor, err = negotiateVersion(maxMajor, maxMinor)
+	if err != nil {
+		return &ProtocolError{err}, false
 	}
-	if maxMinor < 3 {
-		return errors.Errorf("unsupported minor version, less than 3: %d", maxMinor), false
+
+	// Respond with the version we will support
+	if err = c.rawWrite([]byte(fmt.Sprintf("RFB 003.%03d\n", c.protocolMinor))); err != nil {
+		return err, false
 	}
 
-	if maxMinor == 8 {
-		err := c.securityHandshakeVersion8()
-		if err != nil {
-			return err, false
-		}
+	var auth ClientAuth
+	if c.protocolMinor >= 7 {
+		auth, err = c.securityHandshakeVersion7()
 	} else {
-		err := c.securityHandshakeVersion3()
-		if err != nil {
-			return err, false
-		}
+		auth, err = c.securityHandshakeVersion3()
 	}
-
-	// 7.1.3 SecurityResult Handshake
-	var securityResult uint32
-	if err = binary.Read(c.c, binary.BigEndian, &securityResult); err != nil {
+	if err != nil {
 		return err, false
 	}
 
-	if securityResult == 1 {
-		return errors.Errorf("security handshake failed: %s", c.readErrorReason()), false
+	// 7.1.3 SecurityResult Handshake. Before 3.8, the server skips
+	// this for the None security type.
+	if c.protocolMinor >= 8 || auth.SecurityType() != new(ClientAuthNone).SecurityType() {
+		securityResult, err := readU32(c.r)
+		if err != nil {
+			return err, false
+		}
+
+		if securityResult != 0 {
+			// Only 3.8 servers explain why the handshake failed.
+			reason := "no reason given"
+			if c.protocolMinor >= 8 {
+				reason = c.readErrorReason()
+			}
+			return &AuthError{errors.Errorf("security handshake failed: %s", reason)}, false
+		}
 	}
 
 	// 7.3.1 ClientInit
@@ -504,31 +766,40 @@ func (c *ClientConn) handshake() (error, bool) {
 		sharedFlag = 0
 	}
 
-	if err = binary.Write(c.c, binary.BigEndian, sharedFlag); err != nil {
+	if err = c.rawWrite([]byte{sharedFlag}); err != nil {
 		return err, false
 	}
 
 	// 7.3.2 ServerInit
-	if err = binary.Read(c.c, binary.BigEndian, &c.FramebufferWidth); err != nil {
+	if c.FramebufferWidth, err = readU16(c.r); err != nil {
 		return err, false
 	}
 
-	if err = binary.Read(c.c, binary.BigEndian, &c.FramebufferHeight); err != nil {
+	if c.FramebufferHeight, err = readU16(c.r); err != nil {
 		return err, false
 	}
 
+	limits := c.limits()
+	if int(c.FramebufferWidth) > limits.MaxFramebufferWidth || int(c.FramebufferHeight) > limits.MaxFramebufferHeight {
+		return &ProtocolError{errors.Errorf("%dx%d desktop exceeds limit of %dx%d", c.FramebufferWidth, c.FramebufferHeight, limits.MaxFramebufferWidth, limits.MaxFramebufferHeight)}, false
+	}
+
 	// Read the pixel format
-	if err = ReadPixelFormat(c.c, &c.PixelFormat); err != nil {
+	if err = ReadPixelFormat(c.r, &c.PixelFormat); err != nil {
 		return err, false
 	}
 
-	var nameLength uint32
-	if err = binary.Read(c.c, binary.BigEndian, &nameLength); err != nil {
+	nameLength, err := readU32(c.r)
+	if err != nil {
 		return err, false
 	}
 
+	if int64(nameLength) > int64(limits.MaxDesktopNameBytes) {
+		return &ProtocolError{errors.Errorf("%d byte desktop name exceeds limit of %d", nameLength, limits.MaxDesktopNameBytes)}, false
+	}
+
 	nameBytes := make([]uint8, nameLength)
-	if err = binary.Read(c.c, binary.BigEndian, &nameBytes); err != nil {
+	if _, err = io.ReadFull(c.r, nameBytes); err != nil {
 		return err, false
 	}
 
@@ -540,7 +811,8 @@ func (c *ClientConn) handshake() (error, bool) {
 // mainLoop reads messages sent from the server and routes them to the
 // proper channels for users of the client to read.
 func (c *ClientConn) mainLoop() {
-	defer c.Close()
+	defer close(c.done)
+	defer c.shutdown()
 
 	// Build the map of available server messages
 	typeMap := make(map[uint8]ServerMessage)
@@ -563,49 +835,107 @@ func (c *ClientConn) mainLoop() {
 	}
 
 	for {
-		var messageType uint8
-		if err := binary.Read(c.c, binary.BigEndian, &messageType); err != nil {
-			c.reportError(errors.Annotate(err, "could not read message type"))
+		messageType, err := c.r.ReadByte()
+		if err != nil {
+			c.reportError(asConnectError(errors.Annotate(err, "could not read message type"), true))
 			break
 		}
 
+		c.switchPixelFormat()
 		msg, ok := typeMap[messageType]
 		if !ok {
 			// Unsupported message type! Bad!
-			c.reportError(errors.Errorf("no such message type: %d", messageType))
+			c.reportError(&ProtocolError{errors.Errorf("no such message type: %d", messageType)})
 			break
 		}
 
-		parsedMsg, err := msg.Read(c, c.c)
+		if c.config.ReadTimeout > 0 {
+			c.c.SetReadDeadline(time.Now().Add(c.config.ReadTimeout))
+		}
+		parsedMsg, err := c.readMessage(msg)
 		if err != nil {
+			if isTimeout(err) {
+				err = errors.Annotatef(err, "timed out after %s reading %T", c.config.ReadTimeout, msg)
+			}
+			if isIOError(err) {
+				err = asConnectError(err, true)
+			} else if _, ok := errors.Cause(err).(Error); !ok {
+				err = &ProtocolError{err}
+			}
 			c.reportError(err)
 			break
 		}
+		if c.config.ReadTimeout > 0 {
+			c.c.SetReadDeadline(time.Time{})
+		}
 
 		if c.config.ServerMessageCh == nil {
 			continue
 		}
 
-		c.config.ServerMessageCh <- parsedMsg
+		select {
+		case c.config.ServerMessageCh <- parsedMsg:
+		case <-c.closing:
+			c.setErr(ErrClosed)
+			return
+		}
 	}
 }
 
+// readMessage reads msg from the server. Rectangles that panic are
+// caught with more detail by FramebufferUpdateMessage; this catches
+// panics in any other message, so that they only end this connection.
+func (c *ClientConn) readMessage(msg ServerMessage) (parsed ServerMessage, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			parsed, err = nil, PanicError(v, 0, nil)
+		}
+	}()
+	return msg.Read(c, c.r)
+}
+
+// reportError records the error that stopped the reader, and passes
+// it on to ErrorCh if that can be done without blocking. Errors caused
+// by Close are not reported.
 func (c *ClientConn) reportError(err error) {
+	select {
+	case <-c.closing:
+		c.setErr(ErrClosed)
+		return
+	default:
+	}
+
+	c.setErr(err)
 	if c.errorCh != nil {
-		c.errorCh <- err
+		select {
+		case c.errorCh <- err:
+		default:
+		}
 	}
 }
 
 func (c *ClientConn) readErrorReason() string {
-	var reasonLen uint32
-	if err := binary.Read(c.c, binary.BigEndian, &reasonLen); err != nil {
+	reasonLen, err := readU32(c.r)
+	if err != nil {
 		return "<error>"
 	}
 
-	reason := make([]uint8, reasonLen)
-	if err := binary.Read(c.c, binary.BigEndian, &reason); err != nil {
+	// Keep the start of an overlong reason, and skip the rest
+	max := c.limits().MaxReasonBytes
+	keep := reasonLen
+	if int64(keep) > int64(max) {
+		keep = uint32(max)
+	}
+	reason := make([]uint8, keep)
+	if _, err := io.ReadFull(c.r, reason); err != nil {
 		return "<error>"
 	}
+	if keep < reasonLen {
+		if _, err := c.r.Discard(int(reasonLen - keep)); err != nil {
+			return "<error>"
+		}
+		return string(reason) + "..."
+	}
 
 	return string(reason)
 }
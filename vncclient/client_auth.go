@@ -1,10 +1,10 @@
 package vncclient
 
 import (
+	"io"
 	"net"
 
 	"crypto/des"
-	"encoding/binary"
 )
 
 // A ClientAuth implements a method of authenticating with a remote server.
@@ -40,7 +40,7 @@ func (p *PasswordAuth) SecurityType() uint8 {
 
 func (p *PasswordAuth) Handshake(c net.Conn) error {
 	randomValue := make([]uint8, 16)
-	if err := binary.Read(c, binary.BigEndian, &randomValue); err != nil {
+	if _, err := io.ReadFull(c, randomValue); err != nil {
 		return err
 	}
 
@@ -50,7 +50,7 @@ func (p *PasswordAuth) Handshake(c net.Conn) error {
 		return err
 	}
 
-	if err := binary.Write(c, binary.BigEndian, &crypted); err != nil {
+	if _, err := c.Write(crypted); err != nil {
 		return err
 	}
 
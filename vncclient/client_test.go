@@ -1,12 +1,22 @@
 package vncclient
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
-func newMockServer(t *testing.T, version string) string {
+// newMockServer starts a server that accepts a single connection, sends
+// the given ProtocolVersion and then hands the connection to script.
+func newMockServer(t *testing.T, version string, script func(c net.Conn) error) string {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("error listening: %s", err)
@@ -16,26 +26,116 @@ func newMockServer(t *testing.T, version string) string {
 		defer ln.Close()
 		c, err := ln.Accept()
 		if err != nil {
-			t.Fatalf("error accepting conn: %s", err)
+			t.Errorf("error accepting conn: %s", err)
+			return
 		}
 		defer c.Close()
 
 		_, err = c.Write([]byte(fmt.Sprintf("RFB %s\n", version)))
 		if err != nil {
-			t.Fatal("failed writing version")
+			t.Errorf("failed writing version: %s", err)
+			return
+		}
+
+		if script != nil {
+			if err := script(c); err != nil {
+				t.Errorf("mock server: %s", err)
+			}
 		}
 	}()
 
 	return ln.Addr().String()
 }
 
-func TestClient_LowMajorVersion(t *testing.T) {
-	nc, err := net.Dial("tcp", newMockServer(t, "002.009"))
+func dialMockServer(t *testing.T, version string, cfg *ClientConfig, script func(c net.Conn) error) (*ClientConn, error) {
+	nc, err := net.Dial("tcp", newMockServer(t, version, script))
 	if err != nil {
 		t.Fatalf("error connecting to mock server: %s", err)
 	}
 
-	_, err = Client(nc, &ClientConfig{})
+	conn, err, _ := Client(nc, cfg)
+	return conn, err
+}
+
+// expectVersion reads the client's ProtocolVersion reply.
+func expectVersion(c net.Conn, expected string) error {
+	var reply [pvLen]byte
+	if _, err := io.ReadFull(c, reply[:]); err != nil {
+		return err
+	}
+	if string(reply[:]) != expected {
+		return fmt.Errorf("client replied with version %q, expected %q", reply, expected)
+	}
+	return nil
+}
+
+// expectSecurityType reads the security type chosen by the client.
+func expectSecurityType(c net.Conn, expected uint8) error {
+	var chosen [1]byte
+	if _, err := io.ReadFull(c, chosen[:]); err != nil {
+		return err
+	}
+	if chosen[0] != expected {
+		return fmt.Errorf("client chose security type %d, expected %d", chosen[0], expected)
+	}
+	return nil
+}
+
+// vncAuthChallenge plays the server side of VNC authentication.
+func vncAuthChallenge(c net.Conn) error {
+	if _, err := c.Write(make([]byte, 16)); err != nil {
+		return err
+	}
+	var response [16]byte
+	_, err := io.ReadFull(c, response[:])
+	return err
+}
+
+func writeU32(c net.Conn, v uint32) error {
+	return binary.Write(c, binary.BigEndian, v)
+}
+
+func writeReason(c net.Conn, reason string) error {
+	if err := writeU32(c, uint32(len(reason))); err != nil {
+		return err
+	}
+	_, err := c.Write([]byte(reason))
+	return err
+}
+
+// serverInit reads ClientInit and replies with a small ServerInit.
+func serverInit(c net.Conn) error {
+	var shared [1]byte
+	if _, err := io.ReadFull(c, shared[:]); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(640))
+	binary.Write(&buf, binary.BigEndian, uint16(480))
+	pf, err := WritePixelFormat(&PixelFormat{
+		BPP:        32,
+		Depth:      24,
+		TrueColor:  true,
+		RedMax:     255,
+		GreenMax:   255,
+		BlueMax:    255,
+		RedShift:   16,
+		GreenShift: 8,
+	})
+	if err != nil {
+		return err
+	}
+	buf.Write(pf)
+	binary.Write(&buf, binary.BigEndian, uint32(len("mock")))
+	buf.WriteString("mock")
+
+	_, err = c.Write(buf.Bytes())
+	return err
+}
+
+func TestClient_LowMajorVersion(t *testing.T) {
+	_, err := dialMockServer(t, "002.009", &ClientConfig{}, nil)
 	if err == nil {
 		t.Fatal("error expected")
 	}
@@ -46,21 +146,316 @@ func TestClient_LowMajorVersion(t *testing.T) {
 }
 
 func TestClient_LowMinorVersion(t *testing.T) {
-	nc, err := net.Dial("tcp", newMockServer(t, "003.007"))
+	_, err := dialMockServer(t, "003.002", &ClientConfig{}, nil)
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if err.Error() != "unsupported minor version, less than 3: 2" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_Version3None(t *testing.T) {
+	conn, err := dialMockServer(t, "003.003", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.003\n"); err != nil {
+			return err
+		}
+		// No SecurityResult follows the None security type.
+		if err := writeU32(c, 1); err != nil {
+			return err
+		}
+		return serverInit(c)
+	})
 	if err != nil {
-		t.Fatalf("error connecting to mock server: %s", err)
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if conn.DesktopName != "mock" || conn.FramebufferWidth != 640 || conn.FramebufferHeight != 480 {
+		t.Fatalf("unexpected ServerInit: name=%q width=%d height=%d", conn.DesktopName, conn.FramebufferWidth, conn.FramebufferHeight)
+	}
+}
+
+func TestClient_Version3VNCAuth(t *testing.T) {
+	cfg := &ClientConfig{Auth: []ClientAuth{&PasswordAuth{Password: "openai"}}}
+	conn, err := dialMockServer(t, "003.003", cfg, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.003\n"); err != nil {
+			return err
+		}
+		if err := writeU32(c, 2); err != nil {
+			return err
+		}
+		if err := vncAuthChallenge(c); err != nil {
+			return err
+		}
+		if err := writeU32(c, 0); err != nil {
+			return err
+		}
+		return serverInit(c)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestClient_Version3Rejected(t *testing.T) {
+	_, err := dialMockServer(t, "003.003", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.003\n"); err != nil {
+			return err
+		}
+		if err := writeU32(c, 0); err != nil {
+			return err
+		}
+		return writeReason(c, "too many connections")
+	})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if !strings.Contains(err.Error(), "too many connections") {
+		t.Fatalf("failure reason missing from error: %s", err)
+	}
+}
+
+func TestClient_Version3AuthFailed(t *testing.T) {
+	cfg := &ClientConfig{Auth: []ClientAuth{&PasswordAuth{Password: "wrong"}}}
+	_, err := dialMockServer(t, "003.003", cfg, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.003\n"); err != nil {
+			return err
+		}
+		if err := writeU32(c, 2); err != nil {
+			return err
+		}
+		if err := vncAuthChallenge(c); err != nil {
+			return err
+		}
+		// Before 3.8 no reason string follows, so the client must
+		// not block waiting for one.
+		return writeU32(c, 1)
+	})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if err.Error() != "security handshake failed: no reason given" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_Version3Unsupported(t *testing.T) {
+	_, err := dialMockServer(t, "003.003", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.003\n"); err != nil {
+			return err
+		}
+		return writeU32(c, 2)
+	})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if err.Error() != "did not support server-requested auth scheme: 0x2" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_Version7None(t *testing.T) {
+	conn, err := dialMockServer(t, "003.007", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.007\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{2, 2, 1}); err != nil {
+			return err
+		}
+		if err := expectSecurityType(c, 1); err != nil {
+			return err
+		}
+		// No SecurityResult follows the None security type.
+		return serverInit(c)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if conn.DesktopName != "mock" {
+		t.Fatalf("unexpected desktop name: %q", conn.DesktopName)
+	}
+}
+
+func TestClient_Version7VNCAuth(t *testing.T) {
+	cfg := &ClientConfig{Auth: []ClientAuth{&PasswordAuth{Password: "openai"}}}
+	conn, err := dialMockServer(t, "003.007", cfg, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.007\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{1, 2}); err != nil {
+			return err
+		}
+		if err := expectSecurityType(c, 2); err != nil {
+			return err
+		}
+		if err := vncAuthChallenge(c); err != nil {
+			return err
+		}
+		if err := writeU32(c, 0); err != nil {
+			return err
+		}
+		return serverInit(c)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
 	}
+	conn.Close()
+}
 
-	_, err = Client(nc, &ClientConfig{})
+func TestClient_Version7NoSecurityTypes(t *testing.T) {
+	_, err := dialMockServer(t, "003.007", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.007\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{0}); err != nil {
+			return err
+		}
+		return writeReason(c, "go away")
+	})
 	if err == nil {
 		t.Fatal("error expected")
 	}
 
-	if err.Error() != "unsupported minor version, less than 8: 7" {
+	if err.Error() != "no security types: go away" {
 		t.Fatalf("unexpected error: %s", err)
 	}
 }
 
+func TestClient_Version7AuthFailed(t *testing.T) {
+	cfg := &ClientConfig{Auth: []ClientAuth{&PasswordAuth{Password: "wrong"}}}
+	_, err := dialMockServer(t, "003.007", cfg, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.007\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{1, 2}); err != nil {
+			return err
+		}
+		if err := expectSecurityType(c, 2); err != nil {
+			return err
+		}
+		if err := vncAuthChallenge(c); err != nil {
+			return err
+		}
+		return writeU32(c, 1)
+	})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if err.Error() != "security handshake failed: no reason given" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_Version8None(t *testing.T) {
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.008\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{1, 1}); err != nil {
+			return err
+		}
+		if err := expectSecurityType(c, 1); err != nil {
+			return err
+		}
+		// 3.8 sends a SecurityResult even for None.
+		if err := writeU32(c, 0); err != nil {
+			return err
+		}
+		return serverInit(c)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestClient_Version8AuthFailed(t *testing.T) {
+	cfg := &ClientConfig{Auth: []ClientAuth{&PasswordAuth{Password: "wrong"}}}
+	_, err := dialMockServer(t, "003.008", cfg, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.008\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{1, 2}); err != nil {
+			return err
+		}
+		if err := expectSecurityType(c, 2); err != nil {
+			return err
+		}
+		if err := vncAuthChallenge(c); err != nil {
+			return err
+		}
+		if err := writeU32(c, 1); err != nil {
+			return err
+		}
+		return writeReason(c, "bad password")
+	})
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	if err.Error() != "security handshake failed: bad password" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func TestClient_NegotiatedVersions(t *testing.T) {
+	tests := []struct {
+		advertised string
+		reply      string
+	}{
+		{"003.003", "RFB 003.003\n"},
+		{"003.005", "RFB 003.003\n"}, // Not a published version; treated as 3.3
+		{"003.006", "RFB 003.003\n"}, // UltraVNC
+		{"003.007", "RFB 003.007\n"},
+		{"003.008", "RFB 003.008\n"},
+		{"003.889", "RFB 003.008\n"}, // Apple Remote Desktop
+		{"004.000", "RFB 003.008\n"}, // RealVNC 4.x
+		{"004.001", "RFB 003.008\n"}, // RealVNC 4.x
+		{"005.000", "RFB 003.008\n"}, // RealVNC 5.x
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		conn, err := dialMockServer(t, tt.advertised, &ClientConfig{}, func(c net.Conn) error {
+			if err := expectVersion(c, tt.reply); err != nil {
+				return err
+			}
+			if tt.reply == "RFB 003.003\n" {
+				if err := writeU32(c, 1); err != nil {
+					return err
+				}
+			} else {
+				if _, err := c.Write([]byte{1, 1}); err != nil {
+					return err
+				}
+				if err := expectSecurityType(c, 1); err != nil {
+					return err
+				}
+				if tt.reply == "RFB 003.008\n" {
+					if err := writeU32(c, 0); err != nil {
+						return err
+					}
+				}
+			}
+			return serverInit(c)
+		})
+		if err != nil {
+			t.Errorf("server version %s: unexpected error: %s", tt.advertised, err)
+			continue
+		}
+		conn.Close()
+	}
+}
+
 func TestParseProtocolVersion(t *testing.T) {
 	tests := []struct {
 		proto        []byte
@@ -70,7 +465,8 @@ func TestParseProtocolVersion(t *testing.T) {
 		// Valid ProtocolVersion messages.
 		{[]byte{82, 70, 66, 32, 48, 48, 51, 46, 48, 48, 56, 10}, 3, 8, false},   // RFB 003.008\n
 		{[]byte{82, 70, 66, 32, 48, 48, 51, 46, 56, 56, 57, 10}, 3, 889, false}, // RFB 003.889\n -- OS X 10.10.3
-		{[]byte{82, 70, 66, 32, 48, 48, 48, 46, 48, 48, 48, 10}, 0, 0, false},   // RFB 000.0000\n
+		{[]byte{82, 70, 66, 32, 48, 48, 52, 46, 48, 48, 49, 10}, 4, 1, false},   // RFB 004.001\n -- RealVNC
+		{[]byte{82, 70, 66, 32, 48, 48, 48, 46, 48, 48, 48, 10}, 0, 0, false},   // RFB 000.000\n
 		// Invalid messages.
 		{[]byte{82, 70, 66, 32, 51, 46, 56, 10}, 0, 0, true}, // RFB 3.8\n -- too short; not zero padded
 		{[]byte{82, 70, 66, 10}, 0, 0, true},                 // RFB\n -- too short
@@ -78,18 +474,211 @@ func TestParseProtocolVersion(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		major, minor, err := parseProtocolVersion(tt.proto)
+		major, minor, err := ParseProtocolVersion(tt.proto)
 		if err != nil && !tt.isErr {
-			t.Fatalf("parseProtocolVersion(%v) unexpected error %v", tt.proto, err)
+			t.Fatalf("ParseProtocolVersion(%v) unexpected error %v", tt.proto, err)
 		}
 		if err == nil && tt.isErr {
-			t.Fatalf("parseProtocolVersion(%v) expected error", tt.proto)
+			t.Fatalf("ParseProtocolVersion(%v) expected error", tt.proto)
 		}
 		if major != tt.major {
-			t.Errorf("parseProtocolVersion(%v) major = %v, want %v", tt.proto, major, tt.major)
+			t.Errorf("ParseProtocolVersion(%v) major = %v, want %v", tt.proto, major, tt.major)
 		}
-		if major != tt.major {
-			t.Errorf("parseProtocolVersion(%v) minor = %v, want %v", tt.proto, minor, tt.minor)
+		if minor != tt.minor {
+			t.Errorf("ParseProtocolVersion(%v) minor = %v, want %v", tt.proto, minor, tt.minor)
+		}
+	}
+}
+
+// handshake38 plays the server side of an RFB 3.8 handshake with no
+// authentication.
+func handshake38(c net.Conn) error {
+	if err := expectVersion(c, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	if _, err := c.Write([]byte{1, 1}); err != nil {
+		return err
+	}
+	if err := expectSecurityType(c, 1); err != nil {
+		return err
+	}
+	if err := writeU32(c, 0); err != nil {
+		return err
+	}
+	return serverInit(c)
+}
+
+// ringBells completes the handshake and then sends Bell messages
+// until the client goes away.
+func ringBells(c net.Conn) error {
+	if err := handshake38(c); err != nil {
+		return err
+	}
+	go io.Copy(ioutil.Discard, c)
+	for {
+		if _, err := c.Write([]byte{2}); err != nil {
+			return nil
 		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func waitDone(t *testing.T, conn *ClientConn) {
+	select {
+	case <-conn.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("reader goroutine did not exit")
+	}
+}
+
+func TestClientConn_CloseUnblocksReader(t *testing.T) {
+	// Nobody reads this, so the reader blocks on the first Bell
+	messages := make(chan ServerMessage)
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{ServerMessageCh: messages}, ringBells)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if conn.Err() != nil {
+		t.Fatalf("unexpected error before Close: %s", conn.Err())
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		conn.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+
+	waitDone(t, conn)
+	if conn.Err() != ErrClosed {
+		t.Errorf("Err() = %v, want ErrClosed", conn.Err())
+	}
+	// Closing again is harmless
+	conn.Close()
+}
+
+func TestClientConn_ServerDisconnect(t *testing.T) {
+	// Unbuffered and never read: reporting the error mustn't block
+	errorCh := make(chan error)
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{ErrorCh: errorCh}, handshake38)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	waitDone(t, conn)
+	if conn.Err() == nil || conn.Err() == ErrClosed {
+		t.Errorf("Err() = %v, want the read error", conn.Err())
+	}
+}
+
+func TestClientWithContext_Cancel(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	addr := newMockServer(t, "003.008", func(c net.Conn) error {
+		// Never answer the client's version
+		<-hold
+		return nil
+	})
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("error connecting to mock server: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err, soft := ClientWithContext(ctx, nc, &ClientConfig{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if soft {
+		t.Error("cancellation should not be a soft error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handshake took %s to abort", elapsed)
+	}
+}
+
+func TestClientConn_NoGoroutineLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 20; i++ {
+		messages := make(chan ServerMessage)
+		conn, err := dialMockServer(t, "003.008", &ClientConfig{ServerMessageCh: messages, ErrorCh: make(chan error)}, ringBells)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		conn.Close()
+	}
+
+	// The mock servers notice the client is gone asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines before, %d after:\n%s", before, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientConn_ReadTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{ReadTimeout: 50 * time.Millisecond}, func(c net.Conn) error {
+		if err := handshake38(c); err != nil {
+			return err
+		}
+		// Start a FramebufferUpdate, and then stall
+		if _, err := c.Write([]byte{0, 0}); err != nil {
+			return err
+		}
+		<-hold
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	waitDone(t, conn)
+	if err := conn.Err(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Err() = %v, want a timeout", err)
+	}
+}
+
+func TestClientConn_WriteTimeout(t *testing.T) {
+	// net.Pipe is unbuffered, so a write blocks until the server
+	// reads it, which it never does.
+	client, server := net.Pipe()
+	hold := make(chan struct{})
+	defer close(hold)
+	go func() {
+		defer server.Close()
+		server.Write([]byte("RFB 003.008\n"))
+		if err := handshake38(server); err != nil {
+			t.Errorf("mock server: %s", err)
+			return
+		}
+		<-hold
+	}()
+
+	conn, err, _ := Client(client, &ClientConfig{WriteTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	err = conn.KeyEvent(0x61, true)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("KeyEvent() = %v, want a timeout", err)
 	}
 }
@@ -1,15 +1,13 @@
 package vncclient
 
 import (
+	"bufio"
 	"bytes"
-	"compress/zlib"
-	"encoding/binary"
-	"fmt"
 	"io"
 
 	"github.com/juju/errors"
 	logging "github.com/op/go-logging"
-	"github.com/pixiv/go-libjpeg/jpeg"
+	"github.com/openai/go-vncdriver/flexzlib"
 )
 
 var log = logging.MustGetLogger("vncclient")
@@ -90,10 +88,14 @@ func (s SubsampleLevel) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 // See RFC 6143 Section 7.7.1
 type RawEncoding struct {
 	Colors []Color
+	size   int
 }
 
 func (r *RawEncoding) Size() int {
-	return len(r.Colors) * 3
+	if r.size == 0 {
+		return len(r.Colors) * 3
+	}
+	return r.size
 }
 
 func (*RawEncoding) Type() int32 {
@@ -101,53 +103,32 @@ func (*RawEncoding) Type() int32 {
 }
 
 func (*RawEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
-	bytesPerPixel := c.PixelFormat.BPP / 8
-
-	// Various housekeeping helpers
-	pixelBytes := make([]uint8, bytesPerPixel)
-	var byteOrder binary.ByteOrder = binary.LittleEndian
-	if c.PixelFormat.BigEndian {
-		byteOrder = binary.BigEndian
+	p, err := c.converter()
+	if err != nil {
+		return nil, err
 	}
 
-	// Output buffer
-	colors := make([]Color, rect.Area())
-
 	// Read all needed bytes: this improves performance so we
 	// don't have to do piecemeal unbuffered reads.
-	buf := make([]byte, rect.Area()*int(bytesPerPixel))
+	rowBytes := int(rect.Width) * p.size
+	buf := getBytes(int(rect.Height) * rowBytes)
+	defer putBytes(buf)
 	if _, err := io.ReadFull(r, buf); err != nil {
 		return nil, err
 	}
-	r = bytes.NewBuffer(buf)
 
-	for y := uint16(0); y < rect.Height; y++ {
-		for x := uint16(0); x < rect.Width; x++ {
-			if _, err := io.ReadFull(r, pixelBytes); err != nil {
-				return nil, err
-			}
-
-			var rawPixel uint32
-			if c.PixelFormat.BPP == 8 {
-				rawPixel = uint32(pixelBytes[0])
-			} else if c.PixelFormat.BPP == 16 {
-				rawPixel = uint32(byteOrder.Uint16(pixelBytes))
-			} else if c.PixelFormat.BPP == 32 {
-				rawPixel = byteOrder.Uint32(pixelBytes)
-			}
+	fb, colors := c.framebuffer(rect)
+	pix, stride := fb.Lock(rect)
+	defer fb.Unlock(rect)
 
-			color := &colors[int(y)*int(rect.Width)+int(x)]
-			if c.PixelFormat.TrueColor {
-				color.R = uint8((rawPixel >> c.PixelFormat.RedShift) & uint32(c.PixelFormat.RedMax))
-				color.G = uint8((rawPixel >> c.PixelFormat.GreenShift) & uint32(c.PixelFormat.GreenMax))
-				color.B = uint8((rawPixel >> c.PixelFormat.BlueShift) & uint32(c.PixelFormat.BlueMax))
-			} else {
-				*color = c.ColorMap[rawPixel]
-			}
+	width := int(rect.Width)
+	for y := 0; y < int(rect.Height); y++ {
+		if err := p.pixels(pix[y*stride:y*stride+width], buf[y*rowBytes:]); err != nil {
+			return nil, err
 		}
 	}
 
-	return &RawEncoding{colors}, nil
+	return &RawEncoding{Colors: colors, size: rect.Area() * 3}, nil
 }
 
 // ZRLEEncoding is Zlib run-length encoded pixel data
@@ -167,37 +148,58 @@ func (z *ZRLEEncoding) Size() int {
 }
 
 func (z *ZRLEEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
-	var length int32
-	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+	u, err := readU32(r)
+	if err != nil {
 		return nil, err
 	}
+	length := int32(u)
+	limits := c.limits()
+	if length < 0 {
+		return nil, errors.Errorf("negative zlib data length: %d", length)
+	} else if int(length) > limits.MaxCompressedBytes {
+		return nil, errors.Errorf("%d bytes of zlib data exceeds limit of %d", length, limits.MaxCompressedBytes)
+	}
 
-	// Could maybe get by without the copy
-	compressed := make([]uint8, length)
-	if err := binary.Read(r, binary.BigEndian, &compressed); err != nil {
+	compressed, err := c.readCompressed(r, int(length))
+	defer releaseBuffer(&c.compressed)
+	if err != nil {
 		return nil, err
 	}
-
-	inflated, err := c.inflator.Inflate(compressed)
+	p, err := c.converter()
 	if err != nil {
-		return nil, errors.Annotate(err, "could not inflate")
+		return nil, err
 	}
 
+	// Each tile inflates to at most a palette of 127 CPIXELs plus, per
+	// pixel, a CPIXEL and a run length.
+	tiles := ((int(rect.Width) + 63) / 64) * ((int(rect.Height) + 63) / 64)
+	max := (p.cpixel+1)*int(rect.Width)*int(rect.Height) + tiles*(1+127*p.cpixel)
+	if max > limits.MaxDecompressedBytes {
+		max = limits.MaxDecompressedBytes
+	}
+	c.zrleBuf.Reset()
+	defer releaseBuffer(&c.zrleBuf)
+	if err := c.inflator.InflateBuffer(&c.zrleBuf, compressed, max); err != nil {
+		return nil, newDecodeError(z.Type(), rect, errors.Annotate(err, "could not inflate"))
+	}
+	inflated := c.zrleBuf.Bytes()
+
 	// It's now safe to start reading other ZRLE messages if desired
 	log.Debugf("expanded zlib: %d bytes -> %d bytes", len(compressed), len(inflated))
 
-	// TODO: other format checks here
-	if c.PixelFormat.BPP < 24 {
-		return nil, errors.Errorf("unsupported bitsPerPixel: %d", c.PixelFormat.BPP)
-	}
-
 	// data := base64.StdEncoding.EncodeToString(inflated)
 	// log.Infof("payload %v %v %v %v: %v", rect.X, rect.Y, rect.Width, rect.Height, data)
 
+	fb, colors := c.framebuffer(rect)
+	pix, stride := fb.Lock(rect)
+	defer fb.Unlock(rect)
+
 	buf := NewQuickBuf(inflated)
-	colors, err := z.parse(rect, buf)
-	if err != nil {
-		return nil, errors.Annotatef(err, "could not parse ZRLEEncoding colors")
+	if err := z.parse(p, rect, buf, pix, stride); err != nil {
+		// The inflated data is all in memory, so even running out
+		// of it means the rectangle was malformed, rather than that
+		// the connection was lost.
+		return nil, newDecodeError(z.Type(), rect, errors.Annotatef(err, "could not parse ZRLEEncoding colors"))
 	}
 
 	if buf.Len() != 0 {
@@ -208,30 +210,30 @@ func (z *ZRLEEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encodi
 	return &ZRLEEncoding{colors, length}, nil
 }
 
-func (z *ZRLEEncoding) parse(rect *Rectangle, r *QuickBuf) ([]Color, error) {
-	colors := make([]Color, rect.Area())
-
+// parse paints the tiles of rect into pix, which has rows of stride,
+// converting their CPIXELs with p.
+func (z *ZRLEEncoding) parse(p *pixelConverter, rect *Rectangle, r *QuickBuf, pix []Color, stride int) error {
 	// We pass in a scratch buffer so that parseTile doesn't need
-	// to allocate its own. A better implementation would probably
-	// write directly into the colors buffer.
-	scratch := make([]Color, 64*64)
+	// to allocate its own.
+	scratch := getColors(64 * 64)
+	defer putColors(scratch)
 
 	for tileY := uint16(0); tileY < rect.Height; tileY += 64 {
 		tileHeight := min(64, rect.Height-tileY)
 		for tileX := uint16(0); tileX < rect.Width; tileX += 64 {
 			tileWidth := min(64, rect.Width-tileX)
 
-			err := z.parseTile(rect, colors, r, tileX, tileY, tileWidth, tileHeight, scratch[:int(tileHeight)*int(tileWidth)])
+			err := z.parseTile(p, pix, stride, r, tileX, tileY, tileWidth, tileHeight, scratch[:int(tileHeight)*int(tileWidth)])
 			if err != nil {
-				return nil, err
+				return err
 			}
 		}
 	}
 
-	return colors, nil
+	return nil
 }
 
-func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, tileX, tileY, tileWidth, tileHeight uint16, scratch []Color) error {
+func (*ZRLEEncoding) parseTile(p *pixelConverter, pix []Color, stride int, r *QuickBuf, tileX, tileY, tileWidth, tileHeight uint16, scratch []Color) error {
 	// Each tile begins with a subencoding type byte.  The top bit of this
 	// byte is set if the tile has been run-length encoded, clear otherwise.
 	// The bottom 7 bits indicate the size of the palette used: zero means
@@ -247,8 +249,9 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 	runLengthEncoded := subencoding&128 != 0
 	paletteSize := subencoding & 127
 
-	paletteData, err := r.ReadColors(int(paletteSize))
-	if err != nil {
+	var palette [127]Color
+	paletteData := palette[:paletteSize]
+	if err := readCPixels(r, p, paletteData); err != nil {
 		return errors.Annotatef(err, "failed to read palette: runLengthEncoded:%v paletteSize:%v", runLengthEncoded, paletteSize)
 	}
 
@@ -262,12 +265,9 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 		//  | width*height*BytesPerCPixel | CPIXEL array | pixels      |
 		//  +-----------------------------+--------------+-------------+
 
-		colors, err := r.ReadColors(len(scratch))
-		if err != nil {
+		if err := readCPixels(r, p, scratch); err != nil {
 			return errors.Annotate(err, "failed to read raw colors")
 		}
-		// Don't bother with the scratch buffer
-		scratch = colors
 	} else if paletteSize == 1 && !runLengthEncoded {
 		// 1: A solid tile consisting of a single color.  The pixel value
 		// follows:
@@ -331,6 +331,9 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 				}
 				nbits -= bitsPerPackedPixel
 				paletteIdx := (b >> nbits) & ((1 << bitsPerPackedPixel) - 1) & 127
+				if paletteIdx >= paletteSize {
+					return errors.Errorf("invalid index %d in palette of size %d", paletteIdx, paletteSize)
+				}
 				pixelValue := paletteData[paletteIdx]
 				scratch[j*tileWidth+i] = pixelValue
 			}
@@ -356,9 +359,9 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 		// +-------------------------+--------------+-----------------------+
 
 		for pos := 0; pos < len(scratch); {
-			pixelValue, err := r.ReadColor()
+			pixelValue, err := readCPixel(r, p)
 			if err != nil {
-				return err
+				return errors.Annotate(err, "failed to read rle color")
 			}
 
 			count := 1
@@ -369,6 +372,9 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 				}
 				count += int(b)
 			}
+			if count > len(scratch)-pos {
+				return errors.Errorf("run of %d pixels overflows tile with %d pixels left", count, len(scratch)-pos)
+			}
 
 			fillColor2(scratch[pos:pos+count], pixelValue)
 			pos += count
@@ -420,8 +426,14 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 					count += int(b)
 				}
 			}
+			if count > len(scratch)-pos {
+				return errors.Errorf("run of %d pixels overflows tile with %d pixels left", count, len(scratch)-pos)
+			}
 
 			paletteIdx &= 127
+			if paletteIdx >= paletteSize {
+				return errors.Errorf("invalid index %d in palette of size %d", paletteIdx, paletteSize)
+			}
 			pixelValue := paletteData[paletteIdx]
 			fillColor(scratch[pos:pos+count], pixelValue)
 			pos += count
@@ -430,11 +442,8 @@ func (*ZRLEEncoding) parseTile(rect *Rectangle, colors []Color, r *QuickBuf, til
 		return errors.Errorf("Unhandled case: runLengthEncoded=%v paletteSize=%v", runLengthEncoded, paletteSize)
 	}
 
-	for j := 0; j < int(tileHeight); j++ {
-		off := int(tileY)*int(rect.Width) + int(tileX)
-		start := j*int(rect.Width) + off
-		copy(colors[start:start+int(tileWidth)], scratch[j*int(tileWidth):])
-	}
+	off := int(tileY)*stride + int(tileX)
+	copyRows(pix[off:], stride, scratch, int(tileWidth), int(tileHeight))
 
 	return nil
 }
@@ -446,6 +455,25 @@ func min(x, y uint16) uint16 {
 	return x
 }
 
+// readCPixels reads CPIXELs from r into dst.
+func readCPixels(r *QuickBuf, p *pixelConverter, dst []Color) error {
+	b, err := r.Next(p.cpixel * len(dst))
+	if err != nil {
+		return err
+	}
+	return p.cpixels(dst, b)
+}
+
+// readCPixel reads a single CPIXEL from r.
+func readCPixel(r *QuickBuf, p *pixelConverter) (Color, error) {
+	if p.rgbCPixels {
+		return r.ReadColor()
+	}
+	var c [1]Color
+	err := readCPixels(r, p, c[:])
+	return c[0], err
+}
+
 func fillColor(dst []Color, pixelValue Color) {
 	dst[0] = pixelValue
 	for bp := 1; bp < len(dst); {
@@ -460,11 +488,6 @@ func fillColor2(dst []Color, pixelValue Color) {
 	}
 }
 
-type readCloseResetter interface {
-	io.ReadCloser
-	zlib.Resetter
-}
-
 // Superceded by the Fine Quality Level / Compress Level options
 type JPEGQuality uint8
 
@@ -487,21 +510,12 @@ func (j JPEGQuality) Read(*ClientConn, *Rectangle, io.Reader) (Encoding, error)
 type TightEncoding struct {
 	Colors []Color
 
-	streams [4]readCloseResetter
-	// streamBufs holds the underlying io.Readers for the zlib streams.
-	// We can't share the same underlying io.Reader between the streams
-	// because zlib does not always read all available data in a given frame.
-	//
-	// TODO: could skip the bytes.Buffer and read directly from Readers
-	// here. But see zlib note:
-	//
-	//    If r does not implement io.ByteReader, the decompressor may read
-	//    more data than necessary from r.
-	//
-	// Reading more data than necessary might cause it to encounter an
-	// unwanted EOF, while providing an unbuffered ByteReader
-	// implementationa might be slower than using bytes.Buffer anyway.
-	streamBufs [4]*bytes.Buffer
+	// streams are the four zlib streams, created on first use. Each
+	// rectangle's compressed data is swapped in under its stream, which
+	// carries on from where the last rectangle on it left off.
+	streams [4]*flexzlib.Reader
+	// compressed reads the compressed data of the current rectangle.
+	compressed bytes.Reader
 
 	// reset is a bitmap that represents which zlib streams need
 	// to be reset before their next use.
@@ -524,9 +538,6 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 	t.size = 0
 	if t.buf == nil {
 		t.buf = bytes.NewBuffer(nil)
-		for i := range t.streamBufs {
-			t.streamBufs[i] = bytes.NewBuffer(nil)
-		}
 	}
 	// To reduce implementation complexity, the width of any Tight-encoded
 	// rectangle cannot exceed 2048 pixels. If a wider rectangle is
@@ -536,10 +547,9 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 		return nil, errors.Errorf("rectangle too wide: %vpx. tight-encoded rectangles cannot be wider than 2048 pixels.", rect.Width)
 	}
 
-	// To simplify implementation at the cost of full spec compliance,
-	// we only accept the simplest case of pixel format.
-	if f := c.PixelFormat; f.BPP != 32 || f.Depth != 24 || !f.TrueColor || f.RedMax != 255 || f.GreenMax != 255 || f.BlueMax != 255 {
-		return nil, errors.Errorf("this implementation of Tight encoding does not support this pixel format: %#v", f)
+	p, err := c.converter()
+	if err != nil {
+		return nil, err
 	}
 
 	// The first byte of each Tight-encoded rectangle is a compression-
@@ -550,8 +560,8 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 	//  +---------------------+--------------+---------------------+
 	//  | 1                   | U8           | compression-control |
 	//  +---------------------+--------------+---------------------+
-	var compressionControl uint8
-	if err := binary.Read(r, binary.BigEndian, &compressionControl); err != nil {
+	compressionControl, err := readU8(r)
+	if err != nil {
 		return nil, err
 	}
 	t.size++
@@ -603,7 +613,7 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 		readFilterID := compressionControl>>6 == 1
 		stream := compressionControl >> 4 & 0x03
 		log.Debugf("BasicCompression")
-		return t.readBasicCompression(c, rect, r, readFilterID, stream)
+		return t.readBasicCompression(c, p, rect, r, readFilterID, stream)
 	}
 
 	// Otherwise, if the bit 7 of compression-control is set to 1, then the
@@ -626,15 +636,14 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 		// If the compression type is FillCompression, then the only
 		// pixel value follows, in TPIXEL format. This value applies to
 		// all pixels of the rectangle.
-		t.buf.Reset()
-		fill, err := t.readTPixels(r, 1)
-		if err != nil {
+		var fill [1]Color
+		if err := t.readTPixels(r, p, fill[:]); err != nil {
 			return nil, err
 		}
-		colors := make([]Color, rect.Area())
-		for i := range colors {
-			colors[i] = fill[0]
-		}
+		fb, colors := c.framebuffer(rect)
+		pix, stride := fb.Lock(rect)
+		defer fb.Unlock(rect)
+		fillRows(pix, stride, fill[0], int(rect.Width), int(rect.Height))
 		return &TightEncoding{Colors: colors, size: t.size}, nil
 	// JpegCompression
 	case 9:
@@ -651,31 +660,44 @@ func (t *TightEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encod
 		//  +--------------+----------+----------------------------------+
 		//
 		// The jpeg-data is a JFIF stream.
-		length, err := t.readCompactLength(byteIOReader{Reader: r})
+		length, err := t.readCompactLength(byteReader(r))
 		if err != nil {
 			return nil, err
 		}
-		buf := io.LimitReader(r, int64(length))
-		img, err := jpeg.DecodeIntoRGB(buf, &jpeg.DecoderOptions{})
+		if max := c.limits().MaxCompressedBytes; length > max {
+			return nil, errors.Errorf("%d bytes of jpeg data exceeds limit of %d", length, max)
+		}
+		// The data outlives the reader's buffers if a worker decodes
+		// it, so it goes in one from the pool
+		data, err := readPooledBytes(r, length)
 		if err != nil {
-			return nil, errors.Annotate(err, "could not decode jpeg")
-		} else if img == nil {
-			return nil, errors.New("jpeg decoding returned nil (usually a result of the network being closed)")
+			return nil, err
 		}
 		t.size += length
 
-		qbuf := NewQuickBuf(img.Pix)
-		colors, err := qbuf.ReadColors(rect.Area())
+		// The rest of the update needn't wait for the JPEG to be
+		// decoded, unless it's painted over it
+		enc := &TightEncoding{size: t.size}
+		if c.jpegs.add(c, rect, enc, data) {
+			return enc, nil
+		}
+		defer putBytes(data)
+		decoded, err := decodeJPEG(rect, data)
 		if err != nil {
 			return nil, err
 		}
-		return &TightEncoding{Colors: colors, size: t.size}, nil
+		fb, colors := c.framebuffer(rect)
+		pix, stride := fb.Lock(rect)
+		defer fb.Unlock(rect)
+		copyRows(pix, stride, decoded, int(rect.Width), int(rect.Height))
+		enc.Colors = colors
+		return enc, nil
 	default:
 		return nil, errors.Errorf("invalid compression control byte: %b", compressionControl)
 	}
 }
 
-func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r io.Reader, readFilterID bool, stream uint8) (enc Encoding, e error) {
+func (t *TightEncoding) readBasicCompression(c *ClientConn, p *pixelConverter, rect *Rectangle, r io.Reader, readFilterID bool, stream uint8) (enc Encoding, e error) {
 	var filterID uint8
 	if readFilterID {
 		// If the compression type is BasicCompression and bit 6 (the
@@ -683,7 +705,8 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 		// to 1, then the next (second) byte specifies filter-id which
 		// tells the decoder what filter type was used by the encoder
 		// to pre-process pixel data before the compression.
-		if err := binary.Read(r, binary.BigEndian, &filterID); err != nil {
+		var err error
+		if filterID, err = readU8(r); err != nil {
 			return nil, err
 		}
 		t.size++
@@ -713,18 +736,21 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 		log.Debug("CopyFilter")
 		// When the CopyFilter is active, raw pixel values in TPIXEL
 		// format will be compressed.
-		size := rect.Area() * 3
-		r, err := t.basicCompressionReader(r, size, stream)
+		data, err := t.readFiltered(c, r, rect.Area()*p.tpixel, stream)
 		if err != nil {
 			return nil, err
 		}
-		t.buf.Reset()
-		colors, err := t.readTPixels(r, size/3)
 
-		// Copy the colors slice. It uses the same underlying memory as
-		// t.buf, but when it is used to update a screen later we might
-		// have already modified t.buf while reading a new frame.
-		return &TightEncoding{Colors: append([]Color{}, colors...), size: t.size}, err
+		fb, colors := c.framebuffer(rect)
+		pix, stride := fb.Lock(rect)
+		defer fb.Unlock(rect)
+		width := int(rect.Width)
+		for y := 0; y < int(rect.Height); y++ {
+			if err := p.tpixels(pix[y*stride:y*stride+width], data[y*width*p.tpixel:]); err != nil {
+				return nil, err
+			}
+		}
+		return &TightEncoding{Colors: colors, size: t.size}, nil
 	// PaletteFilter
 	case 1:
 		log.Debug("PaletteFilter")
@@ -737,17 +763,15 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 		// (i.e. 1 means 2 colors, 255 means 256 colors in the palette).
 		// Then follows the palette itself which consist of pixel values
 		// in TPIXEL format.
-		var p uint8
-		if err := binary.Read(r, binary.BigEndian, &p); err != nil {
+		n, err := readU8(r)
+		if err != nil {
 			return nil, err
 		}
-		paletteSize := int(p) + 1
-		t.buf.Reset()
-		palette, err := t.readTPixels(r, paletteSize)
-		if err != nil {
+		paletteSize := int(n) + 1
+		var palette [256]Color
+		if err := t.readTPixels(r, p, palette[:paletteSize]); err != nil {
 			return nil, err
 		}
-		palette = append([]Color{}, palette...)
 
 		// If the number of colors is 2, then each pixel is encoded in
 		// 1 bit, otherwise 8 bits are used to encode one pixel. 1-bit
@@ -759,32 +783,33 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 			size = ((int(rect.Width) + 7) / 8) * int(rect.Height)
 		}
 
-		r, err := t.basicCompressionReader(r, size, stream)
+		buf, err := t.readFiltered(c, r, size, stream)
 		if err != nil {
 			return nil, err
 		}
-		if err = t.readToBuf(r, size); err != nil {
-			return nil, err
-		}
-		buf := t.buf.Bytes()
-		colors := make([]Color, rect.Area())
+		fb, colors := c.framebuffer(rect)
+		pix, stride := fb.Lock(rect)
+		defer fb.Unlock(rect)
+		width := int(rect.Width)
 		if paletteSize == 2 {
-			offset := uint8(8)
-			index := -1
-			for i := range colors {
-				if offset == 0 || i%int(rect.Width) == 0 {
-					offset = 8
-					index++
+			rowBytes := (width + 7) / 8
+			for y := 0; y < int(rect.Height); y++ {
+				row := pix[y*stride : y*stride+width]
+				bits := buf[y*rowBytes:]
+				for x := range row {
+					row[x] = palette[(bits[x/8]>>(7-uint(x%8)))&0x01]
 				}
-				offset--
-				colors[i] = palette[(buf[index]>>offset)&0x01]
 			}
 		} else {
-			for i := range colors {
-				if int(buf[i]) >= paletteSize {
-					return nil, errors.Errorf("invalid index %d in palette of size %d", buf[i], paletteSize)
+			for y := 0; y < int(rect.Height); y++ {
+				row := pix[y*stride : y*stride+width]
+				indexes := buf[y*width:]
+				for x := range row {
+					if int(indexes[x]) >= paletteSize {
+						return nil, errors.Errorf("invalid index %d in palette of size %d", indexes[x], paletteSize)
+					}
+					row[x] = palette[indexes[x]]
 				}
-				colors[i] = palette[uint8(buf[i])]
 			}
 		}
 		return &TightEncoding{Colors: colors, size: t.size}, nil
@@ -793,17 +818,11 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 		log.Debug("GradientFilter")
 		// Note: The GradientFilter may only be used when bits-per-
 		// pixel is either 16 or 32.
-		if c.PixelFormat.BPP != 16 && c.PixelFormat.BPP != 32 {
+		if c.PixelFormat.BPP != 16 && c.PixelFormat.BPP != 32 || !c.PixelFormat.TrueColor {
 			return nil, errors.Errorf("can't use GradientFilter with bitsPerPixel of %v", c.PixelFormat.BPP)
 		}
 
-		size := rect.Area() * 3
-		r, err := t.basicCompressionReader(r, size, stream)
-		if err != nil {
-			return nil, err
-		}
-		t.buf.Reset()
-		diffs, err := t.readTPixels(r, size)
+		data, err := t.readFiltered(c, r, rect.Area()*p.tpixel, stream)
 		if err != nil {
 			return nil, err
 		}
@@ -826,21 +845,34 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 		// rectangle, V[i,j] is assumed to be zero (which is relevant
 		// for P[i,0] and P[0,j]). MAX is the maximum intensity value
 		// for a color component.
-		colors := make([]Color, size/3)
-		cr := colorRect{width: int(rect.Width), colors: colors}
+		//
+		// The predictions are made from the intensities as sent, so
+		// keep those of the row above and this one.
+		fb, colors := c.framebuffer(rect)
+		pix, stride := fb.Lock(rect)
+		defer fb.Unlock(rect)
+		width := int(rect.Width)
+		max := p.maxes()
+		above, row := make([][3]uint16, width), make([][3]uint16, width)
 		for i := 0; i < int(rect.Height); i++ {
-			for j := 0; j < int(rect.Width); j++ {
-				for c := 0; c < 3; c++ {
-					p := cr.at(i-1, j, c) + cr.at(i, j-1, c) - cr.at(i-1, j-1, c)
-					if p < 0 {
-						p = 0
+			for j := range row {
+				diff := p.tintensities(data[(i*width+j)*p.tpixel:])
+				for c := range diff {
+					pred := int(above[j][c])
+					if j > 0 {
+						pred += int(row[j-1][c]) - int(above[j-1][c])
 					}
-					if p > 255 {
-						p = 255
+					if pred < 0 {
+						pred = 0
 					}
-					*component(colors[i], c) = *component(diffs[i], c) + p
+					if pred > int(max[c]) {
+						pred = int(max[c])
+					}
+					row[j][c] = (uint16(pred) + diff[c]) & max[c]
 				}
+				pix[i*stride+j] = p.scaled(row[j])
 			}
+			above, row = row, above
 		}
 		return &TightEncoding{Colors: colors, size: t.size}, nil
 	default:
@@ -849,42 +881,24 @@ func (t *TightEncoding) readBasicCompression(c *ClientConn, rect *Rectangle, r i
 
 }
 
-// colorRect simplifies gradient filter computation
-type colorRect struct {
-	width  int
-	colors []Color
-}
-
-func (r *colorRect) at(y, x, c int) uint8 {
-	if y < 0 || x < 0 {
-		return 0
-	}
-	return *component(r.colors[y*r.width+x], c)
-}
-
-func component(c Color, x int) *uint8 {
-	switch x {
-	case 0:
-		return &c.R
-	case 1:
-		return &c.G
-	case 2:
-		return &c.B
-	}
-	panic(fmt.Sprintf("bad component number: %v", component))
-}
-
-// readCompressedBytes reads compressed data from r.
-// func (t *TightEncoding) readCompressedBytes(r io.Reader, size int, stream uint8) ([]byte, error) {
+// readFiltered reads size bytes of filtered data from r, decompressing
+// them on the given stream if need be. They're in t.buf, and so only
+// valid until it's next used.
+func (t *TightEncoding) readFiltered(c *ClientConn, r io.Reader, size int, stream uint8) ([]byte, error) {
+	t.buf.Reset()
 
-// basicCompressionReader returns an io.Reader that decompresses data from r.
-func (t *TightEncoding) basicCompressionReader(r io.Reader, size int, stream uint8) (out io.Reader, err error) {
 	// After the pixel data has been filtered with one of the above three
 	// filters, it is compressed using the zlib library. But if the data
 	// size after applying the filter but before the compression is less
 	// then 12, then the data is sent as is, uncompressed.
 	if size < 12 {
-		return io.LimitReader(r, int64(size)), nil
+		data, err := next(r, size)
+		if err != nil {
+			return nil, err
+		}
+		t.size += size
+		t.buf.Write(data)
+		return t.buf.Bytes(), nil
 	}
 
 	// Four separate zlib streams (0..3) can be used and the
@@ -901,57 +915,70 @@ func (t *TightEncoding) basicCompressionReader(r io.Reader, size int, stream uin
 	//  +--------------+----------+----------------------------------+
 	//  | length       | U8 array | zlibData                         |
 	//  +--------------+----------+----------------------------------+
-	length, err := t.readCompactLength(byteIOReader{Reader: r})
+	length, err := t.readCompactLength(byteReader(r))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
-	t.size += length
-
-	buf := t.streamBufs[stream]
-	if t.reset&(1<<stream) != 0 {
-		buf.Reset()
+	limits := c.limits()
+	if length > limits.MaxCompressedBytes {
+		return nil, errors.Errorf("%d bytes of zlib data exceeds limit of %d", length, limits.MaxCompressedBytes)
+	} else if size > limits.MaxDecompressedBytes {
+		return nil, errors.Errorf("%d bytes of decompressed data exceeds limit of %d", size, limits.MaxDecompressedBytes)
 	}
-	buf.Grow(length)
-	if _, err = buf.ReadFrom(io.LimitReader(r, int64(length))); err != nil {
-		return nil, errors.Trace(err)
+	compressed, err := c.readCompressed(r, length)
+	defer releaseBuffer(&c.compressed)
+	if err != nil {
+		return nil, err
 	}
+	t.size += length
 
-	if t.streams[stream] == nil {
-		zr, err := zlib.NewReader(buf)
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
-		t.streams[stream] = zr.(readCloseResetter)
-	}
 	// NOTE1: The decoder must reset the zlib streams before
 	// decoding the rectangle, if some of the bits 0, 1, 2 and 3 in
 	// the compression-control byte are set to 1. Note that the
 	// decoder must reset the indicated zlib streams even if the
 	// compression type is FillCompression or JpegCompression.
-	if t.reset&(1<<stream) != 0 {
-		t.streams[stream].Reset(buf, nil)
-		t.reset &^= 1 << stream
+	//
+	// A stream's first rectangle starts it afresh anyway.
+	t.compressed.Reset(compressed)
+	if z := t.streams[stream]; z == nil {
+		if t.streams[stream], err = flexzlib.NewReader(&t.compressed); err != nil {
+			return nil, errors.Annotatef(err, "could not start zlib stream %d", stream)
+		}
+	} else if t.reset&(1<<stream) != 0 {
+		if err := z.Reset(&t.compressed, nil); err != nil {
+			return nil, errors.Annotatef(err, "could not reset zlib stream %d", stream)
+		}
+	} else {
+		z.SwapReader(&t.compressed)
 	}
-
-	return t.streams[stream], nil
+	t.reset &^= 1 << stream
+
+	// Inflate all of the rectangle's data, so that the stream is left
+	// ready for the next one, but no more than it should come to.
+	// t.buf grows as it inflates, rather than to the size expected.
+	_, err = t.buf.ReadFrom(io.LimitReader(t.streams[stream], int64(size)+1))
+	// Let go of compressed, which readCompressed may reuse
+	t.compressed.Reset(nil)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not inflate")
+	}
+	if n := t.buf.Len(); n > size {
+		return nil, errors.Errorf("zlib data inflated to more than the %d bytes expected", size)
+	} else if n < size {
+		return nil, errors.Errorf("zlib data inflated to %d bytes, expected %d", n, size)
+	}
+	return t.buf.Bytes(), nil
 }
 
-// readTPixels reads Colors in TPIXEL format from r. Uses t.buf as buffer space.
-//
-// NOTE: to simplify implementation, it expects the 3-byte-per-pixel version
-// of TPIXEL, which means it is not compatible with all pixel formats.
-//
-// NOTE: the returned []Color uses the same memory as t.buf, and so will
-// no longer be valid once t.buf changes.
-func (t *TightEncoding) readTPixels(r io.Reader, n int) ([]Color, error) {
-	if t.buf.Len() != 0 {
-		panic("unread bytes in t.buf before call to readTPixels")
-	}
-	if err := t.readToBuf(r, n*3); err != nil {
-		return nil, err
+// readTPixels reads TPIXELs from r into dst.
+func (t *TightEncoding) readTPixels(r io.Reader, p *pixelConverter, dst []Color) error {
+	n := p.tpixel * len(dst)
+	b, err := next(r, n)
+	if err != nil {
+		return err
 	}
-	t.size += n * 3
-	return (&QuickBuf{buf: t.buf.Next(n * 3)}).ReadColors(n)
+	t.size += n
+	return p.tpixels(dst, b)
 }
 
 func (t *TightEncoding) readCompactLength(r io.ByteReader) (int, error) {
@@ -991,10 +1018,82 @@ func (t *TightEncoding) readCompactLength(r io.ByteReader) (int, error) {
 	}
 }
 
-func (t *TightEncoding) readToBuf(r io.Reader, n int) error {
-	t.buf.Grow(n)
-	_, err := t.buf.ReadFrom(io.LimitReader(r, int64(n)))
-	return err
+// readBytes reads exactly n bytes from r. Unlike allocating n bytes up
+// front, it doesn't let a server that claims a huge length and then
+// sends nothing of the sort exhaust our memory.
+func readBytes(r io.Reader, n int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// readPooledBytes is like readBytes, but reads into a buffer from the
+// pool, which the caller should hand back with putBytes. Past what the
+// pool had, it grows a read buffer's worth at a time, as the bytes
+// arrive.
+func readPooledBytes(r io.Reader, n int) ([]byte, error) {
+	b := getBytes(0)
+	for len(b) < n {
+		chunk := n - len(b)
+		if chunk > readBufferSize {
+			chunk = readBufferSize
+		}
+		if len(b)+chunk > cap(b) {
+			size := 2 * cap(b)
+			if size < len(b)+chunk {
+				size = len(b) + chunk
+			} else if size > n {
+				size = n
+			}
+			grown := make([]byte, len(b), size)
+			copy(grown, b)
+			putBytes(b)
+			b = grown
+		}
+		m, err := io.ReadFull(r, b[len(b):len(b)+chunk])
+		b = b[:len(b)+m]
+		if err != nil {
+			putBytes(b)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// readCompressed reads n bytes of compressed data from r. Unless they
+// fit in the read buffer, they go in c.compressed, which only grows as
+// they arrive, so that a server can't make us allocate a huge length up
+// front. Either way, they're only valid until r is next read.
+func (c *ClientConn) readCompressed(r io.Reader, n int) ([]byte, error) {
+	if br, ok := r.(*bufio.Reader); ok && n <= br.Size() {
+		return next(r, n)
+	}
+	c.compressed.Reset()
+	if _, err := io.CopyN(&c.compressed, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return c.compressed.Bytes(), nil
+}
+
+// releaseBuffer empties b, letting go of its memory if it has grown
+// past maxKeptBuffer.
+func releaseBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxKeptBuffer {
+		*b = bytes.Buffer{}
+	} else {
+		b.Reset()
+	}
 }
 
 // byteIOReader implements both io.ByteReader and io.Reader
@@ -2,7 +2,9 @@ package vncclient
 
 import (
 	"bytes"
+	"compress/zlib"
 	"encoding/base64"
+	"reflect"
 	"testing"
 )
 
@@ -30,7 +32,243 @@ func TestTightEncodingCompactLength(t *testing.T) {
 	}
 }
 
+func TestTightEncodingGradientFilter(t *testing.T) {
+	c := &ClientConn{PixelFormat: PixelFormat{BPP: 32, Depth: 24, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255}}
+	// A column of gray pixels of intensity 10, 30 and 5, each sent as
+	// its difference from the one above
+	data := []byte{0x40, 2, 10, 10, 10, 20, 20, 20, 231, 231, 231}
+	enc, err := (&TightEncoding{}).Read(c, &Rectangle{Width: 1, Height: 3}, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []Color{{10, 10, 10}, {30, 30, 30}, {5, 5, 5}}
+	if got := enc.(*TightEncoding).Colors; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// appendCompactLength appends n in Tight's compact representation.
+func appendCompactLength(b []byte, n int) []byte {
+	for ; n >= 0x80; n >>= 7 {
+		b = append(b, byte(n)|0x80)
+	}
+	return append(b, byte(n))
+}
+
+// tightStream plays a server's side of one of Tight's zlib streams,
+// compressing the data of each rectangle sent on it.
+type tightStream struct {
+	id         uint8
+	compressed bytes.Buffer
+	w          *zlib.Writer
+}
+
+// rect returns the data of a Tight rectangle with filter data on the
+// stream, resetting it first if reset is set. A stream's first use
+// needn't be a reset.
+func (s *tightStream) rect(filter uint8, header, data []byte, reset bool) []byte {
+	control := s.id<<4 | 0x40
+	if reset {
+		control |= 1 << s.id
+	}
+	if reset || s.w == nil {
+		s.w = zlib.NewWriter(&s.compressed)
+	}
+	s.compressed.Reset()
+	s.w.Write(data)
+	s.w.Flush()
+
+	b := append([]byte{control, filter}, header...)
+	b = appendCompactLength(b, s.compressed.Len())
+	return append(b, s.compressed.Bytes()...)
+}
+
+// tightPixels returns n pixels of a pattern that compresses somewhat,
+// as TPIXELs and as the Colors they stand for.
+func tightPixels(n, seed int) ([]byte, []Color) {
+	data := make([]byte, 3*n)
+	colors := make([]Color, n)
+	for i := range colors {
+		colors[i] = Color{uint8(i / 7), uint8(seed), uint8(i*i/5 + seed)}
+		data[3*i], data[3*i+1], data[3*i+2] = colors[i].R, colors[i].G, colors[i].B
+	}
+	return data, colors
+}
+
+func TestTightEncoding_Streams(t *testing.T) {
+	c := fuzzConn()
+	enc := &TightEncoding{}
+	streams := []*tightStream{{id: 0}, {id: 1}, {id: 2}, {id: 3}}
+	rect := &Rectangle{Width: 16, Height: 16}
+
+	// Interleave the streams, resetting some of them along the way,
+	// including stream 0 on its first use, and mix in a palette
+	// rectangle and one too small to compress
+	for i := 0; i < 20; i++ {
+		s := streams[i%len(streams)]
+		data, want := tightPixels(rect.Area(), i)
+		b := s.rect(0, nil, data, i == 0 || i == 9 || i == 14)
+		if i%5 == 4 {
+			want = make([]Color, rect.Area())
+			indexes := make([]byte, rect.Area())
+			for j := range indexes {
+				indexes[j] = byte(j % 3)
+				want[j] = []Color{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}[j%3]
+			}
+			b = s.rect(1, []byte{2, 1, 2, 3, 4, 5, 6, 7, 8, 9}, indexes, false)
+		}
+		if i%7 == 6 {
+			rect := &Rectangle{Width: 2, Height: 1}
+			got, err := enc.Read(c, rect, bytes.NewReader([]byte{s.id << 4, 10, 20, 30, 40, 50, 60}))
+			if err != nil {
+				t.Fatalf("uncompressed rectangle %d: %s", i, err)
+			}
+			if colors := got.(*TightEncoding).Colors; !reflect.DeepEqual(colors, []Color{{10, 20, 30}, {40, 50, 60}}) {
+				t.Errorf("uncompressed rectangle %d: got %v", i, colors)
+			}
+		}
+
+		r := bytes.NewReader(b)
+		got, err := enc.Read(c, rect, r)
+		if err != nil {
+			t.Fatalf("rectangle %d: %s", i, err)
+		}
+		if r.Len() != 0 {
+			t.Errorf("rectangle %d: %d bytes left unread", i, r.Len())
+		}
+		if colors := got.(*TightEncoding).Colors; !reflect.DeepEqual(colors, want) {
+			t.Fatalf("rectangle %d: decoded differently", i)
+		}
+	}
+}
+
+// BenchmarkTightEncoding_Basic reads a run of 64x64 rectangles, each
+// compressed on the same zlib stream as the last.
+func BenchmarkTightEncoding_Basic(b *testing.B) {
+	quietLogs(b)
+	rect := &Rectangle{Width: 64, Height: 64}
+	s := &tightStream{}
+	var rects [][]byte
+	var size int
+	for i := 0; i < 16; i++ {
+		data, _ := tightPixels(rect.Area(), i)
+		rects = append(rects, s.rect(0, nil, data, false))
+		size += len(rects[i])
+	}
+
+	c := fuzzConn()
+	r := new(bytes.Reader)
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		// Start the stream over
+		enc := &TightEncoding{}
+		for _, data := range rects {
+			r.Reset(data)
+			got, err := enc.Read(c, rect, r)
+			if err != nil {
+				b.Fatal(err)
+			}
+			(&FramebufferUpdateMessage{Rectangles: []Rectangle{{Enc: got}}}).Release()
+		}
+	}
+}
+
+// rawFormats are pixel formats RawEncoding has fast paths for, and one
+// it doesn't.
+var rawFormats = []struct {
+	name   string
+	format PixelFormat
+}{
+	{"RGBX", PixelFormat{BPP: 32, Depth: 24, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, GreenShift: 8, BlueShift: 16}},
+	{"BGRX", PixelFormat{BPP: 32, Depth: 24, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}},
+	{"RGBXBigEndian", PixelFormat{BPP: 32, Depth: 24, BigEndian: true, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 24, GreenShift: 16, BlueShift: 8}},
+	{"BGRXBigEndian", PixelFormat{BPP: 32, Depth: 24, BigEndian: true, TrueColor: true, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 8, GreenShift: 16, BlueShift: 24}},
+	{"565", PixelFormat{BPP: 16, Depth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}},
+	{"565BigEndian", PixelFormat{BPP: 16, Depth: 16, BigEndian: true, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}},
+	{"555", PixelFormat{BPP: 16, Depth: 15, TrueColor: true, RedMax: 31, GreenMax: 31, BlueMax: 31, RedShift: 10, GreenShift: 5}},
+}
+
+func TestRawEncoding_Formats(t *testing.T) {
+	rect := &Rectangle{Width: 5, Height: 3}
+	for _, tt := range rawFormats {
+		bytesPerPixel := int(tt.format.BPP / 8)
+		data := make([]byte, rect.Area()*bytesPerPixel)
+		for i := range data {
+			data[i] = byte(i*37 + 11)
+		}
+
+		// Decode each pixel as RFC 6143 describes, and scale each
+		// intensity to 8 bits
+		scale := func(v uint32, max uint16) uint8 {
+			v &= uint32(max)
+			return uint8((v*255 + uint32(max)/2) / uint32(max))
+		}
+		want := make([]Color, rect.Area())
+		for i := range want {
+			var v uint32
+			for j := 0; j < bytesPerPixel; j++ {
+				b := data[i*bytesPerPixel+j]
+				if tt.format.BigEndian {
+					v = v<<8 | uint32(b)
+				} else {
+					v |= uint32(b) << (8 * uint(j))
+				}
+			}
+			want[i] = Color{
+				scale(v>>tt.format.RedShift, tt.format.RedMax),
+				scale(v>>tt.format.GreenShift, tt.format.GreenMax),
+				scale(v>>tt.format.BlueShift, tt.format.BlueMax),
+			}
+		}
+
+		c := fuzzConn()
+		c.PixelFormat = tt.format
+		enc, err := (&RawEncoding{}).Read(c, rect, bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		if got := enc.(*RawEncoding).Colors; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+	}
+}
+
+// BenchmarkRawEncoding reads a 1920x1080 rectangle in each of
+// rawFormats.
+func BenchmarkRawEncoding(b *testing.B) {
+	rect := &Rectangle{Width: 1920, Height: 1080}
+	for _, tt := range rawFormats {
+		b.Run(tt.name, func(b *testing.B) {
+			data := make([]byte, rect.Area()*int(tt.format.BPP/8))
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			c := fuzzConn()
+			c.PixelFormat = tt.format
+			c.FramebufferWidth, c.FramebufferHeight = rect.Width, rect.Height
+			r := bytes.NewReader(data)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(data)
+				enc, err := (&RawEncoding{}).Read(c, rect, r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				(&FramebufferUpdateMessage{Rectangles: []Rectangle{{Enc: enc}}}).Release()
+			}
+		})
+	}
+}
+
 func TestZRLEPayload(t *testing.T) {
+	// The payloads were recorded in the format gymvnc asks for, whose
+	// CPIXELs are bytes of red, green and blue
+	p, err := newPixelConverter(&rawFormats[0].format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	for i, payload := range zrlePayloads {
 		rect := &Rectangle{
 			X:      payload.x,
@@ -46,8 +284,8 @@ func TestZRLEPayload(t *testing.T) {
 		buf := &QuickBuf{buf: data}
 
 		encoding := &ZRLEEncoding{}
-		parsed, err := encoding.parse(rect, buf)
-		if err != nil {
+		parsed := make([]Color, rect.Area())
+		if err := encoding.parse(p, rect, buf, parsed, int(rect.Width)); err != nil {
 			t.Fatal(err)
 		}
 
@@ -71,7 +309,7 @@ var zrlePayloads = []zrlePayload{
 		y:      279,
 		width:  10,
 		height: 16,
-		result: []Color{Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}},
+		result: []Color{Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 249, B: 246}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 249, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 249, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 249, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 0}, Color{R: 0, G: 0, B: 0}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}, Color{R: 246, G: 249, B: 248}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 248, G: 248, B: 248}, Color{R: 248, G: 248, B: 248}},
 	},
 	zrlePayload{
 		data:   "A////13qpgAAEQaqqqoKqqqqCqqqqgqqqqoKqqqqCqqqqgqqqqoKqqqqCqqqqgqqqqoKqqqqCqqqqgqqqqoKqqqqCqqqqgqqqqo=",
@@ -80,7 +318,7 @@ var zrlePayloads = []zrlePayload{
 		width:  16,
 		height: 16,
 		result: []Color{
-			Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17},
+			Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 93, G: 234, B: 166}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 255, G: 255, B: 255}, Color{R: 255, G: 255, B: 255}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17}, Color{R: 0, G: 0, B: 17},
 		},
 	},
 }
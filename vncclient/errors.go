@@ -0,0 +1,136 @@
+package vncclient
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+
+	"github.com/juju/errors"
+)
+
+// Error is implemented by each of the error types below. Errors are
+// annotated on their way up, so use errors.Cause to get at them, or
+// IsRetryable to just ask whether trying again might help.
+type Error interface {
+	error
+
+	// Retryable reports whether the same operation may succeed if
+	// tried again, e.g. once a restarting server is back.
+	Retryable() bool
+}
+
+// IsRetryable reports whether err, or the error it was annotated from,
+// is an Error which is Retryable.
+func IsRetryable(err error) bool {
+	e, ok := errors.Cause(err).(Error)
+	return ok && e.Retryable()
+}
+
+// AuthError means the server refused to let us in: it rejected our
+// credentials, or we had no security type in common.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string   { return e.Err.Error() }
+func (e *AuthError) Retryable() bool { return false }
+
+// ConnectError means the connection to the server could not be
+// established, or was lost. Retry is set for failures that a server
+// which is still starting up (or restarting) would cause.
+type ConnectError struct {
+	Err   error
+	Retry bool
+}
+
+func (e *ConnectError) Error() string   { return e.Err.Error() }
+func (e *ConnectError) Retryable() bool { return e.Retry }
+
+// ProtocolError means the server sent something that isn't valid RFB,
+// or that we don't speak.
+type ProtocolError struct {
+	Err error
+}
+
+func (e *ProtocolError) Error() string   { return e.Err.Error() }
+func (e *ProtocolError) Retryable() bool { return false }
+
+// UnsupportedEncodingError means the server sent a rectangle in an
+// encoding we can't decode.
+type UnsupportedEncodingError struct {
+	Encoding int32
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported encoding type: %v", e.Encoding)
+}
+func (e *UnsupportedEncodingError) Retryable() bool { return false }
+
+// DecodeError means a rectangle's pixel data was malformed. Encoding
+// and Rect describe the rectangle being decoded, if known. If decoding
+// it panicked, Stack is where.
+type DecodeError struct {
+	Encoding int32
+	Rect     Rectangle
+	Err      error
+	Stack    []byte
+}
+
+func (e *DecodeError) Error() string   { return e.Err.Error() }
+func (e *DecodeError) Retryable() bool { return false }
+
+// ClosedError means the connection was shut down on our side, by Close
+// or by cancelling the handshake. Err, if set, is what that caused.
+type ClosedError struct {
+	Err error
+}
+
+func (e *ClosedError) Error() string {
+	if e.Err == nil {
+		return "vncclient: connection closed"
+	}
+	return e.Err.Error()
+}
+func (e *ClosedError) Retryable() bool { return false }
+
+// newDecodeError is a DecodeError for rect, in the given encoding.
+func newDecodeError(encoding int32, rect *Rectangle, err error) *DecodeError {
+	e := &DecodeError{Encoding: encoding, Rect: *rect, Err: err}
+	e.Rect.Enc = nil
+	return e
+}
+
+// PanicError converts v, as recovered from a panic while decoding rect
+// (which may be nil) in the given encoding, into a DecodeError. It must
+// be called from the deferred function that recovered it, so that
+// Stack shows where the panic happened.
+func PanicError(v interface{}, encoding int32, rect *Rectangle) *DecodeError {
+	if rect == nil {
+		return &DecodeError{Encoding: encoding, Err: errors.Errorf("panic while decoding: %v", v), Stack: debug.Stack()}
+	}
+	err := errors.Errorf("panic while decoding %dx%d rectangle at (%d, %d) in encoding %d: %v", rect.Width, rect.Height, rect.X, rect.Y, encoding, v)
+	e := newDecodeError(encoding, rect, err)
+	e.Stack = debug.Stack()
+	return e
+}
+
+// isIOError reports whether err came from the connection itself, as
+// opposed to from interpreting what was read from it.
+func isIOError(err error) bool {
+	err = errors.Cause(err)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// asConnectError classifies an error that isn't already one of ours as
+// a ConnectError.
+func asConnectError(err error, retry bool) error {
+	if _, ok := errors.Cause(err).(Error); ok || err == nil {
+		return err
+	}
+	return &ConnectError{Err: err, Retry: retry}
+}
@@ -0,0 +1,197 @@
+package vncclient
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/juju/errors"
+)
+
+// sendAfterHandshake completes the handshake and then sends msg.
+func sendAfterHandshake(msg []byte) func(c net.Conn) error {
+	return func(c net.Conn) error {
+		if err := handshake38(c); err != nil {
+			return err
+		}
+		if _, err := c.Write(msg); err != nil {
+			return err
+		}
+		// Hold the connection open until the client gives up on it
+		c.Read(make([]byte, 1))
+		return nil
+	}
+}
+
+func TestClient_HandshakeErrorTypes(t *testing.T) {
+	// Closing before sending anything is what a server that's still
+	// starting does, so it's retryable
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("error connecting: %s", err)
+	}
+	_, err, soft := Client(nc, &ClientConfig{})
+	if _, ok := err.(*ConnectError); !ok || !soft || !IsRetryable(err) {
+		t.Errorf("closed before version: got %T %v (soft=%v), want a retryable ConnectError", err, err, soft)
+	}
+
+	_, err = dialMockServer(t, "002.009", &ClientConfig{}, nil)
+	if _, ok := err.(*ProtocolError); !ok || IsRetryable(err) {
+		t.Errorf("bad version: got %T %v, want a ProtocolError", err, err)
+	}
+
+	_, err = dialMockServer(t, "003.008", &ClientConfig{}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.008\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{0}); err != nil {
+			return err
+		}
+		return writeReason(c, "go away")
+	})
+	if _, ok := err.(*AuthError); !ok || IsRetryable(err) {
+		t.Errorf("no security types: got %T %v, want an AuthError", err, err)
+	}
+}
+
+func TestClientConn_ErrorTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   []byte
+		check func(err error) bool
+	}{
+		{
+			"unknown message",
+			[]byte{99},
+			func(err error) bool { _, ok := err.(*ProtocolError); return ok },
+		},
+		{
+			"unsupported encoding",
+			// One 1x1 rectangle in encoding 0x3039
+			[]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0x30, 0x39},
+			func(err error) bool {
+				e, ok := err.(*UnsupportedEncodingError)
+				return ok && e.Encoding == 0x3039
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		conn, err := dialMockServer(t, "003.008", &ClientConfig{}, sendAfterHandshake(tt.msg))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		waitDone(t, conn)
+		if err := errors.Cause(conn.Err()); !tt.check(err) || IsRetryable(err) {
+			t.Errorf("%s: got %T %v", tt.name, err, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestFramebufferUpdateMessage_DecodeError(t *testing.T) {
+	c := &ClientConn{Encs: []Encoding{&TightEncoding{}}, FramebufferWidth: 4000, FramebufferHeight: 10}
+	// A Tight rectangle too wide for Tight
+	msg := []byte{0, 0, 1, 0, 0, 0, 0, 0x0b, 0xb8, 0, 1, 0, 0, 0, 7}
+	_, err := new(FramebufferUpdateMessage).Read(c, bytes.NewReader(msg))
+	if e, ok := err.(*DecodeError); !ok || e.Encoding != 7 || IsRetryable(err) {
+		t.Errorf("got %T %v, want a DecodeError for encoding 7", err, err)
+	}
+
+	// Running out of data is an I/O error, not a decoding one
+	_, err = new(FramebufferUpdateMessage).Read(c, bytes.NewReader(msg[:10]))
+	if _, ok := err.(*DecodeError); ok || err == nil {
+		t.Errorf("truncated message: got %T %v", err, err)
+	}
+}
+
+func TestClientConn_ClosedErrorTypes(t *testing.T) {
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{}, handshake38)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.Close()
+
+	if _, ok := conn.Err().(*ClosedError); !ok {
+		t.Errorf("Err() after Close = %T %v, want a ClosedError", conn.Err(), conn.Err())
+	}
+	err = conn.KeyEvent(0x61, true)
+	if _, ok := errors.Cause(err).(*ClosedError); !ok {
+		t.Errorf("sending after Close = %T %v, want a ClosedError", err, err)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	err := errors.Annotate(&ConnectError{Err: errors.New("refused"), Retry: true}, "could not connect")
+	if !IsRetryable(err) {
+		t.Error("annotated retryable ConnectError should be retryable")
+	}
+	if err.Error() != "could not connect: refused" {
+		t.Errorf("unexpected message: %s", err)
+	}
+	if IsRetryable(errors.New("plain")) {
+		t.Error("plain errors should not be retryable")
+	}
+}
+
+// panickingEncoding is a decoder that trusts the server too far.
+type panickingEncoding struct{}
+
+func (*panickingEncoding) Type() int32 { return 0x7f }
+func (*panickingEncoding) Size() int   { return 0 }
+func (*panickingEncoding) Read(c *ClientConn, rect *Rectangle, r io.Reader) (Encoding, error) {
+	var palette []Color
+	return &RawEncoding{Colors: palette[:rect.Width]}, nil
+}
+
+func TestFramebufferUpdateMessage_Panic(t *testing.T) {
+	c := &ClientConn{Encs: []Encoding{&panickingEncoding{}}, FramebufferWidth: 640, FramebufferHeight: 480}
+	// One 3x2 rectangle at (1, 4) in encoding 0x7f
+	msg := []byte{0, 0, 1, 0, 1, 0, 4, 0, 3, 0, 2, 0, 0, 0, 0x7f}
+	_, err := new(FramebufferUpdateMessage).Read(c, bytes.NewReader(msg))
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("got %T %v, want a DecodeError", err, err)
+	}
+	if e.Encoding != 0x7f || e.Rect.X != 1 || e.Rect.Y != 4 || e.Rect.Width != 3 || e.Rect.Height != 2 {
+		t.Errorf("wrong rectangle: encoding %d, %+v", e.Encoding, e.Rect)
+	}
+	if !strings.Contains(string(e.Stack), "panickingEncoding") {
+		t.Errorf("stack doesn't show the panic:\n%s", e.Stack)
+	}
+}
+
+// panickingMessage is a server message that trusts the server too far.
+type panickingMessage struct{}
+
+func (*panickingMessage) Type() uint8 { return 99 }
+func (*panickingMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
+	var fields []byte
+	return nil, errors.Errorf("field %d", fields[0])
+}
+
+func TestClientConn_PanicEndsConnection(t *testing.T) {
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{
+		ServerMessages: []ServerMessage{&panickingMessage{}},
+	}, sendAfterHandshake([]byte{99}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+	waitDone(t, conn)
+	if e, ok := conn.Err().(*DecodeError); !ok || len(e.Stack) == 0 {
+		t.Errorf("got %T %v, want a DecodeError with a stack", conn.Err(), conn.Err())
+	}
+}
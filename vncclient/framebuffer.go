@@ -0,0 +1,137 @@
+package vncclient
+
+import "sync"
+
+// A Framebuffer is somewhere for encodings to paint the pixels they
+// decode, so that they needn't be materialised for each rectangle.
+//
+// A FramebufferUpdateMessage read with a Framebuffer in the
+// ClientConfig has been painted into it by the time it is delivered,
+// and the Colors of its rectangles' encodings are nil.
+type Framebuffer interface {
+	// Lock returns the pixels of rect for an encoding to overwrite,
+	// as rows of stride Colors starting from rect's top left corner.
+	// The rectangle has already been checked to fit within the
+	// ClientConn's framebuffer. The pixels are only to be written
+	// until Unlock.
+	Lock(rect *Rectangle) (pix []Color, stride int)
+
+	// Unlock is called once rect has been painted, or has failed to
+	// be.
+	Unlock(rect *Rectangle)
+}
+
+// An UpdateFramebuffer is a Framebuffer that is also told where each
+// FramebufferUpdate begins and ends, so that it can treat the update's
+// rectangles as a whole.
+type UpdateFramebuffer interface {
+	Framebuffer
+
+	// BeginUpdate is called before any of an update's rectangles
+	// are painted.
+	BeginUpdate()
+
+	// EndUpdate is called once all of them have been. If err isn't
+	// nil, the update failed part way and won't be delivered.
+	EndUpdate(err error)
+}
+
+// rectColors is what encodings paint into without a Framebuffer in the
+// ClientConfig: the Colors of the rectangle alone, which its Encoding
+// then carries.
+type rectColors []Color
+
+func (r rectColors) Lock(rect *Rectangle) ([]Color, int) {
+	return r, int(rect.Width)
+}
+
+func (rectColors) Unlock(*Rectangle) {}
+
+// framebuffer returns where to paint rect, and the Colors its Encoding
+// should carry, which are nil if they went to the ClientConfig's
+// Framebuffer.
+func (c *ClientConn) framebuffer(rect *Rectangle) (Framebuffer, []Color) {
+	if c.config != nil && c.config.Framebuffer != nil {
+		return c.config.Framebuffer, nil
+	}
+	colors := getColors(rect.Area())
+	return rectColors(colors), colors
+}
+
+// copyRows copies colors, in rows of width, into pix.
+func copyRows(pix []Color, stride int, colors []Color, width, height int) {
+	for y := 0; y < height; y++ {
+		copy(pix[y*stride:y*stride+width], colors[y*width:])
+	}
+}
+
+// fillRows fills width by height pixels of pix with color.
+func fillRows(pix []Color, stride int, color Color, width, height int) {
+	if width == 0 {
+		return
+	}
+	for y := 0; y < height; y++ {
+		fillColor(pix[y*stride:y*stride+width], color)
+	}
+}
+
+// The pools hold buffers for decoding into, and the Colors of released
+// FramebufferUpdateMessages. A buffer too small for what's asked of it
+// is left to the garbage collector.
+var (
+	colorPool sync.Pool
+	bytePool  sync.Pool
+)
+
+// getColors returns n Colors, which may be left over from a previous
+// use.
+func getColors(n int) []Color {
+	if p, ok := colorPool.Get().(*[]Color); ok && cap(*p) >= n {
+		return (*p)[:n]
+	}
+	return make([]Color, n)
+}
+
+func putColors(colors []Color) {
+	if cap(colors) > 0 {
+		colors = colors[:0]
+		colorPool.Put(&colors)
+	}
+}
+
+// getBytes returns n bytes, which may be left over from a previous
+// use.
+func getBytes(n int) []byte {
+	if p, ok := bytePool.Get().(*[]byte); ok && cap(*p) >= n {
+		return (*p)[:n]
+	}
+	return make([]byte, n)
+}
+
+func putBytes(b []byte) {
+	if cap(b) > 0 {
+		b = b[:0]
+		bytePool.Put(&b)
+	}
+}
+
+// Release hands the Colors of the message's rectangles back to be
+// reused by later ones. Neither they nor the message may be used
+// afterwards. Releasing is optional: a message that isn't is garbage
+// collected as usual.
+func (m *FramebufferUpdateMessage) Release() {
+	for _, rect := range m.Rectangles {
+		switch enc := rect.Enc.(type) {
+		case *RawEncoding:
+			putColors(enc.Colors)
+			enc.Colors = nil
+		case *ZRLEEncoding:
+			putColors(enc.Colors)
+			enc.Colors = nil
+		case *TightEncoding:
+			putColors(enc.Colors)
+			enc.Colors = nil
+		}
+	}
+	m.Rectangles = nil
+}
@@ -0,0 +1,230 @@
+package vncclient
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	logging "github.com/op/go-logging"
+	"github.com/openai/go-vncdriver/flexzlib"
+)
+
+// screen is a Framebuffer the size of fuzzConn's, which checks that
+// each Lock is matched by an Unlock.
+type screen struct {
+	pix    []Color
+	locked *Rectangle
+}
+
+func newScreen() *screen {
+	return &screen{pix: make([]Color, 640*480)}
+}
+
+func (s *screen) Lock(rect *Rectangle) ([]Color, int) {
+	if s.locked != nil {
+		panic("locked twice")
+	}
+	s.locked = rect
+	return s.pix[int(rect.Y)*640+int(rect.X):], 640
+}
+
+func (s *screen) Unlock(rect *Rectangle) {
+	if s.locked != rect {
+		panic("unlocked without being locked")
+	}
+	s.locked = nil
+}
+
+// framebufferCase is a rectangle of each encoding, decoding to a
+// variety of colors.
+type framebufferCase struct {
+	name          string
+	enc           Encoding
+	width, height uint16
+	data          []byte
+}
+
+func framebufferCases() []framebufferCase {
+	raw := make([]byte, 64*64*4)
+	for i := range raw {
+		raw[i] = byte(i * 7)
+	}
+
+	// Three tiles of different solid colors, and one of raw pixels
+	var tiles []byte
+	for i := byte(0); i < 3; i++ {
+		tiles = append(tiles, 1, 10*i, 20*i, 30*i)
+	}
+	tiles = append(tiles, 0)
+	for i := 0; i < 8*6*3; i++ {
+		tiles = append(tiles, byte(i))
+	}
+
+	return []framebufferCase{
+		{"Raw", &RawEncoding{}, 64, 64, raw},
+		{"ZRLE", &ZRLEEncoding{}, 72, 70, zrleData(tiles)},
+		{"TightFill", &TightEncoding{}, 64, 64, []byte{0x80, 10, 20, 30}},
+		{"TightCopy", &TightEncoding{}, 2, 1, []byte{0x00, 1, 2, 3, 4, 5, 6}},
+		{"TightPalette1Bit", &TightEncoding{}, 4, 1, []byte{0x40, 1, 1, 0, 0, 0, 255, 255, 255, 0x50}},
+		{"TightPalette", &TightEncoding{}, 3, 1, []byte{0x40, 1, 2, 0, 0, 0, 128, 128, 128, 255, 255, 255, 0, 1, 2}},
+		{"TightGradient", &TightEncoding{}, 1, 3, []byte{0x40, 2, 10, 10, 10, 20, 20, 20, 231, 231, 231}},
+	}
+}
+
+// colorsOf returns the Colors enc decoded to, if any.
+func colorsOf(enc Encoding) []Color {
+	switch enc := enc.(type) {
+	case *RawEncoding:
+		return enc.Colors
+	case *ZRLEEncoding:
+		return enc.Colors
+	case *TightEncoding:
+		return enc.Colors
+	}
+	return nil
+}
+
+func TestFramebuffer_MatchesColors(t *testing.T) {
+	for _, tt := range framebufferCases() {
+		t.Run(tt.name, func(t *testing.T) {
+			rect := &Rectangle{X: 100, Y: 50, Width: tt.width, Height: tt.height}
+			enc, err := tt.enc.Read(fuzzConn(), rect, bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := colorsOf(enc)
+
+			fb := newScreen()
+			for i := range fb.pix {
+				fb.pix[i] = Color{1, 2, 3}
+			}
+			c := fuzzConn()
+			c.config = &ClientConfig{Framebuffer: fb}
+			painted, err := tt.enc.Read(c, rect, bytes.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fb.locked != nil {
+				t.Fatal("left locked")
+			}
+			if colors := colorsOf(painted); colors != nil {
+				t.Errorf("painted encoding has Colors %v; expected none", colors)
+			}
+
+			for y := 0; y < 480; y++ {
+				for x := 0; x < 640; x++ {
+					got := fb.pix[y*640+x]
+					expected := Color{1, 2, 3}
+					if x >= int(rect.X) && x < int(rect.X+rect.Width) && y >= int(rect.Y) && y < int(rect.Y+rect.Height) {
+						expected = want[(y-int(rect.Y))*int(rect.Width)+x-int(rect.X)]
+					}
+					if got != expected {
+						t.Fatalf("pixel (%d, %d) is %v; expected %v", x, y, got, expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+// updateScreen is a screen that logs the calls made on it.
+type updateScreen struct {
+	*screen
+	calls []string
+}
+
+func (s *updateScreen) Lock(rect *Rectangle) ([]Color, int) {
+	s.calls = append(s.calls, "Lock")
+	return s.screen.Lock(rect)
+}
+
+func (s *updateScreen) BeginUpdate() {
+	s.calls = append(s.calls, "BeginUpdate")
+}
+
+func (s *updateScreen) EndUpdate(err error) {
+	s.calls = append(s.calls, fmt.Sprintf("EndUpdate(%v)", err != nil))
+}
+
+func TestUpdateFramebuffer(t *testing.T) {
+	raw1x1 := []byte{0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 1, 2, 3, 0}
+	twoRects := append(append([]byte{0, 0, 2}, raw1x1...), raw1x1...)
+	tests := []struct {
+		name  string
+		data  []byte
+		calls []string
+	}{
+		{"two rectangles", twoRects, []string{"BeginUpdate", "Lock", "Lock", "EndUpdate(false)"}},
+		{"cut short", twoRects[:len(twoRects)-1], []string{"BeginUpdate", "Lock", "EndUpdate(true)"}},
+	}
+	for _, tt := range tests {
+		fb := &updateScreen{screen: newScreen()}
+		c := fuzzConn()
+		c.config = &ClientConfig{Framebuffer: fb}
+		new(FramebufferUpdateMessage).Read(c, bytes.NewReader(tt.data))
+		if !reflect.DeepEqual(fb.calls, tt.calls) {
+			t.Errorf("%s: got calls %v, want %v", tt.name, fb.calls, tt.calls)
+		}
+	}
+}
+
+func TestFramebufferUpdateMessage_Release(t *testing.T) {
+	data := []byte{0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 255, 255, 255, 0}
+	msg, err := new(FramebufferUpdateMessage).Read(fuzzConn(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := msg.(*FramebufferUpdateMessage)
+	enc := update.Rectangles[0].Enc.(*RawEncoding)
+	if len(enc.Colors) != 1 || enc.Size() != 3 {
+		t.Fatalf("decoded %v, of size %d", enc.Colors, enc.Size())
+	}
+	update.Release()
+	if enc.Colors != nil || update.Rectangles != nil {
+		t.Errorf("still have %v and %v after Release", enc.Colors, update.Rectangles)
+	}
+	if enc.Size() != 3 {
+		t.Errorf("size is %d after Release; expected 3", enc.Size())
+	}
+}
+
+// quietLogs turns off debug logging, which would otherwise dominate
+// benchmarks, until the end of tb.
+func quietLogs(tb testing.TB) {
+	level := logging.GetLevel("vncclient")
+	logging.SetLevel(logging.WARNING, "vncclient")
+	tb.Cleanup(func() { logging.SetLevel(level, "vncclient") })
+}
+
+// BenchmarkFramebuffer compares decoding each encoding to Colors of
+// its own, released after, with painting it into a Framebuffer.
+func BenchmarkFramebuffer(b *testing.B) {
+	quietLogs(b)
+	for _, tt := range framebufferCases() {
+		for _, fb := range []Framebuffer{nil, newScreen()} {
+			name := tt.name + "/Colors"
+			if fb != nil {
+				name = tt.name + "/Framebuffer"
+			}
+			b.Run(name, func(b *testing.B) {
+				c := fuzzConn()
+				c.config = &ClientConfig{Framebuffer: fb}
+				rect := &Rectangle{X: 100, Y: 50, Width: tt.width, Height: tt.height}
+				r := bytes.NewReader(tt.data)
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					// Each rectangle starts a new zlib stream
+					c.inflator = flexzlib.NewInflator()
+					r.Reset(tt.data)
+					enc, err := tt.enc.Read(c, rect, r)
+					if err != nil {
+						b.Fatal(err)
+					}
+					update := FramebufferUpdateMessage{Rectangles: []Rectangle{{Enc: enc}}}
+					update.Release()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package vncclient
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"testing"
+
+	"github.com/openai/go-vncdriver/flexzlib"
+)
+
+// fuzzConn returns a ClientConn in the state a freshly negotiated
+// connection to a 640x480, 32-bit true color server would be in.
+func fuzzConn() *ClientConn {
+	return &ClientConn{
+		PixelFormat: PixelFormat{
+			BPP:        32,
+			Depth:      24,
+			TrueColor:  true,
+			RedMax:     255,
+			GreenMax:   255,
+			BlueMax:    255,
+			RedShift:   16,
+			GreenShift: 8,
+		},
+		Encs:              []Encoding{&RawEncoding{}, &ZRLEEncoding{}, &TightEncoding{}},
+		FramebufferWidth:  640,
+		FramebufferHeight: 480,
+		inflator:          flexzlib.NewInflator(),
+	}
+}
+
+// fuzzFormatConns returns fuzzConns in each of a few pixel formats
+// whose pixels are laid out differently: 32-bit true color with 3-byte
+// CPIXELs and TPIXELs, 16-bit 565, and 8-bit true color and color map.
+func fuzzFormatConns() []*ClientConn {
+	conns := []*ClientConn{fuzzConn(), fuzzConn(), fuzzConn(), fuzzConn()}
+	conns[1].PixelFormat = PixelFormat{BPP: 16, Depth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	conns[2].PixelFormat = PixelFormat{BPP: 8, Depth: 8, TrueColor: true, RedMax: 7, GreenMax: 7, BlueMax: 3, RedShift: 5, GreenShift: 2}
+	conns[3].PixelFormat = PixelFormat{BPP: 8, Depth: 8}
+	return conns
+}
+
+// checkColors fails the test unless enc decoded a color for every
+// pixel of rect.
+func checkColors(t *testing.T, rect *Rectangle, enc Encoding) {
+	var colors []Color
+	switch enc := enc.(type) {
+	case *RawEncoding:
+		colors = enc.Colors
+	case *ZRLEEncoding:
+		colors = enc.Colors
+	case *TightEncoding:
+		colors = enc.Colors
+	default:
+		t.Fatalf("decoded to %T", enc)
+	}
+	if len(colors) != rect.Area() {
+		t.Fatalf("decoded %d colors for %dx%d rectangle", len(colors), rect.Width, rect.Height)
+	}
+}
+
+func FuzzRawEncoding_Read(f *testing.F) {
+	f.Add(uint8(32), true, uint8(2), uint8(1), []byte{1, 2, 3, 0, 4, 5, 6, 0})
+	f.Add(uint8(8), false, uint8(2), uint8(2), []byte{0, 1, 2, 255})
+	f.Add(uint8(16), false, uint8(1), uint8(1), []byte{0xff, 0xff})
+	f.Fuzz(func(t *testing.T, bpp uint8, trueColor bool, width, height uint8, data []byte) {
+		c := fuzzConn()
+		c.PixelFormat.BPP = bpp
+		c.PixelFormat.TrueColor = trueColor
+		rect := &Rectangle{Width: uint16(width), Height: uint16(height)}
+		enc, err := (&RawEncoding{}).Read(c, rect, bytes.NewReader(data))
+		if err == nil {
+			checkColors(t, rect, enc)
+		}
+	})
+}
+
+// zrleData compresses ZRLE tile data and prefixes it with its length,
+// as a server would send it.
+func zrleData(tiles []byte) []byte {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(tiles)
+	w.Flush()
+
+	data := make([]byte, 4, 4+compressed.Len())
+	binary.BigEndian.PutUint32(data, uint32(compressed.Len()))
+	return append(data, compressed.Bytes()...)
+}
+
+func FuzzZRLEEncoding_Read(f *testing.F) {
+	// Solid, raw, packed palette, plain RLE and palette RLE tiles, as
+	// tile data for the fuzz function to compress
+	f.Add(uint8(4), uint8(4), false, []byte{1, 10, 20, 30})
+	f.Add(uint8(2), uint8(1), false, []byte{0, 1, 2, 3, 4, 5, 6})
+	f.Add(uint8(4), uint8(1), false, []byte{2, 0, 0, 0, 255, 255, 255, 0x50})
+	f.Add(uint8(3), uint8(2), false, []byte{128, 1, 2, 3, 2, 4, 5, 6, 2})
+	f.Add(uint8(3), uint8(1), false, []byte{130, 0, 0, 0, 255, 255, 255, 0x80, 1, 1})
+	// The same solid tile, already compressed
+	f.Add(uint8(4), uint8(4), true, zrleData([]byte{1, 10, 20, 30}))
+	f.Fuzz(func(t *testing.T, width, height uint8, compressed bool, data []byte) {
+		if !compressed {
+			data = zrleData(data)
+		}
+		rect := &Rectangle{Width: uint16(width), Height: uint16(height)}
+		for _, c := range fuzzFormatConns() {
+			enc, err := (&ZRLEEncoding{}).Read(c, rect, bytes.NewReader(data))
+			if err == nil {
+				checkColors(t, rect, enc)
+			}
+		}
+	})
+}
+
+func FuzzTightEncoding_Read(f *testing.F) {
+	// FillCompression
+	f.Add(uint8(4), uint8(4), []byte{0x80, 10, 20, 30})
+	// CopyFilter, sent uncompressed since it's under 12 bytes
+	f.Add(uint8(2), uint8(1), []byte{0x00, 1, 2, 3, 4, 5, 6})
+	// PaletteFilter with 2 colors, so 1 bit per pixel
+	f.Add(uint8(4), uint8(1), []byte{0x40, 1, 1, 0, 0, 0, 255, 255, 255, 0x50})
+	// PaletteFilter with 3 colors, so a byte per pixel
+	f.Add(uint8(3), uint8(1), []byte{0x40, 1, 2, 0, 0, 0, 128, 128, 128, 255, 255, 255, 0, 1, 2})
+	// GradientFilter
+	f.Add(uint8(1), uint8(1), []byte{0x40, 2, 1, 2, 3})
+	f.Fuzz(func(t *testing.T, width, height uint8, data []byte) {
+		rect := &Rectangle{Width: uint16(width), Height: uint16(height)}
+		for _, c := range fuzzFormatConns() {
+			enc, err := (&TightEncoding{}).Read(c, rect, bytes.NewReader(data))
+			if err == nil {
+				checkColors(t, rect, enc)
+			}
+		}
+	})
+}
+
+func FuzzFramebufferUpdateMessage_Read(f *testing.F) {
+	// A raw 1x1 rectangle
+	f.Add([]byte{0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 255, 255, 255, 0})
+	// A ZRLE 4x4 solid rectangle, then a Tight 2x1 filled one
+	zrle := append([]byte{0, 0, 2, 0, 0, 0, 0, 0, 4, 0, 4, 0, 0, 0, 16}, zrleData([]byte{1, 10, 20, 30})...)
+	f.Add(append(zrle, 0, 4, 0, 0, 0, 2, 0, 1, 0, 0, 0, 7, 0x80, 1, 2, 3))
+	// An unsupported encoding
+	f.Add([]byte{0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0x30, 0x39})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := new(FramebufferUpdateMessage).Read(fuzzConn(), bytes.NewReader(data))
+		if e, ok := err.(*DecodeError); ok && e.Stack != nil {
+			t.Fatalf("decoder panicked: %s\n%s", e, e.Stack)
+		}
+		if err != nil {
+			return
+		}
+		for _, rect := range msg.(*FramebufferUpdateMessage).Rectangles {
+			checkColors(t, &rect, rect.Enc)
+		}
+	})
+}
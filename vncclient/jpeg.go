@@ -0,0 +1,144 @@
+package vncclient
+
+import (
+	"runtime"
+
+	"github.com/juju/errors"
+)
+
+// decodeJPEG decodes the jpeg-data of a Tight rectangle.
+func decodeJPEG(rect *Rectangle, data []byte) ([]Color, error) {
+	colors, width, height, err := jpegDecoder(data)
+	if err != nil {
+		return nil, errors.Annotate(err, "could not decode jpeg")
+	}
+	if width != int(rect.Width) || height != int(rect.Height) {
+		return nil, errors.Errorf("%dx%d jpeg in %dx%d rectangle", width, height, rect.Width, rect.Height)
+	}
+	return colors, nil
+}
+
+// jpegDecoder decodes a JFIF stream into rows of Colors. It's
+// libjpeg-turbo where that's built in, and image/jpeg otherwise.
+var jpegDecoder = decodeStdJPEG
+
+// A jpegJob is a Tight JPEG rectangle handed to the JPEG workers.
+// Unlike the zlib-compressed rectangles, these don't depend on what
+// came before them, so the reader can carry on with the rest of the
+// update while they're decoded.
+type jpegJob struct {
+	rect *Rectangle
+	enc  *TightEncoding
+	data []byte
+
+	// done is closed once decoded or err is set
+	done    chan struct{}
+	decoded []Color
+	err     error
+}
+
+func (j *jpegJob) decode() {
+	defer close(j.done)
+	defer func() {
+		if v := recover(); v != nil {
+			j.err = PanicError(v, j.enc.Type(), j.rect)
+		}
+	}()
+	j.decoded, j.err = decodeJPEG(j.rect, j.data)
+}
+
+// jpegWorkers returns how many JPEG workers cfg asks for.
+func jpegWorkers(cfg *ClientConfig) int {
+	if cfg.JPEGWorkers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return cfg.JPEGWorkers
+}
+
+// startJPEGWorkers starts n goroutines decoding the JPEG rectangles the
+// reader queues, which run until the reader exits.
+func (c *ClientConn) startJPEGWorkers(n int) {
+	if n <= 0 {
+		return
+	}
+	c.jpegJobs = make(chan *jpegJob, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				select {
+				case job := <-c.jpegJobs:
+					job.decode()
+				case <-c.done:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// jpegQueue holds the JPEG rectangles of the FramebufferUpdate being
+// read that are yet to be painted. They must still be painted in order
+// with the rest of the update, wherever they overlap.
+type jpegQueue struct {
+	pending []*jpegJob
+}
+
+// add hands a JPEG rectangle's data to the workers to decode, or if
+// there are none, returns false for the caller to decode it itself.
+func (q *jpegQueue) add(c *ClientConn, rect *Rectangle, enc *TightEncoding, data []byte) bool {
+	if q == nil || c.jpegJobs == nil {
+		return false
+	}
+	job := &jpegJob{rect: rect, enc: enc, data: data, done: make(chan struct{})}
+	c.jpegJobs <- job
+	q.pending = append(q.pending, job)
+	return true
+}
+
+// paintOverlapping paints the pending rectangles if any of them overlap
+// rect, which is about to be painted.
+func (q *jpegQueue) paintOverlapping(c *ClientConn, rect *Rectangle) error {
+	for _, job := range q.pending {
+		if overlaps(job.rect, rect) {
+			return q.paint(c)
+		}
+	}
+	return nil
+}
+
+// paint waits for each pending rectangle to be decoded, and paints it.
+func (q *jpegQueue) paint(c *ClientConn) error {
+	for i, job := range q.pending {
+		<-job.done
+		putBytes(job.data)
+		if job.err != nil {
+			// The rest won't be painted, but their data can be
+			// reused once they're decoded
+			for _, job := range q.pending[i+1:] {
+				select {
+				case <-job.done:
+					putBytes(job.data)
+				case <-c.done:
+				}
+			}
+			q.pending = q.pending[:0]
+			if _, ok := job.err.(*DecodeError); ok {
+				return job.err
+			}
+			return newDecodeError(job.enc.Type(), job.rect, job.err)
+		}
+		fb, colors := c.framebuffer(job.rect)
+		pix, stride := fb.Lock(job.rect)
+		copyRows(pix, stride, job.decoded, int(job.rect.Width), int(job.rect.Height))
+		fb.Unlock(job.rect)
+		job.enc.Colors = colors
+		q.pending[i] = nil
+	}
+	q.pending = q.pending[:0]
+	return nil
+}
+
+func overlaps(a, b *Rectangle) bool {
+	return int(a.X) < int(b.X)+int(b.Width) && int(b.X) < int(a.X)+int(a.Width) &&
+		int(a.Y) < int(b.Y)+int(b.Height) && int(b.Y) < int(a.Y)+int(a.Height)
+}
@@ -0,0 +1,34 @@
+//go:build cgo && !no_libjpeg
+// +build cgo,!no_libjpeg
+
+package vncclient
+
+import (
+	"bytes"
+
+	"github.com/juju/errors"
+	"github.com/pixiv/go-libjpeg/jpeg"
+)
+
+// Build with the no_libjpeg tag (or without cgo) to decode with
+// image/jpeg instead, where libjpeg-turbo isn't available.
+func init() {
+	jpegDecoder = decodeLibJPEG
+}
+
+// decodeLibJPEG decodes a JFIF stream with libjpeg-turbo. The Colors
+// share the decoded image's memory rather than being copied out of it.
+func decodeLibJPEG(data []byte) ([]Color, int, int, error) {
+	img, err := jpeg.DecodeIntoRGB(bytes.NewReader(data), &jpeg.DecoderOptions{})
+	if err != nil {
+		return nil, 0, 0, err
+	} else if img == nil {
+		return nil, 0, 0, errors.New("jpeg decoding returned nil")
+	}
+	b := img.Bounds()
+	colors, err := NewQuickBuf(img.Pix).ReadColors(b.Dx() * b.Dy())
+	if err != nil {
+		return nil, 0, 0, errors.Annotate(err, "jpeg has too few pixels")
+	}
+	return colors, b.Dx(), b.Dy(), nil
+}
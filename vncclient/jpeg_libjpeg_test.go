@@ -0,0 +1,65 @@
+//go:build cgo && !no_libjpeg
+// +build cgo,!no_libjpeg
+
+package vncclient
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestDecodeJPEG_BackendsAgree(t *testing.T) {
+	// A gradient with a hard edge down it, at a size that isn't a
+	// whole number of MCUs
+	img := image.NewRGBA(image.Rect(0, 0, 67, 45))
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 67; x++ {
+			c := color.RGBA{uint8(x * 3), uint8(y * 5), 128, 255}
+			if x > 40 {
+				c.B = 0
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	lib, lw, lh, err := decodeLibJPEG(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	std, sw, sh, err := decodeStdJPEG(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lw != 67 || lh != 45 || sw != 67 || sh != 45 {
+		t.Fatalf("decoded %dx%d with libjpeg and %dx%d with image/jpeg; expected 67x45", lw, lh, sw, sh)
+	}
+
+	// The decoders round differently, and libjpeg smooths the chroma
+	// it upsamples where image/jpeg doesn't, most of all at the edge
+	var sum, worst int
+	for i := range lib {
+		for _, d := range []int{
+			int(lib[i].R) - int(std[i].R),
+			int(lib[i].G) - int(std[i].G),
+			int(lib[i].B) - int(std[i].B),
+		} {
+			if d < 0 {
+				d = -d
+			}
+			sum += d
+			if d > worst {
+				worst = d
+			}
+		}
+	}
+	if mean := float64(sum) / float64(3*len(lib)); mean > 3 || worst > 32 {
+		t.Errorf("backends differ by %.2f on average and %d at worst", mean, worst)
+	}
+}
@@ -0,0 +1,45 @@
+package vncclient
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// decodeStdJPEG decodes a JFIF stream with image/jpeg, which needs no
+// cgo but is a few times slower than libjpeg-turbo.
+func decodeStdJPEG(data []byte) ([]Color, int, int, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	colors := make([]Color, width*height)
+
+	switch img := img.(type) {
+	case *image.YCbCr:
+		// What a Tight server sends, so worth avoiding the interface
+		// calls of the general case for
+		i := 0
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				yi, ci := img.YOffset(x, y), img.COffset(x, y)
+				r, g, b := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+				colors[i] = Color{r, g, b}
+				i++
+			}
+		}
+	default:
+		i := 0
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+				colors[i] = Color{c.R, c.G, c.B}
+				i++
+			}
+		}
+	}
+	return colors, width, height, nil
+}
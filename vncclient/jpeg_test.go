@@ -0,0 +1,182 @@
+package vncclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// jpegConn is fuzzConn with the given number of JPEG workers, which
+// run until the returned function is called.
+func jpegConn(workers int) (*ClientConn, func()) {
+	c := fuzzConn()
+	c.done = make(chan struct{})
+	c.startJPEGWorkers(workers)
+	return c, func() { close(c.done) }
+}
+
+// tightJPEGRect encodes a width by height gradient, tinted by seed, as a
+// Tight JPEG rectangle at (x, y).
+func tightJPEGRect(x, y, width, height int, seed uint8) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			img.Set(px, py, color.RGBA{uint8(px * 4), uint8(py * 4), seed, 255})
+		}
+	}
+	var data bytes.Buffer
+	jpeg.Encode(&data, img, &jpeg.Options{Quality: 90})
+
+	var b []byte
+	b = binary.BigEndian.AppendUint16(b, uint16(x))
+	b = binary.BigEndian.AppendUint16(b, uint16(y))
+	b = binary.BigEndian.AppendUint16(b, uint16(width))
+	b = binary.BigEndian.AppendUint16(b, uint16(height))
+	b = binary.BigEndian.AppendUint32(b, 7)
+	b = appendCompactLength(append(b, 0x90), data.Len())
+	return append(b, data.Bytes()...)
+}
+
+// jpegUpdate is a FramebufferUpdate of four JPEG rectangles, with a
+// filled one painted over part of the second.
+func jpegUpdate() []byte {
+	update := []byte{0, 0, 5}
+	update = append(update, tightJPEGRect(0, 0, 64, 64, 0)...)
+	update = append(update, tightJPEGRect(64, 0, 64, 64, 50)...)
+	update = append(update, 0, 80, 0, 16, 0, 32, 0, 32, 0, 0, 0, 7, 0x80, 1, 2, 3)
+	update = append(update, tightJPEGRect(0, 64, 64, 64, 100)...)
+	update = append(update, tightJPEGRect(64, 64, 64, 64, 150)...)
+	return update
+}
+
+func TestFramebufferUpdateMessage_JPEGWorkers(t *testing.T) {
+	read := func(workers int) *screen {
+		c, stop := jpegConn(workers)
+		defer stop()
+		fb := newScreen()
+		c.config = &ClientConfig{Framebuffer: fb}
+		if _, err := new(FramebufferUpdateMessage).Read(c, bytes.NewReader(jpegUpdate())); err != nil {
+			t.Fatalf("%d workers: %s", workers, err)
+		}
+		if c.jpegs != nil {
+			t.Errorf("%d workers: JPEG queue left behind", workers)
+		}
+		return fb
+	}
+
+	serial := read(0)
+	if c := serial.pix[20*640+90]; c != (Color{1, 2, 3}) {
+		t.Fatalf("filled rectangle is %v", c)
+	}
+	for _, workers := range []int{1, 4} {
+		if got := read(workers); !reflect.DeepEqual(got.pix, serial.pix) {
+			t.Errorf("%d workers painted differently to the reader alone", workers)
+		}
+	}
+}
+
+func TestFramebufferUpdateMessage_JPEGColors(t *testing.T) {
+	c, stop := jpegConn(2)
+	defer stop()
+	msg, err := new(FramebufferUpdateMessage).Read(c, bytes.NewReader(jpegUpdate()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rect := range msg.(*FramebufferUpdateMessage).Rectangles {
+		checkColors(t, &rect, rect.Enc)
+	}
+}
+
+func TestFramebufferUpdateMessage_JPEGError(t *testing.T) {
+	c, stop := jpegConn(2)
+	defer stop()
+	update := []byte{0, 0, 2}
+	update = append(update, tightJPEGRect(0, 0, 16, 16, 0)...)
+	update = append(update, 0, 0, 0, 16, 0, 16, 0, 16, 0, 0, 0, 7, 0x90, 4, 1, 2, 3, 4)
+	_, err := new(FramebufferUpdateMessage).Read(c, bytes.NewReader(update))
+	e, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("got %T %v; expected a DecodeError", err, err)
+	}
+	if e.Encoding != 7 || e.Rect.Y != 16 {
+		t.Errorf("error is for %+v in encoding %d; expected the second rectangle", e.Rect, e.Encoding)
+	}
+}
+
+func TestTightEncoding_JPEGShortData(t *testing.T) {
+	// The most jpeg-data a compact length can announce, 4MB, but only
+	// a little sent
+	data := append([]byte{0x90, 0xff, 0xff, 0xff}, make([]byte, 1000)...)
+	rect := &Rectangle{Width: 640, Height: 480}
+
+	var err error
+	n := allocated(func() {
+		_, err = (&TightEncoding{}).Read(fuzzConn(), rect, bytes.NewReader(data))
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if n > 1<<20 {
+		t.Errorf("allocated %d bytes for %d sent", n, len(data))
+	}
+}
+
+func TestJPEGQueue_PaintError(t *testing.T) {
+	c := fuzzConn()
+	failed := &jpegJob{rect: &Rectangle{}, enc: &TightEncoding{}, done: make(chan struct{}), err: fmt.Errorf("bad jpeg")}
+	close(failed.done)
+	later := &jpegJob{rect: &Rectangle{}, enc: &TightEncoding{}, data: getBytes(16), done: make(chan struct{})}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(later.done)
+	}()
+
+	// paint mustn't hand back the later job's data while it's still
+	// being decoded
+	q := &jpegQueue{pending: []*jpegJob{failed, later}}
+	if err := q.paint(c); err == nil {
+		t.Fatal("expected an error")
+	}
+	select {
+	case <-later.done:
+	default:
+		t.Error("returned before the later job was decoded")
+	}
+	if len(q.pending) != 0 {
+		t.Errorf("left %d jobs pending", len(q.pending))
+	}
+}
+
+// BenchmarkFramebufferUpdateMessage_JPEG reads updates of four 64x64
+// JPEG rectangles with various numbers of workers.
+func BenchmarkFramebufferUpdateMessage_JPEG(b *testing.B) {
+	quietLogs(b)
+	update := jpegUpdate()
+	for _, workers := range []int{-1, 1, 2, 4} {
+		name := fmt.Sprintf("%dWorkers", workers)
+		if workers < 0 {
+			name = "Reader"
+		}
+		b.Run(name, func(b *testing.B) {
+			c, stop := jpegConn(workers)
+			defer stop()
+			c.config = &ClientConfig{Framebuffer: newScreen()}
+			r := bytes.NewReader(update)
+			b.SetBytes(int64(len(update)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r.Reset(update)
+				if _, err := new(FramebufferUpdateMessage).Read(c, r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
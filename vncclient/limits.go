@@ -0,0 +1,76 @@
+package vncclient
+
+// Limits bounds what a server can make a ClientConn allocate. Each is
+// checked against the length the server announces, before reading or
+// allocating anything. Zero fields take their value from DefaultLimits.
+type Limits struct {
+	// MaxFramebufferWidth and MaxFramebufferHeight bound the desktop
+	// size the server may announce. Rectangles must then fit within
+	// the desktop.
+	MaxFramebufferWidth  int
+	MaxFramebufferHeight int
+
+	// MaxRectangles is the most rectangles a single
+	// FramebufferUpdateMessage may have.
+	MaxRectangles int
+
+	// MaxCompressedBytes is the most compressed data a single
+	// rectangle may carry, and MaxDecompressedBytes the most that
+	// data may inflate to.
+	MaxCompressedBytes   int
+	MaxDecompressedBytes int
+
+	// MaxCutTextBytes is the longest ServerCutTextMessage that is
+	// kept. Longer ones are skipped over and arrive with no Text,
+	// rather than failing the connection.
+	MaxCutTextBytes int
+
+	// MaxDesktopNameBytes is the longest desktop name the server may
+	// announce.
+	MaxDesktopNameBytes int
+
+	// MaxReasonBytes is the longest reason for failing the handshake
+	// that is kept. Longer ones are truncated.
+	MaxReasonBytes int
+}
+
+// DefaultLimits are the Limits used where a ClientConfig leaves them
+// unset. They allow a 5120x2880 desktop to be sent uncompressed as a
+// single ZRLE rectangle.
+var DefaultLimits = Limits{
+	MaxFramebufferWidth:  5120,
+	MaxFramebufferHeight: 2880,
+	MaxRectangles:        1000,
+	MaxCompressedBytes:   64 << 20,
+	MaxDecompressedBytes: 64 << 20,
+	MaxCutTextBytes:      1 << 20,
+	MaxDesktopNameBytes:  4096,
+	MaxReasonBytes:       4096,
+}
+
+// withDefaults fills in l's zero fields from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	pick := func(v *int, def int) {
+		if *v <= 0 {
+			*v = def
+		}
+	}
+	pick(&l.MaxFramebufferWidth, DefaultLimits.MaxFramebufferWidth)
+	pick(&l.MaxFramebufferHeight, DefaultLimits.MaxFramebufferHeight)
+	pick(&l.MaxRectangles, DefaultLimits.MaxRectangles)
+	pick(&l.MaxCompressedBytes, DefaultLimits.MaxCompressedBytes)
+	pick(&l.MaxDecompressedBytes, DefaultLimits.MaxDecompressedBytes)
+	pick(&l.MaxCutTextBytes, DefaultLimits.MaxCutTextBytes)
+	pick(&l.MaxDesktopNameBytes, DefaultLimits.MaxDesktopNameBytes)
+	pick(&l.MaxReasonBytes, DefaultLimits.MaxReasonBytes)
+	return l
+}
+
+// limits returns the Limits in force for c.
+func (c *ClientConn) limits() Limits {
+	var l Limits
+	if c.config != nil {
+		l = c.config.Limits
+	}
+	return l.withDefaults()
+}
@@ -0,0 +1,278 @@
+package vncclient
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestClient_Limits(t *testing.T) {
+	// The mock server announces a 640x480 desktop named "mock"
+	tests := []struct {
+		name   string
+		limits Limits
+	}{
+		{"desktop size", Limits{MaxFramebufferWidth: 320}},
+		{"desktop name", Limits{MaxDesktopNameBytes: 3}},
+	}
+	for _, tt := range tests {
+		_, err := dialMockServer(t, "003.008", &ClientConfig{Limits: tt.limits}, handshake38)
+		if _, ok := err.(*ProtocolError); !ok {
+			t.Errorf("%s: got %T %v, want a ProtocolError", tt.name, err, err)
+		}
+	}
+
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{Limits: Limits{MaxDesktopNameBytes: 4}}, handshake38)
+	if err != nil {
+		t.Fatalf("desktop name at the limit: unexpected error: %s", err)
+	}
+	conn.Close()
+}
+
+func TestClient_LongReason(t *testing.T) {
+	_, err := dialMockServer(t, "003.008", &ClientConfig{Limits: Limits{MaxReasonBytes: 4}}, func(c net.Conn) error {
+		if err := expectVersion(c, "RFB 003.008\n"); err != nil {
+			return err
+		}
+		if _, err := c.Write([]byte{0}); err != nil {
+			return err
+		}
+		return writeReason(c, "go away")
+	})
+	if err == nil || !strings.HasSuffix(err.Error(), ": go a...") {
+		t.Errorf("got %v, want the reason truncated", err)
+	}
+}
+
+// limitsConn is a ClientConn for a 640x480 desktop with the given limits.
+func limitsConn(limits Limits) *ClientConn {
+	c := fuzzConn()
+	c.config = &ClientConfig{Limits: limits}
+	return c
+}
+
+func TestFramebufferUpdateMessage_Limits(t *testing.T) {
+	raw1x1 := []byte{0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 1, 2, 3, 0}
+	twoRects := append(append([]byte{0, 0, 2}, raw1x1...), raw1x1...)
+	if _, err := new(FramebufferUpdateMessage).Read(limitsConn(Limits{MaxRectangles: 2}), bytes.NewReader(twoRects)); err != nil {
+		t.Errorf("rectangles at the limit: unexpected error: %s", err)
+	}
+	_, err := new(FramebufferUpdateMessage).Read(limitsConn(Limits{MaxRectangles: 1}), bytes.NewReader(twoRects))
+	if _, ok := err.(*ProtocolError); !ok {
+		t.Errorf("too many rectangles: got %T %v, want a ProtocolError", err, err)
+	}
+
+	// A 1x1 rectangle just off the right of the desktop
+	offscreen := []byte{0, 0, 1, 0x02, 0x80, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 1, 2, 3, 0}
+	_, err = new(FramebufferUpdateMessage).Read(limitsConn(Limits{}), bytes.NewReader(offscreen))
+	if _, ok := err.(*ProtocolError); !ok {
+		t.Errorf("rectangle off the desktop: got %T %v, want a ProtocolError", err, err)
+	}
+}
+
+func TestZRLEEncoding_Limits(t *testing.T) {
+	// A solid 4x4 tile: 4 bytes that inflate to 4
+	data := zrleData([]byte{1, 10, 20, 30})
+	compressed := len(data) - 4
+	rect := &Rectangle{Width: 4, Height: 4}
+
+	if _, err := (&ZRLEEncoding{}).Read(limitsConn(Limits{MaxCompressedBytes: compressed, MaxDecompressedBytes: 4}), rect, bytes.NewReader(data)); err != nil {
+		t.Errorf("at the limits: unexpected error: %s", err)
+	}
+	for _, limits := range []Limits{{MaxCompressedBytes: compressed - 1}, {MaxDecompressedBytes: 3}} {
+		_, err := (&ZRLEEncoding{}).Read(limitsConn(limits), rect, bytes.NewReader(data))
+		if err == nil || IsRetryable(err) {
+			t.Errorf("%+v: got %v, want a decoding error", limits, err)
+		}
+	}
+
+	// More data than any 4x4 rectangle could inflate to, well within
+	// the limits
+	tooMuch := zrleData(append([]byte{1, 10, 20, 30}, make([]byte, 512)...))
+	_, err := (&ZRLEEncoding{}).Read(limitsConn(Limits{}), rect, bytes.NewReader(tooMuch))
+	if err == nil || IsRetryable(err) {
+		t.Errorf("too much data for the rectangle: got %v, want a decoding error", err)
+	}
+}
+
+// allocated returns how many bytes f allocates.
+func allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestZRLEEncoding_ShortData(t *testing.T) {
+	// 60MB of zlib data announced, within the limits, but only a
+	// little sent
+	data := append([]byte{0x03, 0xc0, 0, 0}, make([]byte, 1000)...)
+	rect := &Rectangle{Width: 640, Height: 480}
+
+	for _, r := range []io.Reader{bytes.NewReader(data), bufio.NewReaderSize(bytes.NewReader(data), 4096)} {
+		var err error
+		n := allocated(func() {
+			_, err = (&ZRLEEncoding{}).Read(limitsConn(Limits{}), rect, r)
+		})
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%T: got %v, want %v", r, err, io.ErrUnexpectedEOF)
+		}
+		if n > 1<<20 {
+			t.Errorf("%T: allocated %d bytes for %d sent", r, n, len(data))
+		}
+	}
+}
+
+// sizedScreen is a Framebuffer of any width.
+type sizedScreen struct {
+	pix   []Color
+	width int
+}
+
+func (s *sizedScreen) Lock(rect *Rectangle) ([]Color, int) {
+	return s.pix[int(rect.Y)*s.width+int(rect.X):], s.width
+}
+
+func (s *sizedScreen) Unlock(rect *Rectangle) {}
+
+func TestZRLEEncoding_LargeRectangle(t *testing.T) {
+	c := limitsConn(Limits{})
+	c.config.Framebuffer = &sizedScreen{pix: make([]Color, 5120*2880), width: 5120}
+
+	// The whole of the largest desktop in solid tiles, which inflate
+	// to a few bytes each
+	rect := &Rectangle{Width: 5120, Height: 2880}
+	data := zrleData(bytes.Repeat([]byte{1, 10, 20, 30}, 80*45))
+	var err error
+	n := allocated(func() {
+		_, err = (&ZRLEEncoding{}).Read(c, rect, bytes.NewReader(data))
+	})
+	if err != nil {
+		t.Fatalf("solid tiles: unexpected error: %s", err)
+	}
+	if n > 1<<20 {
+		t.Errorf("solid tiles: allocated %d bytes to inflate %d", n, 80*45*4)
+	}
+
+	// Raw tiles, which inflate to more than the buffer should keep,
+	// on a stream of their own
+	fb := c.config.Framebuffer
+	c = limitsConn(Limits{})
+	c.config.Framebuffer = fb
+	rect = &Rectangle{Width: 2048, Height: 2048}
+	tile := append([]byte{0}, make([]byte, 64*64*3)...)
+	data = zrleData(bytes.Repeat(tile, 32*32))
+	if _, err := (&ZRLEEncoding{}).Read(c, rect, bytes.NewReader(data)); err != nil {
+		t.Fatalf("raw tiles: unexpected error: %s", err)
+	}
+	if size := c.zrleBuf.Cap(); size > maxKeptBuffer {
+		t.Errorf("raw tiles: kept a %d-byte buffer", size)
+	}
+}
+
+func TestTightEncoding_Limits(t *testing.T) {
+	// CopyFilter on a 4x1 rectangle, so 12 bytes compressed with zlib
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(make([]byte, 12))
+	w.Flush()
+	data := append([]byte{0x00, byte(compressed.Len())}, compressed.Bytes()...)
+	rect := &Rectangle{Width: 4, Height: 1}
+
+	if _, err := (&TightEncoding{}).Read(limitsConn(Limits{MaxCompressedBytes: compressed.Len(), MaxDecompressedBytes: 12}), rect, bytes.NewReader(data)); err != nil {
+		t.Errorf("at the limits: unexpected error: %s", err)
+	}
+	for _, limits := range []Limits{{MaxCompressedBytes: compressed.Len() - 1}, {MaxDecompressedBytes: 11}} {
+		_, err := (&TightEncoding{}).Read(limitsConn(limits), rect, bytes.NewReader(data))
+		if err == nil || IsRetryable(err) {
+			t.Errorf("%+v: got %v, want a decoding error", limits, err)
+		}
+	}
+}
+
+func TestTightEncoding_ShortData(t *testing.T) {
+	// CopyFilter with the most zlib data a compact length can
+	// announce, 4MB, but only a little sent
+	data := append([]byte{0x00, 0xff, 0xff, 0xff}, make([]byte, 1000)...)
+	rect := &Rectangle{Width: 640, Height: 480}
+
+	for _, r := range []io.Reader{bytes.NewReader(data), bufio.NewReaderSize(bytes.NewReader(data), 4096)} {
+		var err error
+		n := allocated(func() {
+			_, err = (&TightEncoding{}).Read(limitsConn(Limits{}), rect, r)
+		})
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%T: got %v, want %v", r, err, io.ErrUnexpectedEOF)
+		}
+		if n > 1<<20 {
+			t.Errorf("%T: allocated %d bytes for %d sent", r, n, len(data))
+		}
+	}
+}
+
+func TestTightEncoding_LargeRectangle(t *testing.T) {
+	// CopyFilter on the largest rectangle Tight allows, but with zlib
+	// data that inflates to far less than it should
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(make([]byte, 100))
+	w.Flush()
+	data := append([]byte{0x00, byte(compressed.Len())}, compressed.Bytes()...)
+	rect := &Rectangle{Width: 2048, Height: 2880}
+
+	var err error
+	n := allocated(func() {
+		_, err = (&TightEncoding{}).Read(limitsConn(Limits{}), rect, bytes.NewReader(data))
+	})
+	if err == nil || IsRetryable(err) {
+		t.Errorf("got %v, want a decoding error", err)
+	}
+	if n > 1<<20 {
+		t.Errorf("allocated %d bytes to inflate 100", n)
+	}
+}
+
+func TestServerCutTextMessage_Limit(t *testing.T) {
+	msg := []byte{0, 0, 0, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o', 2}
+	r := bytes.NewReader(msg)
+	parsed, err := new(ServerCutTextMessage).Read(limitsConn(Limits{MaxCutTextBytes: 4}), r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if text := parsed.(*ServerCutTextMessage).Text; text != "" {
+		t.Errorf("got text %q from an overlong message", text)
+	}
+	// What follows should be left for the next message
+	if rest, _ := ioutil.ReadAll(r); !bytes.Equal(rest, []byte{2}) {
+		t.Errorf("left %v unread, want [2]", rest)
+	}
+
+	parsed, err = new(ServerCutTextMessage).Read(limitsConn(Limits{MaxCutTextBytes: 5}), bytes.NewReader(msg))
+	if err != nil || parsed.(*ServerCutTextMessage).Text != "hello" {
+		t.Errorf("at the limit: got %v, %v", parsed, err)
+	}
+}
+
+func TestLimits_WithDefaults(t *testing.T) {
+	l := Limits{MaxRectangles: 5}.withDefaults()
+	if l.MaxRectangles != 5 {
+		t.Errorf("MaxRectangles = %d, want 5", l.MaxRectangles)
+	}
+	l.MaxRectangles = DefaultLimits.MaxRectangles
+	if l != DefaultLimits {
+		t.Errorf("unset limits = %+v, want %+v", l, DefaultLimits)
+	}
+
+	var c ClientConn
+	if c.limits() != DefaultLimits {
+		t.Error("a ClientConn without a config should use DefaultLimits")
+	}
+}
@@ -0,0 +1,256 @@
+package vncclient
+
+import (
+	"unsafe"
+
+	"github.com/juju/errors"
+)
+
+// A pixelConverter turns pixels sent in a PixelFormat into Colors, with
+// 8 bits each of red, green and blue. ClientConn builds one whenever
+// its PixelFormat changes, and every encoding decodes pixels with it.
+type pixelConverter struct {
+	format PixelFormat
+
+	// size is how many bytes a PIXEL takes, cpixel a ZRLE CPIXEL and
+	// tpixel a Tight TPIXEL. A CPIXEL's value is shifted up by
+	// cpixelShift to make a pixel value.
+	size, cpixel, tpixel int
+	cpixelShift          uint
+
+	// rgbCPixels is set if CPIXELs are bytes of red, green and blue,
+	// as Colors are. 3-byte TPIXELs always are.
+	rgbCPixels bool
+
+	// offsets is where the intensities are in each PIXEL, if fast32
+	// is set; fast565 is set for 16-bit 565 formats.
+	offsets         [3]int
+	fast32, fast565 bool
+
+	// scale maps each of red, green and blue's intensities to 8 bits.
+	scale [3][]uint8
+
+	// colorMap is the connection's color map, which formats without
+	// true color index.
+	colorMap *[256]Color
+}
+
+func newPixelConverter(format *PixelFormat, colorMap *[256]Color) (*pixelConverter, error) {
+	if format.BPP != 8 && format.BPP != 16 && format.BPP != 32 {
+		return nil, errors.Errorf("unsupported bitsPerPixel: %d", format.BPP)
+	}
+	p := &pixelConverter{format: *format, colorMap: colorMap, size: int(format.BPP / 8)}
+	p.cpixel, p.tpixel = p.size, p.size
+	if !format.TrueColor {
+		return p, nil
+	}
+
+	maxes, shifts := p.maxes(), p.shifts()
+	for i, max := range maxes {
+		p.scale[i] = make([]uint8, int(max)+1)
+		for v := 1; v <= int(max); v++ {
+			p.scale[i][v] = uint8((v*255 + int(max)/2) / int(max))
+		}
+	}
+
+	// See RFC 6143 Section 7.7.6 for when CPIXELs are 3 bytes
+	if format.BPP == 32 && format.Depth <= 24 {
+		var bits uint64
+		for i := range maxes {
+			bits |= uint64(maxes[i]) << shifts[i]
+		}
+		if bits < 1<<24 {
+			p.cpixel = 3
+		} else if bits < 1<<32 && bits&0xff == 0 {
+			p.cpixel, p.cpixelShift = 3, 8
+		}
+	}
+	eight := maxes == [3]uint16{255, 255, 255}
+	if p.cpixel == 3 && eight {
+		p.rgbCPixels = true
+		for i, shift := range shifts {
+			offset := (int(shift) - int(p.cpixelShift)) / 8
+			if format.BigEndian {
+				offset = 2 - offset
+			}
+			p.rgbCPixels = p.rgbCPixels && shift%8 == 0 && offset == i
+		}
+	}
+	if format.BPP == 32 && format.Depth == 24 && eight {
+		p.tpixel = 3
+	}
+
+	p.offsets, p.fast32 = rawOffsets32(format)
+	p.fast565 = is565(format)
+	return p, nil
+}
+
+func (p *pixelConverter) maxes() [3]uint16 {
+	return [3]uint16{p.format.RedMax, p.format.GreenMax, p.format.BlueMax}
+}
+
+func (p *pixelConverter) shifts() [3]uint8 {
+	return [3]uint8{p.format.RedShift, p.format.GreenShift, p.format.BlueShift}
+}
+
+// pixels converts the PIXELs at the start of src into dst.
+func (p *pixelConverter) pixels(dst []Color, src []byte) error {
+	if p.fast32 {
+		rawRow32(dst, src, p.offsets)
+		return nil
+	} else if p.fast565 {
+		p.row565(dst, src)
+		return nil
+	}
+	return p.convert(dst, src, p.size, 0)
+}
+
+// cpixels converts the CPIXELs at the start of src into dst.
+func (p *pixelConverter) cpixels(dst []Color, src []byte) error {
+	if p.rgbCPixels {
+		copyRGB(dst, src)
+		return nil
+	} else if p.cpixel == p.size {
+		return p.pixels(dst, src)
+	}
+	return p.convert(dst, src, p.cpixel, p.cpixelShift)
+}
+
+// tpixels converts the TPIXELs at the start of src into dst.
+func (p *pixelConverter) tpixels(dst []Color, src []byte) error {
+	if p.tpixel == 3 {
+		copyRGB(dst, src)
+		return nil
+	}
+	return p.pixels(dst, src)
+}
+
+// convert converts the size-byte pixels at the start of src into dst,
+// shifting their values up by shift first.
+func (p *pixelConverter) convert(dst []Color, src []byte, size int, shift uint) error {
+	src = src[:size*len(dst)]
+	for i := range dst {
+		c, err := p.color(p.value(src[size*i:size*i+size]) << shift)
+		if err != nil {
+			return err
+		}
+		dst[i] = c
+	}
+	return nil
+}
+
+// value reads the pixel value in b, in the format's byte order.
+func (p *pixelConverter) value(b []byte) uint32 {
+	var v uint32
+	if p.format.BigEndian {
+		for _, x := range b {
+			v = v<<8 | uint32(x)
+		}
+	} else {
+		for i := len(b) - 1; i >= 0; i-- {
+			v = v<<8 | uint32(b[i])
+		}
+	}
+	return v
+}
+
+func (p *pixelConverter) color(v uint32) (Color, error) {
+	if !p.format.TrueColor {
+		if v >= uint32(len(p.colorMap)) {
+			return Color{}, errors.Errorf("pixel value %d is outside the %d-entry color map", v, len(p.colorMap))
+		}
+		return p.colorMap[v], nil
+	}
+	return p.scaled(p.intensities(v)), nil
+}
+
+// intensities splits a true color pixel value into red, green and blue.
+func (p *pixelConverter) intensities(v uint32) [3]uint16 {
+	f := &p.format
+	return [3]uint16{
+		uint16(v>>f.RedShift) & f.RedMax,
+		uint16(v>>f.GreenShift) & f.GreenMax,
+		uint16(v>>f.BlueShift) & f.BlueMax,
+	}
+}
+
+// tintensities splits the TPIXEL at the start of b into red, green and
+// blue.
+func (p *pixelConverter) tintensities(b []byte) [3]uint16 {
+	if p.tpixel == 3 {
+		return [3]uint16{uint16(b[0]), uint16(b[1]), uint16(b[2])}
+	}
+	return p.intensities(p.value(b[:p.size]))
+}
+
+// scaled makes a Color of true color intensities.
+func (p *pixelConverter) scaled(i [3]uint16) Color {
+	return Color{p.scale[0][i[0]], p.scale[1][i[1]], p.scale[2][i[2]]}
+}
+
+// rawOffsets32 reports where in each of format's pixels the red, green
+// and blue bytes are, if it's a 32-bit true color format with 8-bit
+// channels on byte boundaries, such as RGBX or BGRX.
+func rawOffsets32(format *PixelFormat) (offsets [3]int, ok bool) {
+	if format.BPP != 32 || !format.TrueColor ||
+		format.RedMax != 255 || format.GreenMax != 255 || format.BlueMax != 255 {
+		return offsets, false
+	}
+	for i, shift := range []uint8{format.RedShift, format.GreenShift, format.BlueShift} {
+		if shift%8 != 0 || shift > 24 {
+			return offsets, false
+		}
+		offsets[i] = int(shift / 8)
+		if format.BigEndian {
+			offsets[i] = 3 - offsets[i]
+		}
+	}
+	return offsets, true
+}
+
+// rawRow32 converts the pixels at the start of src, laid out as
+// rawOffsets32 found, into dst.
+func rawRow32(dst []Color, src []byte, offsets [3]int) {
+	src = src[:4*len(dst)]
+	// Masking lets the compiler drop the bounds checks
+	r, g, b := offsets[0]&3, offsets[1]&3, offsets[2]&3
+	for i := range dst {
+		p := (*[4]byte)(src[4*i:])
+		dst[i] = Color{p[r], p[g], p[b]}
+	}
+}
+
+// is565 reports whether format is 16-bit true color with 5 bits of
+// red, 6 of green and 5 of blue, from the top bit down.
+func is565(format *PixelFormat) bool {
+	return format.BPP == 16 && format.TrueColor &&
+		format.RedMax == 31 && format.GreenMax == 63 && format.BlueMax == 31 &&
+		format.RedShift == 11 && format.GreenShift == 5 && format.BlueShift == 0
+}
+
+// row565 converts the 565 pixels at the start of src into dst.
+func (p *pixelConverter) row565(dst []Color, src []byte) {
+	src = src[:2*len(dst)]
+	lo, hi := 0, 1
+	if p.format.BigEndian {
+		lo, hi = 1, 0
+	}
+	// As arrays, the lookups need no bounds checks
+	red, green, blue := (*[32]uint8)(p.scale[0]), (*[64]uint8)(p.scale[1]), (*[32]uint8)(p.scale[2])
+	for i := range dst {
+		b := (*[2]byte)(src[2*i:])
+		v := uint16(b[lo&1]) | uint16(b[hi&1])<<8
+		dst[i] = Color{red[v>>11], green[v>>5&63], blue[v&31]}
+	}
+}
+
+// copyRGB copies the bytes of red, green and blue at the start of src
+// into dst.
+func copyRGB(dst []Color, src []byte) {
+	if len(dst) == 0 {
+		return
+	}
+	src = src[:colorSize*len(dst)]
+	// As QuickBuf.ReadColors does
+	copy(dst, (*[(1 << 31) / colorSize]Color)(unsafe.Pointer(&src[0]))[:len(dst):len(dst)])
+}
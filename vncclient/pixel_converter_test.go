@@ -0,0 +1,300 @@
+package vncclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	rgb332 = PixelFormat{
+		BPP:        8,
+		Depth:      8,
+		TrueColor:  true,
+		RedMax:     7,
+		GreenMax:   7,
+		BlueMax:    3,
+		RedShift:   5,
+		GreenShift: 2,
+	}
+	colorMap8 = PixelFormat{BPP: 8, Depth: 8}
+)
+
+// testColorMap has a few entries set, as SetColorMapEntries would.
+func testColorMap() *[256]Color {
+	var m [256]Color
+	m[1] = Color{1, 2, 3}
+	m[200] = Color{4, 5, 6}
+	m[255] = Color{255, 255, 255}
+	return &m
+}
+
+func TestPixelConverter_Pixels(t *testing.T) {
+	tests := []struct {
+		name   string
+		format PixelFormat
+		src    []byte
+		want   []Color
+	}{
+		{"rgb332", rgb332, []byte{0xff, 0, 0x89}, []Color{{255, 255, 255}, {}, {146, 73, 85}}},
+		{"555", PixelFormat{BPP: 16, Depth: 15, TrueColor: true, RedMax: 31, GreenMax: 31, BlueMax: 31, RedShift: 10, GreenShift: 5},
+			[]byte{0xff, 0x7f, 0x10, 0x42}, []Color{{255, 255, 255}, {132, 132, 132}}},
+		{"565 big endian", PixelFormat{BPP: 16, Depth: 16, BigEndian: true, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5},
+			[]byte{0xf8, 0x00, 0x07, 0xe0}, []Color{{255, 0, 0}, {0, 255, 0}}},
+		{"30 bit", PixelFormat{BPP: 32, Depth: 30, TrueColor: true, RedMax: 1023, GreenMax: 1023, BlueMax: 1023, RedShift: 20, GreenShift: 10},
+			[]byte{0xff, 0xff, 0xff, 0x3f, 0x00, 0x00, 0xf0, 0x3f}, []Color{{255, 255, 255}, {255, 0, 0}}},
+		{"color map", colorMap8, []byte{1, 200, 0}, []Color{{1, 2, 3}, {4, 5, 6}, {}}},
+	}
+	for _, tt := range tests {
+		p, err := newPixelConverter(&tt.format, testColorMap())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		got := make([]Color, len(tt.want))
+		if err := p.pixels(got, tt.src); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPixelConverter_CPixels(t *testing.T) {
+	eight := func(bigEndian bool, r, g, b uint8) PixelFormat {
+		return PixelFormat{BPP: 32, Depth: 24, BigEndian: bigEndian, TrueColor: true,
+			RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: r, GreenShift: g, BlueShift: b}
+	}
+	rgb, bgr := []Color{{0x10, 0x20, 0x30}}, []Color{{0x30, 0x20, 0x10}}
+	tests := []struct {
+		name   string
+		format PixelFormat
+		cpixel int
+		want   []Color
+	}{
+		{"RGBX", eight(false, 0, 8, 16), 3, rgb},
+		{"BGRX", eight(false, 16, 8, 0), 3, bgr},
+		{"XRGB", eight(false, 8, 16, 24), 3, rgb},
+		{"XRGB big endian", eight(true, 16, 8, 0), 3, rgb},
+		{"RGBX big endian", eight(true, 24, 16, 8), 3, rgb},
+		{"XBGR big endian", eight(true, 0, 8, 16), 3, bgr},
+		{"565", PixelFormat{BPP: 16, Depth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}, 2, []Color{{33, 0, 132}}},
+		{"color map", colorMap8, 1, []Color{{}}},
+	}
+	for _, tt := range tests {
+		p, err := newPixelConverter(&tt.format, testColorMap())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.name, err)
+		}
+		if p.cpixel != tt.cpixel {
+			t.Errorf("%s: CPIXELs are %d bytes, want %d", tt.name, p.cpixel, tt.cpixel)
+			continue
+		}
+		got := make([]Color, 1)
+		if err := p.cpixels(got, []byte{0x10, 0x20, 0x30}); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Channels wider than 8 bits need all 4 bytes
+	wide := PixelFormat{BPP: 32, Depth: 30, TrueColor: true, RedMax: 1023, GreenMax: 1023, BlueMax: 1023, RedShift: 20, GreenShift: 10}
+	if p, _ := newPixelConverter(&wide, testColorMap()); p.cpixel != 4 {
+		t.Errorf("30 bit: CPIXELs are %d bytes, want 4", p.cpixel)
+	}
+}
+
+func TestPixelConverter_TPixels(t *testing.T) {
+	p, _ := newPixelConverter(&fuzzConn().PixelFormat, testColorMap())
+	got := make([]Color, 1)
+	if p.tpixel != 3 {
+		t.Fatalf("TPIXELs are %d bytes, want 3", p.tpixel)
+	} else if p.tpixels(got, []byte{1, 2, 3}); got[0] != (Color{1, 2, 3}) {
+		t.Errorf("got %v, want {1 2 3}", got[0])
+	}
+
+	p, _ = newPixelConverter(&rgb332, testColorMap())
+	if p.tpixel != 1 {
+		t.Errorf("rgb332: TPIXELs are %d bytes, want 1", p.tpixel)
+	}
+}
+
+func TestPixelConverter_Errors(t *testing.T) {
+	if _, err := newPixelConverter(&PixelFormat{BPP: 24, TrueColor: true}, testColorMap()); err == nil {
+		t.Error("24 bits per pixel: expected an error")
+	}
+
+	// A 16 bit color map format can name entries past the end
+	p, err := newPixelConverter(&PixelFormat{BPP: 16, Depth: 16}, testColorMap())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.pixels(make([]Color, 1), []byte{0, 1}); err == nil {
+		t.Error("pixel value 256: expected an error")
+	}
+}
+
+func TestSetColorMapEntriesMessage_Read(t *testing.T) {
+	c := fuzzConn()
+	msg := []byte{0, 0, 1, 0, 2, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0xff, 0xff, 0, 0, 0x80, 0}
+	parsed, err := new(SetColorMapEntriesMessage).Read(c, bytes.NewReader(msg))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Each U16 intensity is cut down to its top 8 bits
+	want := []Color{{0x11, 0x33, 0x55}, {0xff, 0, 0x80}}
+	if got := parsed.(*SetColorMapEntriesMessage).Colors; !reflect.DeepEqual(got, want) {
+		t.Errorf("got colors %v, want %v", got, want)
+	}
+	if got := c.ColorMap[1:3]; !reflect.DeepEqual(got, want) {
+		t.Errorf("color map has %v, want %v", got, want)
+	}
+}
+
+func TestZRLEEncoding_PixelFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		format PixelFormat
+		tiles  []byte
+		want   Color
+	}{
+		// A solid 4x4 tile each time
+		{"565", PixelFormat{BPP: 16, Depth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5},
+			[]byte{1, 0x00, 0xf8}, Color{255, 0, 0}},
+		{"rgb332", rgb332, []byte{1, 0x1c}, Color{0, 255, 0}},
+		{"color map", colorMap8, []byte{1, 200}, Color{4, 5, 6}},
+	}
+	rect := &Rectangle{Width: 4, Height: 4}
+	for _, tt := range tests {
+		c := fuzzConn()
+		c.PixelFormat = tt.format
+		c.ColorMap = *testColorMap()
+		enc, err := new(ZRLEEncoding).Read(c, rect, bytes.NewReader(zrleData(tt.tiles)))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+			continue
+		}
+		for i, got := range enc.(*ZRLEEncoding).Colors {
+			if got != tt.want {
+				t.Errorf("%s: pixel %d is %v, want %v", tt.name, i, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// A palette tile in a color map format: two CPIXELs, then a bit for
+	// each pixel
+	c := fuzzConn()
+	c.PixelFormat = colorMap8
+	c.ColorMap = *testColorMap()
+	tiles := []byte{2, 1, 200, 0xa0, 0x50, 0xa0, 0x50}
+	enc, err := new(ZRLEEncoding).Read(c, rect, bytes.NewReader(zrleData(tiles)))
+	if err != nil {
+		t.Fatalf("palette: unexpected error: %s", err)
+	}
+	a, b := Color{1, 2, 3}, Color{4, 5, 6}
+	want := []Color{b, a, b, a, a, b, a, b, b, a, b, a, a, b, a, b}
+	if got := enc.(*ZRLEEncoding).Colors; !reflect.DeepEqual(got, want) {
+		t.Errorf("palette: got %v, want %v", got, want)
+	}
+}
+
+func TestTightEncoding_ColorMap(t *testing.T) {
+	c := fuzzConn()
+	c.PixelFormat = colorMap8
+	c.ColorMap = *testColorMap()
+	rect := &Rectangle{Width: 4, Height: 1}
+	a, b := Color{1, 2, 3}, Color{4, 5, 6}
+
+	tests := []struct {
+		name string
+		data []byte
+		want []Color
+	}{
+		{"fill", []byte{0x80, 200}, []Color{b, b, b, b}},
+		// Four TPIXELs, too few to be compressed
+		{"copy", []byte{0x00, 1, 200, 1, 255}, []Color{a, b, a, {255, 255, 255}}},
+		// Two TPIXELs, then a bit for each pixel
+		{"palette", []byte{0x40, 1, 1, 1, 200, 0x50}, []Color{a, b, a, b}},
+	}
+	for _, tt := range tests {
+		enc, err := new(TightEncoding).Read(c, rect, bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err)
+		} else if got := enc.(*TightEncoding).Colors; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// There's nothing to take a gradient of
+	if _, err := new(TightEncoding).Read(c, rect, bytes.NewReader([]byte{0x40, 2, 0, 0, 0, 0})); err == nil {
+		t.Error("gradient: expected an error")
+	}
+}
+
+func TestTightEncoding_Gradient565(t *testing.T) {
+	c := fuzzConn()
+	c.PixelFormat = PixelFormat{BPP: 16, Depth: 16, TrueColor: true, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5}
+	rect := &Rectangle{Width: 2, Height: 2}
+
+	// Every pixel is full red. The first is predicted to be black, so
+	// sends all of red; the second, and the third below it, are
+	// predicted right, so send nothing; the last is predicted as red +
+	// red - red and so needs nothing either.
+	data := []byte{0x40, 2, 0x00, 0xf8, 0, 0, 0, 0, 0, 0}
+	enc, err := new(TightEncoding).Read(c, rect, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	red := Color{255, 0, 0}
+	if got, want := enc.(*TightEncoding).Colors, []Color{red, red, red, red}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// TestClientConn_SetPixelFormat switches a connection to an 8-bit color
+// map format and checks updates the server sends afterwards are read
+// in it.
+func TestClientConn_SetPixelFormat(t *testing.T) {
+	script := func(c net.Conn) error {
+		if err := handshake38(c); err != nil {
+			return err
+		}
+		var setPixelFormat [20]byte
+		if _, err := io.ReadFull(c, setPixelFormat[:]); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		// SetColorMapEntries for entries 3 and 4
+		buf.Write([]byte{1, 0, 0, 3, 0, 2})
+		binary.Write(&buf, binary.BigEndian, []uint16{0x1000, 0x2000, 0x3000, 0xff00, 0xff00, 0xff00})
+		// A 2x1 Raw update using them
+		buf.Write([]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 1, 0, 0, 0, 0, 3, 4})
+		_, err := c.Write(buf.Bytes())
+		return err
+	}
+	messages := make(chan ServerMessage, 2)
+	conn, err := dialMockServer(t, "003.008", &ClientConfig{ServerMessageCh: messages}, script)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetPixelFormat(&colorMap8); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-messages
+	update, ok := (<-messages).(*FramebufferUpdateMessage)
+	if !ok {
+		t.Fatal("expected a FramebufferUpdate")
+	}
+	want := []Color{{0x10, 0x20, 0x30}, {0xff, 0xff, 0xff}}
+	if got := update.Rectangles[0].Enc.(*RawEncoding).Colors; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -1,7 +1,6 @@
 package vncclient
 
 import (
-	"bytes"
 	"encoding/binary"
 	"io"
 )
@@ -23,129 +22,50 @@ type PixelFormat struct {
 }
 
 func ReadPixelFormat(r io.Reader, result *PixelFormat) error {
-	var rawPixelFormat [16]byte
-	if _, err := io.ReadFull(r, rawPixelFormat[:]); err != nil {
+	raw, err := next(r, 16)
+	if err != nil {
 		return err
 	}
 
-	var pfBoolByte uint8
-	brPF := bytes.NewReader(rawPixelFormat[:])
-	if err := binary.Read(brPF, binary.BigEndian, &result.BPP); err != nil {
-		return err
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &result.Depth); err != nil {
-		return err
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &pfBoolByte); err != nil {
-		return err
-	}
-
-	if pfBoolByte != 0 {
-		// Big endian is true
-		result.BigEndian = true
-	}
-
-	if err := binary.Read(brPF, binary.BigEndian, &pfBoolByte); err != nil {
-		return err
-	}
-
-	if pfBoolByte != 0 {
-		// True Color is true. So we also have to read all the color max & shifts.
-		result.TrueColor = true
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.RedMax); err != nil {
-			return err
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.GreenMax); err != nil {
-			return err
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.BlueMax); err != nil {
-			return err
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.RedShift); err != nil {
-			return err
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.GreenShift); err != nil {
-			return err
-		}
-
-		if err := binary.Read(brPF, binary.BigEndian, &result.BlueShift); err != nil {
-			return err
-		}
+	result.BPP = raw[0]
+	result.Depth = raw[1]
+	result.BigEndian = raw[2] != 0
+	result.TrueColor = raw[3] != 0
+
+	// Only true color formats have color maxes and shifts.
+	if result.TrueColor {
+		result.RedMax = binary.BigEndian.Uint16(raw[4:])
+		result.GreenMax = binary.BigEndian.Uint16(raw[6:])
+		result.BlueMax = binary.BigEndian.Uint16(raw[8:])
+		result.RedShift = raw[10]
+		result.GreenShift = raw[11]
+		result.BlueShift = raw[12]
 	}
 
 	return nil
 }
 
 func WritePixelFormat(format *PixelFormat) ([]byte, error) {
-	var buf bytes.Buffer
-
-	// Byte 1
-	if err := binary.Write(&buf, binary.BigEndian, format.BPP); err != nil {
-		return nil, err
-	}
-
-	// Byte 2
-	if err := binary.Write(&buf, binary.BigEndian, format.Depth); err != nil {
-		return nil, err
-	}
-
-	var boolByte byte
-	if format.BigEndian {
-		boolByte = 1
-	} else {
-		boolByte = 0
-	}
-
-	// Byte 3 (BigEndian)
-	if err := binary.Write(&buf, binary.BigEndian, boolByte); err != nil {
-		return nil, err
-	}
-
-	if format.TrueColor {
-		boolByte = 1
-	} else {
-		boolByte = 0
-	}
+	return appendPixelFormat(make([]byte, 0, 16), format), nil
+}
 
-	// Byte 4 (TrueColor)
-	if err := binary.Write(&buf, binary.BigEndian, boolByte); err != nil {
-		return nil, err
-	}
+// appendPixelFormat appends the 16 byte wire form of format to b.
+func appendPixelFormat(b []byte, format *PixelFormat) []byte {
+	b = append(b, format.BPP, format.Depth)
+	b = appendBool(b, format.BigEndian)
+	b = appendBool(b, format.TrueColor)
 
 	// If we have true color enabled then we have to fill in the rest of the
 	// structure with the color values.
 	if format.TrueColor {
-		if err := binary.Write(&buf, binary.BigEndian, format.RedMax); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.GreenMax); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.BlueMax); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.RedShift); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.GreenShift); err != nil {
-			return nil, err
-		}
-
-		if err := binary.Write(&buf, binary.BigEndian, format.BlueShift); err != nil {
-			return nil, err
-		}
+		b = appendU16(b, format.RedMax)
+		b = appendU16(b, format.GreenMax)
+		b = appendU16(b, format.BlueMax)
+		b = append(b, format.RedShift, format.GreenShift, format.BlueShift)
+	} else {
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0, 0)
 	}
 
-	return buf.Bytes()[0:16], nil
+	// Padding
+	return append(b, 0, 0, 0)
 }
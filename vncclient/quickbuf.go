@@ -2,7 +2,6 @@ package vncclient
 
 import (
 	"io"
-	"reflect"
 	"unsafe"
 )
 
@@ -23,30 +22,39 @@ func (b *QuickBuf) ReadByte() (byte, error) {
 	return o, nil
 }
 
+// Next returns the next n bytes, which share b's memory.
+func (b *QuickBuf) Next(n int) ([]byte, error) {
+	if n < 0 || n > b.Len() {
+		return nil, io.EOF
+	}
+	p := b.buf[b.off : b.off+n]
+	b.off += n
+	return p, nil
+}
+
 func (b *QuickBuf) ReadColors(n int) ([]Color, error) {
-	skip := colorSize * n
-	if b.off+skip > len(b.buf) {
+	if n < 0 || n > b.Len()/colorSize {
 		return nil, io.EOF
 	}
+	if n == 0 {
+		// There may be no b.buf[b.off] to point to
+		return []Color{}, nil
+	}
+	skip := colorSize * n
 	ptr := unsafe.Pointer(&b.buf[b.off])
 
-	// raw := b.buf[b.off : b.off+skip]
-
 	// Convert memory into a Color slice without copying
 	// (https://github.com/golang/go/issues/13656#issuecomment-165618599)
-	h := reflect.SliceHeader{
-		Data: uintptr(ptr),
-		Len:  n,
-		Cap:  n,
-	}
-	colors := *(*[]Color)(unsafe.Pointer(&h))
-	// colors := (*[(1 << 31) / colorSize]Color)(ptr)[:n:n]
+	colors := (*[(1 << 31) / colorSize]Color)(ptr)[:n:n]
 
 	b.off += skip
 	return colors, nil
 }
 
 func (b *QuickBuf) ReadColor() (Color, error) {
+	if b.Len() < colorSize {
+		return Color{}, io.EOF
+	}
 	c := Color{
 		R: b.buf[b.off],
 		G: b.buf[b.off+1],
@@ -3,8 +3,7 @@ package vncclient
 import (
 	"encoding/binary"
 	"io"
-	"reflect"
-	"time"
+	"io/ioutil"
 
 	"github.com/juju/errors"
 )
@@ -43,22 +42,24 @@ func (*FramebufferUpdateMessage) Type() uint8 {
 	return 0
 }
 
-func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
-	start := time.Now().UnixNano()
-
-	// Read off the padding
-	var padding [1]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
+func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (msg ServerMessage, err error) {
+	// Read off the padding, then the number of rectangles
+	header, err := next(r, 3)
+	if err != nil {
 		return nil, err
 	}
+	numRects := binary.BigEndian.Uint16(header[1:])
+	limits := c.limits()
+	if int(numRects) > limits.MaxRectangles {
+		return nil, &ProtocolError{errors.Errorf("%d rectangles exceeds limit of %d", numRects, limits.MaxRectangles)}
+	}
 
-	var numRects uint16
-	if err := binary.Read(r, binary.BigEndian, &numRects); err != nil {
-		return nil, err
+	if c.config != nil {
+		if fb, ok := c.config.Framebuffer.(UpdateFramebuffer); ok {
+			fb.BeginUpdate()
+			defer func() { fb.EndUpdate(err) }()
+		}
 	}
-    if numRects > 1000 {
-        return nil, errors.Errorf("excessive rectangle count %d", int(numRects));
-    }
 
 	// Build the map of encodings supported
 	encMap := make(map[int32]Encoding)
@@ -74,59 +75,68 @@ func (*FramebufferUpdateMessage) Read(c *ClientConn, r io.Reader) (ServerMessage
 	// zrleEnc := new(ZRLEEncoding)
 	// encMap[zrleEnc.Type()] = zrleEnc
 
+	// Tight JPEG rectangles are decoded alongside the rest of the
+	// update, to be painted in turn before it's done
+	jpegs := &jpegQueue{}
+	c.jpegs = jpegs
+	defer func() { c.jpegs = nil }()
+
 	rects := make([]Rectangle, numRects)
 	for i := uint16(0); i < numRects; i++ {
-		var encodingType int32
-
-		rect := &rects[i]
-		data := []interface{}{
-			&rect.X,
-			&rect.Y,
-			&rect.Width,
-			&rect.Height,
-			&encodingType,
+		header, err := next(r, 12)
+		if err != nil {
+			return nil, err
 		}
 
-		for _, val := range data {
-			if err := binary.Read(r, binary.BigEndian, val); err != nil {
-				return nil, err
-			}
+		rect := &rects[i]
+		rect.X = binary.BigEndian.Uint16(header[0:])
+		rect.Y = binary.BigEndian.Uint16(header[2:])
+		rect.Width = binary.BigEndian.Uint16(header[4:])
+		rect.Height = binary.BigEndian.Uint16(header[6:])
+		encodingType := int32(binary.BigEndian.Uint32(header[8:]))
+
+		// Defend against corrupt rectangles before the encoding
+		// readers allocate memory for them.
+		if int(rect.X)+int(rect.Width) > int(c.FramebufferWidth) || int(rect.Y)+int(rect.Height) > int(c.FramebufferHeight) {
+			return nil, &ProtocolError{errors.Errorf("%dx%d rectangle at (%d, %d) in encoding %v doesn't fit in the %dx%d framebuffer", rect.Width, rect.Height, rect.X, rect.Y, encodingType, c.FramebufferWidth, c.FramebufferHeight)}
 		}
 
-        // Defend against corrupt rectangles before we try to allocate memory.
-        // In the encoding readers we compute int(Width) * int(Height), which will overflow if Width*Height >= (1<<31)
-        if int(rect.X) > 5120 || int(rect.Y) > 2880 || int(rect.Width) > 5120 || int(rect.Height) > 2880 {
-            return nil, errors.Errorf("excessive rectangle origin %dx%d size %dx%d encoding %v", int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height), encodingType);
-        }
-
 		enc, ok := encMap[encodingType]
 		if !ok {
-			return nil, errors.Errorf("unsupported encoding type: %v", encodingType)
+			return nil, &UnsupportedEncodingError{Encoding: encodingType}
 		}
 
-		var err error
-		rect.Enc, err = enc.Read(c, rect, r)
-		if err != nil {
+		if err := jpegs.paintOverlapping(c, rect); err != nil {
+			return nil, err
+		}
+		rect.Enc, err = readRect(c, enc, rect, r)
+		if err != nil && !isIOError(err) {
+			if _, ok := err.(*DecodeError); !ok {
+				err = newDecodeError(encodingType, rect, err)
+			}
+			return nil, err
+		} else if err != nil {
 			return nil, err
 		}
 	}
-
-	var bytes int
-	types := map[string]int{}
-	for _, rect := range rects {
-		bytes += rect.Enc.Size()
-		t := reflect.TypeOf(rect.Enc).String()
-		types[t]++
-	}
-
-	delta := (time.Now().UnixNano() - start) / 1000 / 1000
-	if delta > 1 {
-		// log.Infof("Time to parse framebuffer update message: %vms (bytes: %v, rects: %v)", delta, bytes, types)
+	if err := jpegs.paint(c); err != nil {
+		return nil, err
 	}
 
 	return &FramebufferUpdateMessage{rects}, nil
 }
 
+// readRect decodes rect with enc, turning a panic from a decoder that
+// trusted the server too far into a DecodeError.
+func readRect(c *ClientConn, enc Encoding, rect *Rectangle, r io.Reader) (result Encoding, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, err = nil, PanicError(v, enc.Type(), rect)
+		}
+	}()
+	return enc.Read(c, rect, r)
+}
+
 // SetColorMapEntriesMessage is sent by the server to set values into
 // the color map. This message will automatically update the color map
 // for the associated connection, but contains the color change data
@@ -143,40 +153,35 @@ func (*SetColorMapEntriesMessage) Type() uint8 {
 }
 
 func (*SetColorMapEntriesMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
-	// Read off the padding
-	var padding [1]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
+	// Read off the padding, then the first color and number of colors
+	header, err := next(r, 5)
+	if err != nil {
 		return nil, err
 	}
 
 	var result SetColorMapEntriesMessage
-	if err := binary.Read(r, binary.BigEndian, &result.FirstColor); err != nil {
-		return nil, err
+	result.FirstColor = binary.BigEndian.Uint16(header[1:])
+	numColors := binary.BigEndian.Uint16(header[3:])
+
+	if int(result.FirstColor)+int(numColors) > len(c.ColorMap) {
+		return nil, &ProtocolError{errors.Errorf("%d colors starting at %d overflow the %d-entry color map", numColors, result.FirstColor, len(c.ColorMap))}
 	}
 
-	var numColors uint16
-	if err := binary.Read(r, binary.BigEndian, &numColors); err != nil {
+	// Each color is a U16 each of red, green and blue
+	raw, err := next(r, 6*int(numColors))
+	if err != nil {
 		return nil, err
 	}
 
 	result.Colors = make([]Color, numColors)
-	for i := uint16(0); i < numColors; i++ {
-
+	for i := range result.Colors {
 		color := &result.Colors[i]
-		data := []interface{}{
-			&color.R,
-			&color.G,
-			&color.B,
-		}
-
-		for _, val := range data {
-			if err := binary.Read(r, binary.BigEndian, val); err != nil {
-				return nil, err
-			}
-		}
+		color.R = raw[6*i]
+		color.G = raw[6*i+2]
+		color.B = raw[6*i+4]
 
 		// Update the connection's color map
-		c.ColorMap[result.FirstColor+i] = *color
+		c.ColorMap[int(result.FirstColor)+i] = *color
 	}
 
 	return &result, nil
@@ -207,19 +212,23 @@ func (*ServerCutTextMessage) Type() uint8 {
 }
 
 func (*ServerCutTextMessage) Read(c *ClientConn, r io.Reader) (ServerMessage, error) {
-	// Read off the padding
-	var padding [3]byte
-	if _, err := io.ReadFull(r, padding[:]); err != nil {
+	// Read off the padding, then the length
+	header, err := next(r, 7)
+	if err != nil {
 		return nil, err
 	}
+	textLength := binary.BigEndian.Uint32(header[3:])
 
-	var textLength uint32
-	if err := binary.Read(r, binary.BigEndian, &textLength); err != nil {
-		return nil, err
+	if int64(textLength) > int64(c.limits().MaxCutTextBytes) {
+		// Not worth failing the connection over
+		if _, err := io.CopyN(ioutil.Discard, r, int64(textLength)); err != nil {
+			return nil, err
+		}
+		return &ServerCutTextMessage{}, nil
 	}
 
-	textBytes := make([]uint8, textLength)
-	if err := binary.Read(r, binary.BigEndian, &textBytes); err != nil {
+	textBytes, err := readBytes(r, int(textLength))
+	if err != nil {
 		return nil, err
 	}
 
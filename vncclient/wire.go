@@ -0,0 +1,96 @@
+package vncclient
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// RFB's wire format is big-endian throughout. These read and append its
+// types by hand, rather than through encoding/binary's reflection. Given
+// the ClientConn's *bufio.Reader, the readers don't allocate either.
+
+// next consumes the next n bytes from r and returns them. When r is a
+// *bufio.Reader, they are only valid until r is next read.
+func next(r io.Reader, n int) ([]byte, error) {
+	if br, ok := r.(*bufio.Reader); ok && n <= br.Size() {
+		// On error, consume what there was, as io.ReadFull would
+		b, err := br.Peek(n)
+		br.Discard(len(b))
+		if err != nil {
+			if err == io.EOF && len(b) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return b, nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readU8(r io.Reader) (uint8, error) {
+	if br, ok := r.(io.ByteReader); ok {
+		return br.ReadByte()
+	}
+	b, err := next(r, 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func readU16(r io.Reader) (uint16, error) {
+	b, err := next(r, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func readU32(r io.Reader) (uint32, error) {
+	b, err := next(r, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func appendU16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// byteReader returns r as an io.ByteReader, wrapping it if need be.
+func byteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return byteIOReader{Reader: r}
+}
+
+// bufferedConn is a net.Conn whose reads go through the ClientConn's
+// reader, so that a ClientAuth can't miss data that is already
+// buffered there.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,244 @@
+package vncclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	for _, r := range []io.Reader{
+		bufio.NewReaderSize(strings.NewReader("abcdef"), 16),
+		strings.NewReader("abcdef"),
+	} {
+		b, err := next(r, 4)
+		if err != nil || string(b) != "abcd" {
+			t.Errorf("%T: got %q, %v; expected \"abcd\"", r, b, err)
+		}
+		if _, err := next(r, 4); err != io.ErrUnexpectedEOF {
+			t.Errorf("%T: got %v reading past the end; expected io.ErrUnexpectedEOF", r, err)
+		}
+		if _, err := next(r, 4); err != io.EOF {
+			t.Errorf("%T: got %v reading at the end; expected io.EOF", r, err)
+		}
+	}
+
+	// Larger than the buffer
+	r := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 100)), 16)
+	if b, err := next(r, 100); err != nil || len(b) != 100 {
+		t.Errorf("got %d bytes, %v; expected 100", len(b), err)
+	}
+}
+
+func TestReadUint(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2, 3, 4, 5, 6, 7})
+	if v, err := readU8(r); err != nil || v != 1 {
+		t.Errorf("readU8: got %v, %v", v, err)
+	}
+	if v, err := readU16(r); err != nil || v != 0x0203 {
+		t.Errorf("readU16: got %#x, %v", v, err)
+	}
+	if v, err := readU32(r); err != nil || v != 0x04050607 {
+		t.Errorf("readU32: got %#x, %v", v, err)
+	}
+	if _, err := readU8(r); err != io.EOF {
+		t.Errorf("readU8 at the end: got %v; expected io.EOF", err)
+	}
+}
+
+func TestAppendUint(t *testing.T) {
+	b := appendBool(appendU32(appendU16(nil, 0x0102), 0x03040506), true)
+	if expected := []byte{1, 2, 3, 4, 5, 6, 1}; !bytes.Equal(b, expected) {
+		t.Errorf("got %v; expected %v", b, expected)
+	}
+}
+
+func TestPixelFormat_RoundTrip(t *testing.T) {
+	pf := PixelFormat{
+		BPP:        32,
+		Depth:      24,
+		BigEndian:  true,
+		TrueColor:  true,
+		RedMax:     255,
+		GreenMax:   1023,
+		BlueMax:    255,
+		RedShift:   16,
+		GreenShift: 8,
+	}
+	b, err := WritePixelFormat(&pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 16 {
+		t.Fatalf("wrote %d bytes; expected 16", len(b))
+	}
+	var got PixelFormat
+	if err := ReadPixelFormat(bytes.NewReader(b), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, pf) {
+		t.Errorf("got %+v; expected %+v", got, pf)
+	}
+}
+
+// scriptConn is a net.Conn that plays back a server's side of a
+// connection, and counts the calls made on it. Once the script runs
+// out, reads block until Close.
+type scriptConn struct {
+	r      *bytes.Reader
+	reads  int64
+	writes int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newScriptConn(script []byte) *scriptConn {
+	return &scriptConn{r: bytes.NewReader(script), closed: make(chan struct{})}
+}
+
+func (c *scriptConn) Read(p []byte) (int, error) {
+	atomic.AddInt64(&c.reads, 1)
+	if c.r.Len() == 0 {
+		<-c.closed
+		return 0, io.EOF
+	}
+	return c.r.Read(p)
+}
+
+func (c *scriptConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return len(p), nil
+}
+
+func (c *scriptConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *scriptConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (c *scriptConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (c *scriptConn) SetDeadline(t time.Time) error      { return nil }
+func (c *scriptConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *scriptConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// BenchmarkClientConn_Update measures the round trip of requesting and
+// reading a FramebufferUpdate of ten small raw rectangles, reporting
+// the reads and writes made on the net.Conn for each.
+func BenchmarkClientConn_Update(b *testing.B) {
+	const rects, size = 10, 8
+
+	var script bytes.Buffer
+	script.WriteString("RFB 003.008\n")
+	script.Write([]byte{1, 1})                         // security types: None
+	binary.Write(&script, binary.BigEndian, uint32(0)) // SecurityResult
+	binary.Write(&script, binary.BigEndian, [2]uint16{640, 480})
+	pf, _ := WritePixelFormat(&PixelFormat{
+		BPP:        32,
+		Depth:      24,
+		TrueColor:  true,
+		RedMax:     255,
+		GreenMax:   255,
+		BlueMax:    255,
+		RedShift:   16,
+		GreenShift: 8,
+	})
+	script.Write(pf)
+	binary.Write(&script, binary.BigEndian, uint32(len("bench")))
+	script.WriteString("bench")
+
+	var update bytes.Buffer
+	update.Write([]byte{0, 0})
+	binary.Write(&update, binary.BigEndian, uint16(rects))
+	for i := 0; i < rects; i++ {
+		binary.Write(&update, binary.BigEndian, [4]uint16{uint16(i * size), 0, size, size})
+		binary.Write(&update, binary.BigEndian, int32(0))
+		update.Write(make([]byte, size*size*4))
+	}
+	for i := 0; i < b.N; i++ {
+		script.Write(update.Bytes())
+	}
+
+	nc := newScriptConn(script.Bytes())
+	msgs := make(chan ServerMessage)
+	conn, err, _ := Client(nc, &ClientConfig{ServerMessageCh: msgs})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+
+	reads, writes := atomic.LoadInt64(&nc.reads), atomic.LoadInt64(&nc.writes)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := conn.FramebufferUpdateRequest(true, 0, 0, 640, 480); err != nil {
+			b.Fatal(err)
+		}
+		<-msgs
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(atomic.LoadInt64(&nc.reads)-reads)/float64(b.N), "reads/op")
+	b.ReportMetric(float64(atomic.LoadInt64(&nc.writes)-writes)/float64(b.N), "writes/op")
+}
+
+func TestClientConn_CutTextLatin1(t *testing.T) {
+	var sent bytes.Buffer
+	c := &ClientConn{c: newScriptConn(nil), config: &ClientConfig{}, w: bufio.NewWriter(&sent)}
+
+	if err := c.CutText("café"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{6, 0, 0, 0, 0, 0, 0, 4, 'c', 'a', 'f', 0xe9}; !bytes.Equal(sent.Bytes(), expected) {
+		t.Errorf("sent %v; expected %v", sent.Bytes(), expected)
+	}
+}
+
+// writeRecorder keeps what each call to Write is given.
+type writeRecorder struct {
+	writes [][]byte
+}
+
+func (w *writeRecorder) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestClientConn_SendEvents(t *testing.T) {
+	key := []byte{4, 1, 0, 0, 0, 0, 0, 0x61}
+	pointer := []byte{5, 1, 0, 10, 0, 20}
+	for _, flush := range []bool{false, true} {
+		var sent writeRecorder
+		c := &ClientConn{c: newScriptConn(nil), config: &ClientConfig{FlushEachEvent: flush}, w: bufio.NewWriter(&sent)}
+
+		var batch EventBatch
+		batch.KeyEvent(0x61, true)
+		batch.PointerEvent(ButtonLeft, 10, 20)
+		batch.KeyEvent(0x61, true)
+		if err := c.SendEvents(&batch); err != nil {
+			t.Fatal(err)
+		}
+		if !batch.Empty() {
+			t.Errorf("FlushEachEvent %v: batch not emptied", flush)
+		}
+		if err := c.SendEvents(&batch); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := [][]byte{key, pointer, key}
+		if !flush {
+			expected = [][]byte{bytes.Join(expected, nil)}
+		}
+		if !reflect.DeepEqual(sent.writes, expected) {
+			t.Errorf("FlushEachEvent %v: wrote %v; expected %v", flush, sent.writes, expected)
+		}
+	}
+}
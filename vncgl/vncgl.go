@@ -34,6 +34,17 @@ func colorsToImage(x, y, width, height uint16, colors []vncclient.Color) *image.
 	return rgba
 }
 
+// screenToImage is colorsToImage for a rectangle of a whole screen of
+// the given width.
+func screenToImage(x, y, width, height, screenWidth uint16, screen []vncclient.Color) *image.RGBA {
+	colors := make([]vncclient.Color, 0, int(width)*int(height))
+	for row := int(y); row < int(y)+int(height); row++ {
+		start := row*int(screenWidth) + int(x)
+		colors = append(colors, screen[start:start+int(width)]...)
+	}
+	return colorsToImage(x, y, width, height, colors)
+}
+
 func SetupRendering() {
 	setup.Do(func() {
 		if err := glfw.Init(); err != nil {
@@ -91,7 +102,10 @@ func (g *VNCGL) Close() error {
 	return nil
 }
 
-func (g *VNCGL) Apply(updates []*vncclient.FramebufferUpdateMessage) {
+// Apply copies the updated rectangles to the window's texture: from
+// screen, if the updates were painted into one, or else from their
+// encodings' own Colors.
+func (g *VNCGL) Apply(screen []vncclient.Color, updates []*vncclient.FramebufferUpdateMessage) {
 	start := time.Now().UnixNano()
 	count := 0
 
@@ -100,15 +114,19 @@ func (g *VNCGL) Apply(updates []*vncclient.FramebufferUpdateMessage) {
 			count += 1
 
 			var rgba *image.RGBA
-			switch enc := rect.Enc.(type) {
-			case *vncclient.RawEncoding:
-				rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
-			case *vncclient.ZRLEEncoding:
-				rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
-			case *vncclient.TightEncoding:
-				rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
-			default:
-				panic(errors.Errorf("BUG: unrecognized encoding: %+v", enc))
+			if screen != nil {
+				rgba = screenToImage(rect.X, rect.Y, rect.Width, rect.Height, g.windowWidth, screen)
+			} else {
+				switch enc := rect.Enc.(type) {
+				case *vncclient.RawEncoding:
+					rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
+				case *vncclient.ZRLEEncoding:
+					rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
+				case *vncclient.TightEncoding:
+					rgba = colorsToImage(rect.X, rect.Y, rect.Width, rect.Height, enc.Colors)
+				default:
+					panic(errors.Errorf("BUG: unrecognized encoding: %+v", enc))
+				}
 			}
 
 			g.applyImage(rgba)
@@ -301,7 +319,7 @@ func connect(imgs chan *image.RGBA) (*vncclient.ClientConn, error) {
 			select {
 			case msg := <-updates:
 				msgs := []*vncclient.FramebufferUpdateMessage{msg}
-				vncgl.Apply(msgs)
+				vncgl.Apply(nil, msgs)
 			default:
 				done = true
 			}